@@ -0,0 +1,465 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/apiServer.go
+// Original timestamp: 2026/10/18 23:55
+
+// cm serve : an HTTP API over the PKI, for the clients that cannot run cm themselves
+// Clients authenticate with a bearer token; each token is limited to some environments, domains and operations (apiTokens.go)
+// The requests go through the library API (pki.go), in the environment named in the request path
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"encoding/json"
+	"errors"
+	"github.com/jwalton/gchalk"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ServeListen = "127.0.0.1:8443"
+var ServeTokensFile = ""
+var ServeTLSCert = ""
+var ServeTLSKey = ""
+var ServeInsecure = false
+
+// The largest request body we accept (a certificate config, or a CSR)
+const apiMaxBody = 1024 * 1024
+
+// Names coming from the clients end up in file paths: environments, certificates and issuers
+var apiNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// apiServer : the tokens, the private keys' passphrase and the PKCS#11 PIN, read at startup
+type apiServer struct {
+	tokens     []apiToken
+	passphrase []byte
+	pin        []byte
+}
+
+// apiHandler : an endpoint, called once the token is checked and the environment opened
+// It returns the HTTP status and the payload: a []byte is sent as PEM, anything else as JSON
+type apiHandler func(req *http.Request, token apiToken, pki *CA) (int, interface{}, error)
+
+// apiError : the body of an error response
+type apiError struct {
+	Error string `json:"Error"`
+}
+
+// apiCertificate : a certificate, as returned by the API; the private key is only sent when the certificate is issued
+type apiCertificate struct {
+	Config         CertificateStruct `json:"Config"`
+	X509           *X509Details      `json:"X509,omitempty"`
+	CertificatePEM string            `json:"CertificatePEM,omitempty"`
+	ChainPEM       string            `json:"ChainPEM,omitempty"`
+	PrivateKeyPEM  string            `json:"PrivateKeyPEM,omitempty"`
+}
+
+// ServeAPI :
+// Runs the HTTP API until it fails
+// Endpoints, {env} being an environment name, without .json :
+// POST   /v1/{env}/certificates        issues a certificate from a JSON certificate config, or signs a CSR (application/pkcs10)
+// GET    /v1/{env}/certificates        lists the certificates the token may see
+// GET    /v1/{env}/certificates/{name} fetches a certificate and its chain (JSON, or PEM with ?format=pem)
+// DELETE /v1/{env}/certificates/{name} revokes a certificate (?reason=keyCompromise...)
+// GET    /v1/{env}/ca                  downloads the CA bundle (all CAs), or the chain of a CA with ?issuer=NAME
+func ServeAPI() error {
+	var err error
+	server := &apiServer{}
+
+	if ServeTokensFile == "" {
+		return helpers.CustomError{Message: "You need to provide the tokens file with --tokens"}
+	}
+	if server.tokens, err = loadAPITokens(ServeTokensFile); err != nil {
+		return err
+	}
+	if (ServeTLSCert == "") != (ServeTLSKey == "") {
+		return helpers.CustomError{Message: "Both --tls-cert and --tls-key are needed to serve over HTTPS"}
+	}
+	if err = checkListenAddress(ServeListen, ServeTLSCert != "", ServeInsecure); err != nil {
+		return err
+	}
+	// The error messages are sent to the clients, and the log is not a terminal
+	gchalk.SetLevel(gchalk.LevelNone)
+
+	// Encrypted keys need their passphrase, and PKCS#11 tokens their PIN: we get them now, as there is nobody to prompt
+	// Once the requests come in
+	if err = server.loadSecrets(); err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Addr: ServeListen, Handler: server.routes(), ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout: time.Minute, WriteTimeout: 5 * time.Minute}
+	if ServeTLSCert != "" {
+		log.Printf("API listening on %s (HTTPS), %d token(s)", ServeListen, len(server.tokens))
+		return httpServer.ListenAndServeTLS(ServeTLSCert, ServeTLSKey)
+	}
+	log.Printf("API listening on %s (plain HTTP: the tokens travel in clear text), %d token(s)", ServeListen, len(server.tokens))
+	return httpServer.ListenAndServe()
+}
+
+// checkListenAddress() : the tokens and the private keys of the issued certificates only travel in clear text over
+// The loopback interface, unless --insecure says otherwise
+func checkListenAddress(listen string, tls bool, insecure bool) error {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return helpers.CustomError{Message: "Invalid listen address " + helpers.Red(listen) + ": " + err.Error()}
+	}
+	if tls || insecure {
+		return nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return helpers.CustomError{Message: "Refusing to serve over plain HTTP on " + helpers.Red(listen) + ": the tokens and private keys would travel in clear text. " +
+		"Use --tls-cert and --tls-key, listen on a loopback address, or pass --insecure"}
+}
+
+// routes() : the endpoints of the API, each with the operation the token must be allowed
+func (s *apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/{env}/certificates", s.handle(apiIssue, s.issue))
+	mux.HandleFunc("GET /v1/{env}/certificates", s.handle(apiList, s.list))
+	mux.HandleFunc("GET /v1/{env}/certificates/{name}", s.handle(apiFetch, s.fetch))
+	mux.HandleFunc("DELETE /v1/{env}/certificates/{name}", s.handle(apiRevoke, s.revoke))
+	mux.HandleFunc("GET /v1/{env}/ca", s.handle(apiBundle, s.bundle))
+	return mux
+}
+
+// loadSecrets() : reads (or prompts for) the passphrase if one of the environments of the tokens encrypts its keys, and
+// The PIN if one of them has a PKCS#11 token whose PIN is to be prompted for
+func (s *apiServer) loadSecrets() error {
+	envnames := []string{}
+
+	for _, token := range s.tokens {
+		for _, envname := range token.Environments {
+			if envname != "*" {
+				envnames = append(envnames, envname)
+				continue
+			}
+			envfiles, _ := filepath.Glob(filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", "*.json"))
+			for _, envfile := range envfiles {
+				if !strings.HasPrefix(filepath.Base(envfile), "sample") {
+					envnames = append(envnames, filepath.Base(envfile))
+				}
+			}
+		}
+	}
+
+	for _, envname := range envnames {
+		env, err := environment.ReadEnvironmentFile(envname)
+		if err != nil {
+			continue
+		}
+		if env.KeyEncryptionPolicy() != environment.KeyEncryptionNone && s.passphrase == nil {
+			if s.passphrase, err = keyPassphrase(false); err != nil {
+				return err
+			}
+		}
+		if env.PKCS11 != nil && env.PKCS11.PINSource() == environment.PINFromPrompt && s.pin == nil {
+			if s.pin, err = tokenPINPrompt(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keyPassphrase() : the Options.Passphrase of the environments; nobody is prompted while serving
+func (s *apiServer) keyPassphrase(confirm bool) ([]byte, error) {
+	if s.passphrase == nil {
+		return nil, helpers.CustomError{Message: "The private key is encrypted, and no passphrase was provided when the server started", Err: ErrPassphrase}
+	}
+	return s.passphrase, nil
+}
+
+// tokenPIN() : the Options.PIN of the environments; nobody is prompted while serving
+func (s *apiServer) tokenPIN() ([]byte, error) {
+	if s.pin == nil {
+		return nil, helpers.CustomError{Message: "The token PIN is to be prompted for, and none was provided when the server started", Err: ErrToken}
+	}
+	return s.pin, nil
+}
+
+// handle() : authenticates the request, checks the permissions of the token, then calls the endpoint with the environment opened
+// Requests are logged
+func (s *apiServer) handle(operation string, handler apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var token *apiToken
+		var status int
+		var payload interface{}
+		var err error
+
+		tokenName := "-"
+		defer func() {
+			log.Printf("%s %s %s %s: %d", req.RemoteAddr, tokenName, req.Method, req.URL.Path, status)
+		}()
+
+		bearer, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token = authenticate(s.tokens, strings.TrimSpace(bearer)); !found || token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="certificateManager"`)
+			status = http.StatusUnauthorized
+			writeAPIResponse(w, status, apiError{Error: "Missing or invalid token"})
+			return
+		}
+		tokenName = token.Name
+
+		envname := req.PathValue("env")
+		switch {
+		case !token.allows(operation):
+			status, err = http.StatusForbidden, helpers.CustomError{Message: "Token " + token.Name + " is not allowed to " + operation}
+		case !apiNameRegexp.MatchString(envname) || !token.allowsEnvironment(envname):
+			status, err = http.StatusForbidden, helpers.CustomError{Message: "Token " + token.Name + " is not allowed to use environment " + envname}
+		default:
+			status, payload, err = s.serve(handler, req, *token, envname)
+		}
+		if err != nil {
+			log.Printf("%s %s: %v", req.RemoteAddr, tokenName, err)
+			writeAPIResponse(w, status, apiError{Error: err.Error()})
+			return
+		}
+		writeAPIResponse(w, status, payload)
+	}
+}
+
+// serve() : opens the requested environment on behalf of the token, and runs the endpoint
+func (s *apiServer) serve(handler apiHandler, req *http.Request, token apiToken, envname string) (int, interface{}, error) {
+	envname = strings.TrimSuffix(envname, ".json") + ".json"
+	env, err := environment.ReadEnvironmentFile(envname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, nil, helpers.CustomError{Message: "Unknown environment " + strings.TrimSuffix(envname, ".json")}
+		}
+		return http.StatusInternalServerError, nil, err
+	}
+	pki, err := Open(env, Options{Name: envname, Client: token.Name, KeySize: CertPKsize, Passphrase: s.keyPassphrase, PIN: s.tokenPIN})
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return handler(req, token, pki)
+}
+
+// apiStatus() : the HTTP status matching the kind of error returned by the library, or the fallback status
+func apiStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidCertificate), errors.Is(err, ErrInvalidCSR), errors.Is(err, ErrInvalidReason),
+		errors.Is(err, ErrUnknownIssuer):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, ErrLocked):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrPassphrase), errors.Is(err, ErrToken), errors.Is(err, ErrInvalidEnvironment):
+		return http.StatusInternalServerError
+	}
+	return fallback
+}
+
+// writeAPIResponse() : sends the payload as PEM if raw bytes, or JSON
+func writeAPIResponse(w http.ResponseWriter, status int, payload interface{}) {
+	if pemData, ok := payload.([]byte); ok {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.WriteHeader(status)
+		w.Write(pemData)
+		return
+	}
+	jStream, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		status = http.StatusInternalServerError
+		jStream, _ = json.Marshal(apiError{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(jStream, '\n'))
+}
+
+// issue() : POST /v1/{env}/certificates
+// A JSON body is a certificate config, as in the config files; an application/pkcs10 body is a CSR (PEM or DER),
+// With the certificate name, issuer, profile and duration given in the query string
+func (s *apiServer) issue(req *http.Request, token apiToken, pki *CA) (int, interface{}, error) {
+	var certconfig CertificateStruct
+	var issued *Issued
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, apiMaxBody))
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/json", "":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&certconfig); err != nil {
+			return http.StatusBadRequest, nil, helpers.CustomError{Message: "Invalid certificate config: " + err.Error()}
+		}
+		// The certificate name may be derived from the common name: we need it now, to check it
+		if err = certconfig.complete(pki.env, true); err != nil {
+			return apiStatus(err, http.StatusBadRequest), nil, err
+		}
+		if status, err := checkAPICertificate(token, certconfig, pki); err != nil {
+			return status, nil, err
+		}
+		if issued, err = pki.Issue(req.Context(), certconfig); err != nil {
+			return apiStatus(err, http.StatusInternalServerError), nil, err
+		}
+
+	case "application/pkcs10", "application/x-pem-file":
+		csrRequest, err := parseCSR(body)
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+		query := req.URL.Query()
+		certconfig.CertificateName = query.Get("name")
+		certconfig.Issuer = query.Get("issuer")
+		certconfig.Profile = query.Get("profile")
+		if duration := query.Get("duration"); duration != "" {
+			if certconfig.Duration, err = strconv.Atoi(duration); err != nil || certconfig.Duration < 1 {
+				return http.StatusBadRequest, nil, helpers.CustomError{Message: "Invalid duration: " + duration}
+			}
+		}
+		// SignCSR merges the CSR again, which changes nothing: we need the names now, to check them
+		certconfig.mergeCSR(csrRequest)
+		if status, err := checkAPICertificate(token, certconfig, pki); err != nil {
+			return status, nil, err
+		}
+		if issued, err = pki.SignCSR(req.Context(), body, certconfig); err != nil {
+			return apiStatus(err, http.StatusInternalServerError), nil, err
+		}
+
+	default:
+		return http.StatusUnsupportedMediaType, nil, helpers.CustomError{Message: "Unsupported content type " + mediaType +
+			" (valid types are application/json and application/pkcs10)"}
+	}
+
+	log.Printf("token %s: certificate %s issued in %s", token.Name, issued.Config.CertificateName, pki.options.Name)
+	return http.StatusCreated, newAPICertificate(issued), nil
+}
+
+// list() : GET /v1/{env}/certificates
+func (s *apiServer) list(req *http.Request, token apiToken, pki *CA) (int, interface{}, error) {
+	records, err := pki.List(req.Context())
+	if err != nil {
+		return apiStatus(err, http.StatusInternalServerError), nil, err
+	}
+
+	allowed := []CertificateRecord{}
+	for _, record := range records {
+		if token.allowsCertificate(record.Config) == nil {
+			allowed = append(allowed, record)
+		}
+	}
+	return http.StatusOK, allowed, nil
+}
+
+// fetch() : GET /v1/{env}/certificates/{name}
+func (s *apiServer) fetch(req *http.Request, token apiToken, pki *CA) (int, interface{}, error) {
+	certconfig, status, err := loadAPICertificate(req, token, pki)
+	if err != nil {
+		return status, nil, err
+	}
+	issued, err := pki.Get(req.Context(), certconfig.CertificateName)
+	if err != nil {
+		return apiStatus(err, http.StatusInternalServerError), nil, err
+	}
+	response := newAPICertificate(issued)
+	if req.URL.Query().Get("format") == "pem" {
+		return http.StatusOK, []byte(response.CertificatePEM + response.ChainPEM), nil
+	}
+	return http.StatusOK, response, nil
+}
+
+// revoke() : DELETE /v1/{env}/certificates/{name}
+// The files are never removed through the API
+func (s *apiServer) revoke(req *http.Request, token apiToken, pki *CA) (int, interface{}, error) {
+	certconfig, status, err := loadAPICertificate(req, token, pki)
+	if err != nil {
+		return status, nil, err
+	}
+	if err = pki.Revoke(req.Context(), certconfig.CertificateName, req.URL.Query().Get("reason")); err != nil {
+		return apiStatus(err, http.StatusInternalServerError), nil, err
+	}
+	issued, err := pki.Get(req.Context(), certconfig.CertificateName)
+	if err != nil {
+		return apiStatus(err, http.StatusInternalServerError), nil, err
+	}
+	log.Printf("token %s: certificate %s revoked in %s", token.Name, certconfig.CertificateName, pki.options.Name)
+	return http.StatusOK, newAPICertificate(issued), nil
+}
+
+// bundle() : GET /v1/{env}/ca
+func (s *apiServer) bundle(req *http.Request, token apiToken, pki *CA) (int, interface{}, error) {
+	issuer := req.URL.Query().Get("issuer")
+	if issuer != "" && !apiNameRegexp.MatchString(issuer) {
+		return http.StatusBadRequest, nil, helpers.CustomError{Message: "Invalid issuer name: " + issuer}
+	}
+	bundle, err := pki.Bundle(req.Context(), issuer)
+	if err != nil {
+		if errors.Is(err, ErrUnknownIssuer) {
+			return http.StatusNotFound, nil, err
+		}
+		return apiStatus(err, http.StatusInternalServerError), nil, err
+	}
+	return http.StatusOK, encodeCertificatesPEM(bundle), nil
+}
+
+// checkAPICertificate() : the names of a certificate to be issued must be safe to use as file names, and allowed for the token
+// The name must also be a new one: issuing would overwrite the config, certificate and key of the existing certificate,
+// Which might belong to another token
+func checkAPICertificate(token apiToken, c CertificateStruct, pki *CA) (int, error) {
+	if !apiNameRegexp.MatchString(c.CertificateName) {
+		return http.StatusBadRequest, helpers.CustomError{Message: "Invalid certificate name: " + c.CertificateName}
+	}
+	if c.Issuer != "" && !apiNameRegexp.MatchString(c.Issuer) {
+		return http.StatusBadRequest, helpers.CustomError{Message: "Invalid issuer name: " + c.Issuer}
+	}
+	if err := token.allowsCertificate(c); err != nil {
+		return http.StatusForbidden, err
+	}
+	if _, err := loadCertificateConfig(pki.env, c.CertificateName); !errors.Is(err, ErrNotFound) {
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusConflict, helpers.CustomError{Message: "A certificate named " + c.CertificateName + " already exists", Err: ErrDuplicate}
+	}
+	return 0, nil
+}
+
+// loadAPICertificate() : loads the config of the certificate named in the request path, if the token may see it
+func loadAPICertificate(req *http.Request, token apiToken, pki *CA) (CertificateStruct, int, error) {
+	name := strings.TrimSuffix(req.PathValue("name"), ".json")
+	if !apiNameRegexp.MatchString(name) {
+		return CertificateStruct{}, http.StatusBadRequest, helpers.CustomError{Message: "Invalid certificate name: " + name}
+	}
+	certconfig, err := loadCertificateConfig(pki.env, name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return CertificateStruct{}, http.StatusNotFound, helpers.CustomError{Message: "Unknown certificate " + name, Err: ErrNotFound}
+		}
+		return CertificateStruct{}, http.StatusInternalServerError, err
+	}
+	if err = token.allowsCertificate(certconfig); err != nil {
+		return CertificateStruct{}, http.StatusForbidden, err
+	}
+	return certconfig, 0, nil
+}
+
+// newAPICertificate() : the certificate, its chain, and its private key if the library returned it
+func newAPICertificate(issued *Issued) apiCertificate {
+	details := newX509Details(issued.Certificate)
+	return apiCertificate{Config: issued.Config, X509: &details, CertificatePEM: string(issued.CertificatePEM),
+		ChainPEM: string(encodeCertificatesPEM(issued.Chain)), PrivateKeyPEM: string(issued.PrivateKeyPEM)}
+}
@@ -0,0 +1,207 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/apiServer_test.go
+// Original timestamp: 2026/10/19 10:05
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAPIServer() : cm serve over two environments, prod (with a root CA) and dev, for three tokens:
+// Alice and Bob may do everything in prod, for their own domains; the auditor may only list and fetch, in any environment
+func testAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configDir := filepath.Join(home, ".config", "certificatemanager")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var prod environment.EnvironmentStruct
+	for _, envname := range []string{"prod", "dev"} {
+		root := filepath.Join(home, envname)
+		env := environment.EnvironmentStruct{CertificateRootDir: root, RootCAdir: filepath.Join(root, "rootCA"), ServerCertsDir: filepath.Join(root, "servers"),
+			CertificatesConfigDir: filepath.Join(root, "conf"), RemoveDuplicates: true, KeyEncryption: environment.KeyEncryptionNone}
+		jStream, err := json.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(configDir, envname+".json"), jStream, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if envname == "prod" {
+			prod = env
+		}
+	}
+	pki, err := Open(prod, Options{Name: "prod.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pki.Issue(context.Background(), CertificateStruct{CertificateName: "rootca", CommonName: "Root CA", Country: "CA", Province: "QC",
+		Locality: "Montreal", Organization: "cm", IsCA: true, Profile: "ca", KeyAlgorithm: "ecdsa-p256", Duration: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func(secret string) string {
+		sum := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(sum[:])
+	}
+	server := &apiServer{tokens: []apiToken{
+		{Name: "alice", SHA256: hash("alice-secret"), Environments: []string{"prod"}, Domains: []string{"*.example.com"}},
+		{Name: "bob", SHA256: hash("bob-secret"), Environments: []string{"prod"}, Domains: []string{"*.example.org"}},
+		{Name: "auditor", SHA256: hash("auditor-secret"), Environments: []string{"*"}, Domains: []string{"*"}, Permissions: []string{apiList, apiFetch}},
+	}}
+	// The requests are logged, as cm serve does, but not in the test output
+	log.SetOutput(io.Discard)
+	httpServer := httptest.NewServer(server.routes())
+	t.Cleanup(func() {
+		httpServer.Close()
+		log.SetOutput(os.Stderr)
+	})
+	return httpServer
+}
+
+// apiRequest() : sends the request with the token's secret, and returns the status and body of the response
+func apiRequest(t *testing.T, server *httptest.Server, secret string, method string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(response)
+}
+
+// A token only reaches its environments, its operations and the certificates within its domains,
+// And cannot take over the name of a certificate that is not its own
+func TestAPIServeAuthorization(t *testing.T) {
+	server := testAPIServer(t)
+	www := `{"CertificateName": "www", "CommonName": "www.example.com", "DNSNames": ["www.example.com"], "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`
+
+	tests := []struct {
+		name   string
+		secret string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"no token", "", http.MethodGet, "/v1/prod/certificates", "", http.StatusUnauthorized},
+		{"unknown token", "mallory-secret", http.MethodGet, "/v1/prod/certificates", "", http.StatusUnauthorized},
+		{"issue", "alice-secret", http.MethodPost, "/v1/prod/certificates", www, http.StatusCreated},
+		{"issue again", "alice-secret", http.MethodPost, "/v1/prod/certificates", www, http.StatusConflict},
+		{"issue over another token's certificate", "bob-secret", http.MethodPost, "/v1/prod/certificates",
+			`{"CertificateName": "www", "CommonName": "www.example.org", "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`, http.StatusConflict},
+		{"issue outside the domains", "alice-secret", http.MethodPost, "/v1/prod/certificates",
+			`{"CertificateName": "api", "CommonName": "api.example.org", "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`, http.StatusForbidden},
+		{"issue a CA", "alice-secret", http.MethodPost, "/v1/prod/certificates",
+			`{"CertificateName": "subca", "CommonName": "ca.example.com", "IsCA": true, "Profile": "ca", "KeyAlgorithm": "ecdsa-p256"}`, http.StatusForbidden},
+		{"issue under an unsafe name", "alice-secret", http.MethodPost, "/v1/prod/certificates",
+			`{"CertificateName": "../www", "CommonName": "www.example.com", "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`, http.StatusBadRequest},
+		{"issue without the permission", "auditor-secret", http.MethodPost, "/v1/prod/certificates", www, http.StatusForbidden},
+		{"other environment", "alice-secret", http.MethodGet, "/v1/dev/certificates", "", http.StatusForbidden},
+		{"unknown environment", "auditor-secret", http.MethodGet, "/v1/qa/certificates", "", http.StatusNotFound},
+		{"fetch", "alice-secret", http.MethodGet, "/v1/prod/certificates/www", "", http.StatusOK},
+		{"fetch another token's certificate", "bob-secret", http.MethodGet, "/v1/prod/certificates/www", "", http.StatusForbidden},
+		{"fetch an unknown certificate", "alice-secret", http.MethodGet, "/v1/prod/certificates/nothing", "", http.StatusNotFound},
+		{"fetch with any domain", "auditor-secret", http.MethodGet, "/v1/prod/certificates/www", "", http.StatusOK},
+		{"revoke another token's certificate", "bob-secret", http.MethodDelete, "/v1/prod/certificates/www", "", http.StatusForbidden},
+		{"revoke without the permission", "auditor-secret", http.MethodDelete, "/v1/prod/certificates/www", "", http.StatusForbidden},
+		{"revoke", "alice-secret", http.MethodDelete, "/v1/prod/certificates/www?reason=superseded", "", http.StatusOK},
+		{"bundle", "alice-secret", http.MethodGet, "/v1/prod/ca", "", http.StatusOK},
+		{"bundle without the permission", "auditor-secret", http.MethodGet, "/v1/prod/ca", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status, body := apiRequest(t, server, tt.secret, tt.method, tt.path, tt.body); status != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, status, tt.want, body)
+		}
+	}
+}
+
+// The list only holds the certificates within the token's domains
+func TestAPIServeList(t *testing.T) {
+	server := testAPIServer(t)
+	for secret, body := range map[string]string{
+		"alice-secret": `{"CertificateName": "www", "CommonName": "www.example.com", "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`,
+		"bob-secret":   `{"CertificateName": "shop", "CommonName": "shop.example.org", "Profile": "server", "KeyAlgorithm": "ecdsa-p256"}`,
+	} {
+		if status, response := apiRequest(t, server, secret, http.MethodPost, "/v1/prod/certificates", body); status != http.StatusCreated {
+			t.Fatalf("%s: got %d: %s", secret, status, response)
+		}
+	}
+
+	for secret, want := range map[string][]string{"alice-secret": {"www"}, "bob-secret": {"shop"}, "auditor-secret": {"shop", "www"}} {
+		status, body := apiRequest(t, server, secret, http.MethodGet, "/v1/prod/certificates", "")
+		if status != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", secret, status, body)
+		}
+		var records []CertificateRecord
+		if err := json.Unmarshal([]byte(body), &records); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, record := range records {
+			if !record.Config.IsCA {
+				names = append(names, record.Config.CertificateName)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %v, want %v", secret, names, want)
+		}
+	}
+}
+
+// Plain HTTP is only served on a loopback address, unless over TLS or with --insecure
+func TestCheckListenAddress(t *testing.T) {
+	tests := []struct {
+		listen   string
+		tls      bool
+		insecure bool
+		ok       bool
+	}{
+		{"127.0.0.1:8443", false, false, true},
+		{"[::1]:8443", false, false, true},
+		{"localhost:8443", false, false, true},
+		{":8443", false, false, false},
+		{"0.0.0.0:8443", false, false, false},
+		{"192.168.1.10:8443", false, false, false},
+		{"pki.lan:8443", false, false, false},
+		{":8443", true, false, true},
+		{"192.168.1.10:8443", false, true, true},
+		{"8443", true, false, false},
+	}
+	for _, tt := range tests {
+		if err := checkListenAddress(tt.listen, tt.tls, tt.insecure); (err == nil) != tt.ok {
+			t.Errorf("%s, tls %v, insecure %v: got %v, want allowed %v", tt.listen, tt.tls, tt.insecure, err, tt.ok)
+		}
+	}
+}
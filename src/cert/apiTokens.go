@@ -0,0 +1,192 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/apiTokens.go
+// Original timestamp: 2026/10/18 23:50
+
+// The bearer tokens of the HTTP API (cm serve): what each token may do, in which environments, and for which names
+// Only the SHA-256 of the tokens is stored in the tokens file
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// The operations a token can be allowed to perform
+const (
+	apiIssue  = "issue"
+	apiList   = "list"
+	apiFetch  = "fetch"
+	apiRevoke = "revoke"
+	apiBundle = "bundle"
+)
+
+// apiTokensFile : the JSON file given to cm serve with --tokens
+type apiTokensFile struct {
+	Tokens []apiToken `json:"Tokens"`
+}
+
+// apiToken : an API client
+// Environments are the environment names (without .json) the token can use, "*" for all of them
+// Domains are the DNS names (exact, "*.example.com" for any name under example.com, or "*" for any name),
+// IP addresses and CIDR blocks that the certificates of the token may hold
+// Permissions are the allowed operations, all of them if empty; handling CA certificates also needs AllowCA
+type apiToken struct {
+	Name         string   `json:"Name"`
+	SHA256       string   `json:"SHA256"`
+	Environments []string `json:"Environments"`
+	Domains      []string `json:"Domains"`
+	Permissions  []string `json:"Permissions,omitempty"`
+	AllowCA      bool     `json:"AllowCA,omitempty"`
+}
+
+// loadAPITokens : loads and checks the tokens file
+func loadAPITokens(tokensfile string) ([]apiToken, error) {
+	var payload apiTokensFile
+
+	jFile, err := os.ReadFile(tokensfile)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jFile, &payload); err != nil {
+		return nil, helpers.CustomError{Message: "Unable to parse the tokens file " + tokensfile + ": " + err.Error()}
+	}
+	if len(payload.Tokens) == 0 {
+		return nil, helpers.CustomError{Message: "No token found in " + helpers.Red(tokensfile)}
+	}
+	for i, token := range payload.Tokens {
+		if token.Name == "" {
+			return nil, helpers.CustomError{Message: fmt.Sprintf("Token #%d of %s has no name", i+1, tokensfile)}
+		}
+		if hash, err := hex.DecodeString(token.SHA256); err != nil || len(hash) != sha256.Size {
+			return nil, helpers.CustomError{Message: "Token " + helpers.Red(token.Name) + " does not hold a valid SHA256 value"}
+		}
+		for _, permission := range token.Permissions {
+			if !valueInSlice(permission, []string{apiIssue, apiList, apiFetch, apiRevoke, apiBundle}) {
+				return nil, helpers.CustomError{Message: "Token " + helpers.Red(token.Name) + ": unknown permission " + helpers.Red(permission) +
+					" (valid values are issue, list, fetch, revoke, bundle)"}
+			}
+		}
+		payload.Tokens[i].SHA256 = strings.ToLower(token.SHA256)
+	}
+	return payload.Tokens, nil
+}
+
+// authenticate : the token matching the bearer value, if any
+// All tokens are compared, in constant time
+func authenticate(tokens []apiToken, bearer string) *apiToken {
+	var match *apiToken
+
+	sum := sha256.Sum256([]byte(bearer))
+	hash := []byte(hex.EncodeToString(sum[:]))
+	for i := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(tokens[i].SHA256)) == 1 {
+			match = &tokens[i]
+		}
+	}
+	return match
+}
+
+// allows : tells if the token may perform the operation
+func (t apiToken) allows(operation string) bool {
+	return len(t.Permissions) == 0 || valueInSlice(operation, t.Permissions)
+}
+
+// allowsEnvironment : tells if the token may use the environment
+// As with cm env list, the sample files are not environments
+func (t apiToken) allowsEnvironment(envname string) bool {
+	envname = strings.TrimSuffix(envname, ".json")
+	for _, env := range t.Environments {
+		if (env == "*" && !strings.HasPrefix(envname, "sample")) || strings.TrimSuffix(env, ".json") == envname {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsName : tells if a DNS name or IP address matches one of the token's domains
+func (t apiToken) allowsName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	ip := net.ParseIP(name)
+
+	for _, domain := range t.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		switch {
+		case domain == "*":
+			return true
+		case ip != nil:
+			if _, block, err := net.ParseCIDR(domain); err == nil && block.Contains(ip) {
+				return true
+			}
+			if domainIP := net.ParseIP(domain); domainIP != nil && domainIP.Equal(ip) {
+				return true
+			}
+		case strings.HasPrefix(domain, "*."):
+			if strings.HasSuffix(name, domain[1:]) {
+				return true
+			}
+		case domain == name:
+			return true
+		}
+	}
+	return false
+}
+
+// allowsCertificate : checks all the names of the certificate (common name, DNS names, IP addresses and the domain part of the
+// Email addresses) against the token's domains; the error lists the first name that is not allowed
+// The common name of a CA certificate is not a host name: only the AllowCA setting applies to it
+func (t apiToken) allowsCertificate(c CertificateStruct) error {
+	if c.IsCA && !t.AllowCA {
+		return helpers.CustomError{Message: fmt.Sprintf("Token %s is not allowed to handle CA certificates", t.Name)}
+	}
+	names := append([]string{}, c.DNSNames...)
+	if !c.IsCA {
+		names = append(names, c.CommonName)
+	}
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, email := range c.EmailAddresses {
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			names = append(names, email[at+1:])
+		}
+	}
+	for _, name := range names {
+		if name != "" && !t.allowsName(name) {
+			return helpers.CustomError{Message: fmt.Sprintf("Token %s is not allowed to handle certificates for %s", t.Name, name)}
+		}
+	}
+	return nil
+}
+
+// NewAPIToken :
+// Generates a random token, and prints it along with the tokens file entry holding its hash
+func NewAPIToken(name string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(token))
+
+	entry, err := json.MarshalIndent(apiToken{Name: name, SHA256: hex.EncodeToString(sum[:]),
+		Environments: []string{strings.TrimSuffix(environment.EnvConfigFile, ".json")}, Domains: []string{}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token: %s\n", helpers.Green(token))
+	fmt.Println("It is not stored anywhere: keep it now. Add this entry to the tokens file, and fill in the domains:")
+	fmt.Println(string(entry))
+	return nil
+}
@@ -0,0 +1,89 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/apiTokens_test.go
+// Original timestamp: 2026/10/19 09:50
+
+package cert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+// Every name a certificate holds must be within the token's domains
+func TestAPITokenAllowsCertificate(t *testing.T) {
+	token := apiToken{Name: "web", Domains: []string{"*.example.com", "example.org", "10.1.0.0/16", "192.168.1.10"}}
+	caToken := apiToken{Name: "ca", Domains: []string{"*.example.com"}, AllowCA: true}
+
+	tests := []struct {
+		name  string
+		token apiToken
+		cert  CertificateStruct
+		ok    bool
+	}{
+		{"wildcard domain", token, CertificateStruct{CommonName: "www.example.com"}, true},
+		{"deep wildcard domain", token, CertificateStruct{CommonName: "a.b.example.com"}, true},
+		{"wildcard is not the apex", token, CertificateStruct{CommonName: "example.com"}, false},
+		{"suffix is not a domain", token, CertificateStruct{CommonName: "www.evilexample.com"}, false},
+		{"exact domain", token, CertificateStruct{CommonName: "EXAMPLE.ORG."}, true},
+		{"exact domain, not its subdomains", token, CertificateStruct{CommonName: "www.example.org"}, false},
+		{"DNS names", token, CertificateStruct{CommonName: "www.example.com", DNSNames: []string{"api.example.com", "example.org"}}, true},
+		{"one DNS name outside", token, CertificateStruct{CommonName: "www.example.com", DNSNames: []string{"api.example.com", "www.example.net"}}, false},
+		{"IP address in a block", token, CertificateStruct{CommonName: "www.example.com", IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, true},
+		{"exact IP address", token, CertificateStruct{CommonName: "www.example.com", IPAddresses: []net.IP{net.ParseIP("192.168.1.10")}}, true},
+		{"IP address outside", token, CertificateStruct{CommonName: "www.example.com", IPAddresses: []net.IP{net.ParseIP("10.2.0.1")}}, false},
+		{"IP address as common name", token, CertificateStruct{CommonName: "10.1.0.1"}, true},
+		{"email domain", token, CertificateStruct{CommonName: "www.example.com", EmailAddresses: []string{"admin@example.org"}}, true},
+		{"email outside", token, CertificateStruct{CommonName: "www.example.com", EmailAddresses: []string{"admin@example.net"}}, false},
+		{"no email", token, CertificateStruct{CommonName: "www.example.com", EmailAddresses: []string{"none"}}, true},
+		{"CA without AllowCA", token, CertificateStruct{CommonName: "Example CA", IsCA: true}, false},
+		{"CA, common name not checked", caToken, CertificateStruct{CommonName: "Example CA", IsCA: true}, true},
+		{"CA, DNS names checked", caToken, CertificateStruct{CommonName: "Example CA", IsCA: true, DNSNames: []string{"ca.example.net"}}, false},
+		{"any domain", apiToken{Domains: []string{"*"}}, CertificateStruct{CommonName: "anything.example.net"}, true},
+		{"no domain", apiToken{}, CertificateStruct{CommonName: "www.example.com"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.token.allowsCertificate(tt.cert); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want allowed %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestAPITokenAllows(t *testing.T) {
+	token := apiToken{Environments: []string{"prod", "staging.json"}, Permissions: []string{apiList, apiFetch}}
+	for envname, want := range map[string]bool{"prod": true, "prod.json": true, "staging": true, "dev": false, "sampleEnv": false} {
+		if got := token.allowsEnvironment(envname); got != want {
+			t.Errorf("environment %s: got %v, want %v", envname, got, want)
+		}
+	}
+	if !token.allows(apiList) || token.allows(apiIssue) || token.allows(apiRevoke) {
+		t.Error("the permissions are not applied")
+	}
+
+	all := apiToken{Environments: []string{"*"}}
+	if !all.allowsEnvironment("dev") || all.allowsEnvironment("sampleEnv") {
+		t.Error("* must match all the environments but the samples")
+	}
+	if !all.allows(apiRevoke) {
+		t.Error("a token without permissions may do everything")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash := func(secret string) string {
+		sum := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(sum[:])
+	}
+	tokens := []apiToken{{Name: "alice", SHA256: hash("alice-secret")}, {Name: "bob", SHA256: hash("bob-secret")}}
+
+	if token := authenticate(tokens, "bob-secret"); token == nil || token.Name != "bob" {
+		t.Errorf("bob-secret: got %v", token)
+	}
+	for _, bearer := range []string{"", "nobody", hash("alice-secret")} {
+		if token := authenticate(tokens, bearer); token != nil {
+			t.Errorf("%q: authenticated as %s", bearer, token.Name)
+		}
+	}
+}
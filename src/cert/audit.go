@@ -0,0 +1,92 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/audit.go
+// Original timestamp: 2026/10/18 23:25
+
+// Records the certificate operations in the environment's audit log (helpers/audit.go), and checks the log
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"os"
+	"strings"
+)
+
+// auditCertificate() : appends the operation on the certificate (PEM-encoded) to the audit log, in the root CA directory
+// The serial number and fingerprint are read from the certificate itself; the environment name and the client are the PKI's options
+func auditCertificate(pki *CA, operation string, c CertificateStruct, certPEM []byte, details string) error {
+	record := helpers.AuditRecord{Operation: operation, Environment: pki.options.Name, Client: pki.options.Client,
+		Issuer: c.Issuer, Certificate: c.CertificateName, Subject: c.indexSubject(), Details: details}
+
+	if block, _ := pem.Decode(certPEM); block != nil {
+		sum := sha256.Sum256(block.Bytes)
+		record.SHA256 = hex.EncodeToString(sum[:])
+		if crt, err := x509.ParseCertificate(block.Bytes); err == nil {
+			record.Serial = formatSerial(crt.SerialNumber)
+		}
+	}
+	if record.Serial == "" && c.SerialNumber != nil {
+		record.Serial = formatSerial(c.SerialNumber)
+	}
+	if err := helpers.AppendAuditRecord(pki.env.RootCAdir, record); err != nil {
+		return helpers.CustomError{Message: "The operation succeeded, but could not be recorded in the audit log: " + err.Error()}
+	}
+	return nil
+}
+
+// VerifyAudit :
+// Checks the hash chain of the environment's audit log; the error reports whether it failed
+func VerifyAudit() error {
+	var env environment.EnvironmentStruct
+	var err error
+
+	if env, err = environment.LoadEnvironmentFile(); err != nil {
+		return err
+	}
+	summary, err := helpers.VerifyAuditLog(env.RootCAdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return helpers.CustomError{Message: "No audit log found in " + helpers.Red(env.RootCAdir)}
+		}
+		return err
+	}
+
+	if helpers.StructuredOutput() {
+		if err = helpers.PrintRecords([]helpers.AuditSummary{summary}); err != nil {
+			return err
+		}
+	} else {
+		result := helpers.Green("valid")
+		if !summary.Valid {
+			result = helpers.Red("invalid")
+		}
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Audit log", "Records", "First record", "Last record", "Result"})
+		t.AppendRow([]interface{}{summary.File, summary.Records, summary.First.Local().Format("2006/01/02 15:04:05"),
+			summary.Last.Local().Format("2006/01/02 15:04:05"), result})
+		t.SetStyle(table.StyleBold)
+		t.Style().Format.Header = text.FormatDefault
+		t.Render()
+		for _, failure := range summary.Failures {
+			fmt.Printf("\t• %s\n", helpers.Red(failure))
+		}
+		// The chain cannot tell whether the last records were removed: the last hash, kept elsewhere, can
+		fmt.Printf("Last record hash: %s\n", helpers.White(summary.LastHash))
+	}
+
+	if !summary.Valid {
+		return helpers.CustomError{Message: fmt.Sprintf("The audit log failed the verification (%d problem(s)): %s",
+			len(summary.Failures), strings.TrimSpace(summary.File))}
+	}
+	return nil
+}
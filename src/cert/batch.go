@@ -0,0 +1,318 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/batch.go
+// Original timestamp: 2026/10/18 20:20
+
+// Batch issuance: many certificates in one run, from a directory of certificate config files or a YAML manifest
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var CertBatch = ""
+
+// batchManifest : the YAML manifest; each certificate is layered over the defaults
+// The keys are the same as the certificate config files' (CommonName, DNSNames, Profile...)
+type batchManifest struct {
+	Defaults     map[string]interface{}   `yaml:"defaults"`
+	Certificates []map[string]interface{} `yaml:"certificates"`
+}
+
+// batchEntry : a certificate of the batch, and what happened to it
+// The key of a CA whose key goes on the PKCS#11 token is only generated when the CA is issued
+type batchEntry struct {
+	Source string
+	Config CertificateStruct
+	Key    crypto.Signer
+	Err    error
+}
+
+// CreateBatch :
+// Issues all the certificates of a directory (every .json file) or of a YAML manifest
+// Workflow :
+// 1. Load the environment once, read and prepare every certificate config; flags apply to all of them
+// 2. Check for duplicates, within the batch and against the issuers' index.txt
+// 3. Order the certificates: each CA after its issuer, then the other certificates; a cycle of issuers fails here
+// 4. Generate the private keys in parallel; the keys going on the PKCS#11 token are generated when their CA is issued
+// 5. Issue the certificates one at a time: serial numbers and index.txt updates stay serialized
+// 6. Print a summary; the error reports how many certificates failed
+func CreateBatch(source string) error {
+	var entries []*batchEntry
+	var err error
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	if err = createCertificateDirectories(pki.env); err != nil {
+		return err
+	}
+
+	// 1. Read the certificate configs
+	if entries, err = loadBatch(source); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return helpers.CustomError{Message: "No certificate found in " + helpers.Red(source)}
+	}
+
+	// 1. to 5. Prepare, check, order, generate the keys and issue
+	issueBatch(pki, entries)
+
+	// 6. Summary
+	return printBatchSummary(entries)
+}
+
+// issueBatch() : prepares and issues the loaded entries; each failure is recorded in its entry
+func issueBatch(pki *CA, entries []*batchEntry) {
+	env := pki.env
+
+	// 1. Prepare the certificate configs
+	for _, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+		if _, entry.Err = entry.Config.applyFlags(); entry.Err == nil {
+			entry.Err = entry.Config.prepare(env, false)
+		}
+	}
+
+	// 2. Duplicates: the same name or subject twice in the batch, or a subject already in its issuer's index.txt
+	// The CAs created by this batch have no index.txt yet
+	batchCAs := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Err == nil && entry.Config.IsCA {
+			batchCAs[entry.Config.CertificateName] = true
+		}
+	}
+	seen := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+		for _, key := range []string{"name:" + entry.Config.CertificateName, "subject:" + entry.Config.Issuer + entry.Config.indexSubject()} {
+			if previous, ok := seen[key]; ok {
+				entry.Err = helpers.CustomError{Message: "duplicate of " + previous + " in this batch"}
+			}
+			seen[key] = entry.Source
+		}
+		if entry.Err != nil || batchCAs[entry.Config.Issuer] {
+			continue
+		}
+		if entry.Config.IsCA && entry.Config.Issuer == "" {
+			entry.Err = entry.Config.checkDuplicate(env, env.RootCAdir)
+		} else if issuerDir, err := issuerDirectory(env, entry.Config.Issuer); err != nil {
+			entry.Err = err
+		} else {
+			entry.Err = entry.Config.checkDuplicate(env, issuerDir)
+		}
+	}
+
+	// 3. Each CA after its issuer, so that the certificates it signs find it; nothing is generated yet if that fails
+	orderBatch(entries)
+
+	// 4. Generate the private keys in parallel
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, runtime.NumCPU())
+	for _, entry := range entries {
+		if entry.Err != nil || entry.Config.keyOnToken(pki) {
+			continue
+		}
+		wg.Add(1)
+		go func(entry *batchEntry) {
+			defer wg.Done()
+			workers <- struct{}{}
+			entry.Key, entry.Err = entry.Config.generateKey(pki.options.KeySize)
+			<-workers
+		}(entry)
+	}
+	wg.Wait()
+
+	// 5. Issue the certificates, in that order
+	for _, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+		if entry.Err = entry.issue(pki); entry.Err != nil {
+			continue
+		}
+		entry.Config.printIssued(env)
+		if CertJava && !entry.Config.IsCA {
+			entry.Err = entry.Config.createJavaCert(pki)
+		}
+	}
+}
+
+// orderBatch() : sorts the entries, the CAs first, each after the CA of the batch that issues it, then the other certificates
+// A CA that is its own issuer, directly or not, fails, and so does every certificate whose issuer of the batch fails
+func orderBatch(entries []*batchEntry) {
+	const visiting, done = 1, 2
+	batchCAs := make(map[string]*batchEntry)
+	for _, entry := range entries {
+		if previous, ok := batchCAs[entry.Config.CertificateName]; entry.Config.IsCA && (!ok || previous.Err != nil) {
+			batchCAs[entry.Config.CertificateName] = entry
+		}
+	}
+
+	ordered := make([]*batchEntry, 0, len(entries))
+	state := make(map[*batchEntry]int)
+	var path []*batchEntry
+	var visit func(entry *batchEntry)
+	visit = func(entry *batchEntry) {
+		switch state[entry] {
+		case done:
+			return
+		case visiting:
+			// The issuers from this entry on form a cycle
+			var cycle []string
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i].Config.CertificateName}, cycle...)
+				if path[i] == entry {
+					break
+				}
+			}
+			for _, member := range path[len(path)-len(cycle):] {
+				if member.Err == nil {
+					member.Err = helpers.CustomError{Message: "circular issuers: " + strings.Join(append(cycle, entry.Config.CertificateName), " -> ")}
+				}
+			}
+			return
+		}
+		state[entry] = visiting
+		path = append(path, entry)
+		if issuer, ok := batchCAs[entry.Config.Issuer]; ok && entry.Config.Issuer != "" {
+			visit(issuer)
+			if entry.Err == nil && issuer.Err != nil {
+				entry.Err = helpers.CustomError{Message: "its issuer " + issuer.Config.CertificateName + " (" + issuer.Source + ") cannot be issued"}
+			}
+		}
+		path = path[:len(path)-1]
+		state[entry] = done
+		ordered = append(ordered, entry)
+	}
+	for _, isCA := range []bool{true, false} {
+		for _, entry := range entries {
+			if entry.Config.IsCA == isCA {
+				visit(entry)
+			}
+		}
+	}
+	copy(entries, ordered)
+}
+
+// issue() : saves the private key (or generates it on the PKCS#11 token), then signs and registers the certificate
+func (entry *batchEntry) issue(pki *CA) error {
+	issuer, issuerDir, err := entry.Config.resolveIssuer(pki)
+	if err != nil {
+		return err
+	}
+	unlock, err := helpers.LockDirectory(issuerDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = entry.Config.checkDuplicate(pki.env, issuerDir); err != nil {
+		return err
+	}
+	if entry.Config.keyOnToken(pki) {
+		if entry.Key, err = entry.Config.createTokenKey(pki); err != nil {
+			return err
+		}
+	} else if err = entry.Config.savePrivateKey(pki, entry.Key); err != nil {
+		return err
+	}
+	return entry.Config.issue(pki, entry.Key, issuer, issuerDir)
+}
+
+// loadBatch() : a directory holds certificate config files; anything else is a YAML manifest
+// An unreadable config file is an entry in error, not a failure of the whole batch
+func loadBatch(source string) ([]*batchEntry, error) {
+	var entries []*batchEntry
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(source, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			entry := &batchEntry{Source: filepath.Base(file)}
+			if jFile, err := os.ReadFile(file); err != nil {
+				entry.Err = err
+			} else if err = json.Unmarshal(jFile, &entry.Config); err != nil {
+				entry.Err = helpers.CustomError{Message: "invalid certificate config: " + err.Error()}
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	var manifest batchManifest
+	yFile, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(yFile, &manifest); err != nil {
+		return nil, helpers.CustomError{Message: "Unable to parse the manifest " + source + ": " + err.Error()}
+	}
+	for i, certificate := range manifest.Certificates {
+		entry := &batchEntry{Source: fmt.Sprintf("%s #%d", filepath.Base(source), i+1)}
+		// The YAML values go through JSON, so that the manifest uses the same keys as the config files
+		for _, values := range []map[string]interface{}{manifest.Defaults, certificate} {
+			if jStream, err := json.Marshal(values); err != nil {
+				entry.Err = err
+			} else if err = json.Unmarshal(jStream, &entry.Config); err != nil {
+				entry.Err = helpers.CustomError{Message: "invalid certificate values: " + err.Error()}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// printBatchSummary() : one line per certificate, issued or failed, and why
+func printBatchSummary(entries []*batchEntry) error {
+	failed := 0
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Source", "Cert name", "Common Name", "Serial", "Result"})
+	for _, entry := range entries {
+		serial, result := "", helpers.Green("issued")
+		if entry.Err != nil {
+			failed++
+			result = helpers.Red(strings.TrimSpace(entry.Err.Error()))
+		} else {
+			serial = formatSerial(entry.Config.SerialNumber)
+		}
+		t.AppendRow([]interface{}{entry.Source, entry.Config.CertificateName, entry.Config.CommonName, serial, result})
+	}
+	t.SetStyle(table.StyleBold)
+	t.Style().Format.Header = text.FormatDefault
+	t.Render()
+
+	fmt.Printf("Certificates issued: %s, failed: %s\n", helpers.Green(fmt.Sprintf("%d", len(entries)-failed)), helpers.Red(fmt.Sprintf("%d", failed)))
+	if failed > 0 {
+		return helpers.CustomError{Message: fmt.Sprintf("%d of %d certificates could not be issued", failed, len(entries))}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/batch_test.go
+// Original timestamp: 2026/10/19 11:25
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testBatchCA() : the config of an intermediate CA of the batch
+func testBatchCA(name string, issuer string) CertificateStruct {
+	c := testSubject
+	c.CertificateName, c.CommonName, c.IsCA, c.Profile, c.KeyAlgorithm, c.Issuer = name, name+" CA", true, "ca", "ecdsa-p256", issuer
+	return c
+}
+
+// testBatch() : one entry per config, its source named after the certificate
+func testBatch(configs ...CertificateStruct) []*batchEntry {
+	var entries []*batchEntry
+	for _, c := range configs {
+		entries = append(entries, &batchEntry{Source: c.CertificateName + ".json", Config: c})
+	}
+	return entries
+}
+
+// The CAs are issued after their issuer, whatever their order in the batch
+func TestIssueBatchOrder(t *testing.T) {
+	pki := testPKI(t)
+	web := testLeaf("web", "web.example.com")
+	web.Issuer = "sub"
+	entries := testBatch(web, testBatchCA("sub", "inter"), testBatchCA("inter", "rootca"), testLeaf("api", "api.example.com"))
+
+	issueBatch(pki, entries)
+	var order []string
+	for _, entry := range entries {
+		if entry.Err != nil {
+			t.Errorf("%s: %v", entry.Source, entry.Err)
+		}
+		order = append(order, entry.Config.CertificateName)
+	}
+	if got := strings.Join(order, ","); got != "inter,sub,web,api" {
+		t.Errorf("got the order %s, want inter,sub,web,api", got)
+	}
+
+	issued, err := pki.Get(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain []string
+	for _, crt := range issued.Chain {
+		chain = append(chain, crt.Subject.CommonName)
+	}
+	if got := strings.Join(chain, ","); got != "sub CA,inter CA,Root CA" {
+		t.Errorf("web: got the chain %s", got)
+	}
+}
+
+// A failed entry does not stop the others; the entries that cannot be issued fail before their key is generated
+func TestIssueBatchFailures(t *testing.T) {
+	pki := testPKI(t)
+	if _, err := pki.Issue(context.Background(), testLeaf("existing", "existing.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	orphan, looped, invalid := testLeaf("orphan", "orphan.example.com"), testLeaf("looped", "looped.example.com"), testLeaf("invalid", "invalid.example.com")
+	orphan.Issuer, looped.Issuer, invalid.KeyAlgorithm = "nowhere", "a", "rsa-123"
+
+	entries := testBatch(testLeaf("www", "www.example.com"), testBatchCA("a", "b"), testBatchCA("b", "a"), looped, orphan, invalid,
+		testLeaf("existing", "existing.example.com"), testLeaf("twice", "twice.example.com"), testLeaf("twice", "twice.example.com"),
+		testLeaf("shop", "shop.example.com"))
+	issueBatch(pki, entries)
+
+	want := map[string]string{"www": "", "a": "circular issuers: a -> b -> a", "b": "circular issuers: a -> b -> a", "looped": "its issuer a",
+		"orphan": "Unknown issuer", "invalid": "rsa-123", "existing": "already present", "shop": ""}
+	twice := 0
+	for _, entry := range entries {
+		name := entry.Config.CertificateName
+		if name == "twice" {
+			if entry.Err != nil {
+				twice++
+			}
+			continue
+		}
+		switch {
+		case want[name] == "" && entry.Err != nil:
+			t.Errorf("%s: %v", name, entry.Err)
+		case want[name] != "" && (entry.Err == nil || !strings.Contains(entry.Err.Error(), want[name])):
+			t.Errorf("%s: got %v, want an error containing %q", name, entry.Err, want[name])
+		case want[name] != "" && entry.Key != nil:
+			t.Errorf("%s: a key was generated", name)
+		}
+	}
+	if twice != 1 {
+		t.Errorf("twice: %d failures, want 1", twice)
+	}
+}
+
+// The keys are generated in parallel, but the serial numbers are drawn one certificate at a time
+func TestIssueBatchSerials(t *testing.T) {
+	for _, strategy := range []string{environment.SerialSequential, environment.SerialRandom} {
+		pki := testPKI(t)
+		pki.env.SerialStrategy = strategy
+		var configs []CertificateStruct
+		for i := 0; i < 24; i++ {
+			configs = append(configs, testLeaf(fmt.Sprintf("web%02d", i), fmt.Sprintf("web%02d.example.com", i)))
+		}
+		entries := testBatch(configs...)
+		issueBatch(pki, entries)
+
+		seen := make(map[string]string)
+		for _, entry := range entries {
+			if entry.Err != nil {
+				t.Fatalf("%s, %s: %v", strategy, entry.Source, entry.Err)
+			}
+			serial := formatSerial(entry.Config.SerialNumber)
+			if previous, ok := seen[serial]; ok {
+				t.Errorf("%s: %s and %s have the same serial number %s", strategy, previous, entry.Source, serial)
+			}
+			seen[serial] = entry.Source
+		}
+		index, err := readIndexFile(pki.env.RootCAdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range index {
+			delete(seen, entry.Serial)
+		}
+		if len(seen) != 0 {
+			t.Errorf("%s: serial numbers missing from index.txt: %v", strategy, seen)
+		}
+	}
+}
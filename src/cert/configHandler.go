@@ -7,6 +7,7 @@ package cert
 
 import (
 	"certificateManager/environment"
+	"certificateManager/helpers"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -16,48 +17,43 @@ import (
 // LoadCertificateConfFile :
 // Loads the certificate config from the certificate file
 func LoadCertificateConfFile(certfile string) (CertificateStruct, error) {
-	var payload CertificateStruct
-	var err error
-	var jFile []byte
-	var rcFile string
-	env := environment.EnvironmentStruct{}
-
-	// fetch environment
-	if env, err = environment.LoadEnvironmentFile(); err != nil {
+	env, err := environment.LoadEnvironmentFile()
+	if err != nil {
 		return CertificateStruct{}, err
 	}
+	return loadCertificateConfig(env, certfile)
+}
 
-	if certfile != "" {
-		if !strings.HasSuffix(certfile, ".json") {
-			certfile += ".json"
-		}
-		//rcFile = filepath.Join(env.CertificateRootDir, env.CertificatesConfigDir, filepath.Base(certfile))
-		rcFile = filepath.Join(env.CertificatesConfigDir, filepath.Base(certfile))
-	}
+// loadCertificateConfig() : loads the certificate config from the environment's CertificatesConfigDir
+func loadCertificateConfig(env environment.EnvironmentStruct, certfile string) (CertificateStruct, error) {
+	var payload CertificateStruct
 
-	if jFile, err = os.ReadFile(rcFile); err != nil {
-		return CertificateStruct{}, err
+	if !strings.HasSuffix(certfile, ".json") {
+		certfile += ".json"
 	}
-	err = json.Unmarshal(jFile, &payload)
+	jFile, err := os.ReadFile(filepath.Join(env.CertificatesConfigDir, filepath.Base(certfile)))
 	if err != nil {
+		if os.IsNotExist(err) {
+			return CertificateStruct{}, helpers.CustomError{Message: err.Error(), Err: ErrNotFound}
+		}
+		return CertificateStruct{}, err
+	}
+	if err = json.Unmarshal(jFile, &payload); err != nil {
 		return CertificateStruct{}, err
-	} else {
-		return payload, nil
 	}
+	return payload, nil
 }
 
 // SaveCertificateConfFile :
 // Save a data structure into a certificate file in the directory defined in the JSON environment config file
 func (c CertificateStruct) SaveCertificateConfFile(outfile string) error {
-	var env environment.EnvironmentStruct
-	var err error
-
 	if outfile == "" {
 		// fetch environment
-		if env, err = environment.LoadEnvironmentFile(); err != nil {
+		env, err := environment.LoadEnvironmentFile()
+		if err != nil {
 			return err
 		}
-		outfile = filepath.Join(env.CertificatesConfigDir, c.CertificateName+".json")
+		return c.saveCertificateConfig(env)
 	}
 
 	if !strings.HasSuffix(outfile, ".json") {
@@ -92,3 +88,8 @@ func (c CertificateStruct) SaveCertificateConfFile(outfile string) error {
 
 	return nil
 }
+
+// saveCertificateConfig() : saves the certificate config in the environment's CertificatesConfigDir
+func (c CertificateStruct) saveCertificateConfig(env environment.EnvironmentStruct) error {
+	return c.SaveCertificateConfFile(filepath.Join(env.CertificatesConfigDir, c.CertificateName+".json"))
+}
@@ -8,7 +8,8 @@ package cert
 import (
 	"certificateManager/environment"
 	"certificateManager/helpers"
-	"crypto/rsa"
+	"context"
+	"crypto"
 	"fmt"
 	"net"
 	"os"
@@ -29,20 +30,21 @@ var CertPKsize int
 
 // Workflow :
 // 1. Create the directory structure
-// 2. Populate the cert structure with user-defined values
-// 3. Fetch the current serial number, increment it
-// 4. Generate private key
-// 5. Generate CSR
-// 6. Sign certificate
-// 7. Update index.txt, index.attr.txt, serial
-// 8. Save/update the certificate config file in the config directory
+// 2. Populate the cert structure with user-defined values, load and lock the issuer, check for duplicates
+// 3. Generate private key
+// 4. Issue: fetch and increment the serial number, generate the CSR, sign the certificate,
+//    update index.txt, index.attr.txt, serial, and save the certificate config file
 
 func Create(certconfigfile string) error {
-	var privateKey *rsa.PrivateKey
 	var err error
 	var env environment.EnvironmentStruct
 	var certconfig CertificateStruct
-	isDupe := false
+
+	// --batch issues many certificates at once, from a directory or a manifest
+	if CertBatch != "" {
+		return CreateBatch(CertBatch)
+	}
+
 	if env, err = environment.LoadEnvironmentFile(); err != nil {
 		return err
 	}
@@ -52,112 +54,265 @@ func Create(certconfigfile string) error {
 		return err
 	}
 
-	// 2a. Populate the certificate structure with user-provided values or a file
-	if certconfigfile == "" {
+	// 2a. Populate the certificate structure with a file, the command line flags layered over it, or user-provided values
+	// Prompting only happens when there is neither a file nor flags
+	if certconfigfile != "" {
+		if certconfig, err = LoadCertificateConfFile(certconfigfile); err != nil {
+			return err
+		}
+	}
+	flagsSet, err := certconfig.applyFlags()
+	if err != nil {
+		return err
+	}
+	interactive := certconfigfile == "" && !flagsSet
+	if interactive {
 		fmt.Printf("An example of a certificate can be found at %s\n", helpers.Green(filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", "sampleCert.json")))
-		if err = populateCertificateStructure(&certconfig); err != nil {
+		if err = populateCertificateStructure(env, &certconfig); err != nil {
 			return err
 		}
-	} else {
-		if certconfig, err = LoadCertificateConfFile(certconfigfile); err != nil {
+	}
+	if err = certconfig.prepare(env, interactive); err != nil {
+		return err
+	}
+
+	// 2b. to 4. Load the issuer, check for duplicates, generate the private key, sign and register the certificate
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	if err = certconfig.create(context.Background(), pki); err != nil {
+		return err
+	}
+	certconfig.printIssued(env)
+
+	if !certconfig.IsCA {
+		if CertJava {
+			if err = certconfig.createJavaCert(pki); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Certificate %s has been created.\n", helpers.Green(certconfig.CertificateName))
+	}
+	return nil
+}
+
+// create() : once the certificate structure is prepared, the rest of the workflow
+// 2b. Load the issuing CA, which holds the serial and index.txt this certificate goes into
+// 2c. Check for duplicates
+// 3. Generate the private key
+// 4. Sign, register and save the certificate
+func (c *CertificateStruct) create(ctx context.Context, pki *CA) error {
+	issuer, issuerDir, err := c.resolveIssuer(pki)
+	if err != nil {
+		return err
+	}
+	// From here on, we hold the issuer's directory lock: another cm process cannot take the same serial number
+	// Or rewrite index.txt under our feet
+	unlock, err := helpers.LockDirectoryContext(ctx, issuerDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = c.checkDuplicate(pki.env, issuerDir); err != nil {
+		return err
+	}
+
+	// Destination is either ServerCertsDir/private or the CA's own directory
+	privateKey, err := c.createPrivateKey(pki)
+	if err != nil {
+		return err
+	}
+	return c.issue(pki, privateKey, issuer, issuerDir)
+}
+
+// prepare() : applies the command line overrides, then the profile and the defaults, and checks the values
+func (c *CertificateStruct) prepare(env environment.EnvironmentStruct, interactive bool) error {
+	var err error
+
+	// The -p flag applies a profile, overriding the config file's key usages
+	if CertProfile != "" {
+		if err = c.applyProfile(env, CertProfile); err != nil {
 			return err
 		}
 	}
+	c.applyKeyUsageFlags()
 
-	// 2b. Check if the proposed certificate already exists
-	// There is no reason in a well-behaved PKI to allow duplicates. I offer the possibility just because there might
-	// be use-cases that I am not aware of
-	if env.RemoveDuplicates {
-		if isDupe, err = certconfig.check4DuplicateCert(filepath.Join(env.RootCAdir, "index.txt")); err != nil {
-			return helpers.CustomError{Message: "Unable to load/parse the index.txt database: " + err.Error()}
+	// The -a flag overrides whatever key algorithm is in the config file
+	if CertKeyAlgorithm != "" {
+		c.KeyAlgorithm = CertKeyAlgorithm
+	}
+	// The -i flag overrides whatever issuer is in the config file
+	if CertIssuer != "" {
+		c.Issuer = CertIssuer
+	}
+	// Same thing with the -o flag and the OCSP responder URL
+	if CertOCSPServer != "" {
+		c.OCSPServers = []string{CertOCSPServer}
+	}
+	return c.complete(env, !interactive)
+}
+
+// complete() : applies the profile and the defaults, normalizes the key algorithm, and if asked to, checks the values
+// A config naming a profile without listing its key usages relies on that profile
+func (c *CertificateStruct) complete(env environment.EnvironmentStruct, check bool) error {
+	var err error
+
+	if c.Profile != "" && len(c.KeyUsage) == 0 {
+		if err = c.applyProfile(env, c.Profile); err != nil {
+			return err
 		}
-		if isDupe {
-			return helpers.CustomError{Message: "Unable to proceed: that certificate is already present in the index.txt database"}
+	}
+	if c.Duration == 0 {
+		c.Duration = 1
+	}
+	if check {
+		if err = c.checkRequiredValues(); err != nil {
+			return err
 		}
 	}
+	if c.KeyAlgorithm, err = normalizeKeyAlgorithm(c.KeyAlgorithm); err != nil {
+		return err
+	}
 
 	// Corner case : as EmailAddress is part of a certificate signature (ie: this is part on how
 	// We differentiate the registered certconfig), we need to have a value in this field.
-	if len(certconfig.EmailAddresses) == 0 {
-		certconfig.EmailAddresses = []string{"none"}
+	if len(c.EmailAddresses) == 0 {
+		c.EmailAddresses = []string{"none"}
+	}
+	return nil
+}
+
+// resolveIssuer() : loads the issuing CA, and returns the directory holding the serial and index.txt of the certificate
+// A CA with no issuer is a root CA: it is self-signed and goes into RootCAdir's serial and index.txt
+func (c CertificateStruct) resolveIssuer(pki *CA) (*issuingCA, string, error) {
+	if c.IsCA && c.Issuer == "" {
+		return nil, pki.env.RootCAdir, nil
+	}
+	ca, err := loadIssuer(pki, c.Issuer)
+	if err != nil {
+		return nil, "", err
 	}
+	return &ca, ca.Dir, nil
+}
 
-	// 3. Get the current serial number
-	if certconfig.SerialNumber, err = getSerialNumber(); err != nil {
-		return err
-	} else {
-		certconfig.SerialNumber++
+// checkDuplicate() : there is no reason in a well-behaved PKI to allow duplicates. I offer the possibility just
+// Because there might be use-cases that I am not aware of
+func (c CertificateStruct) checkDuplicate(env environment.EnvironmentStruct, issuerDir string) error {
+	if !env.RemoveDuplicates {
+		return nil
 	}
+	isDupe, err := c.check4DuplicateCert(filepath.Join(issuerDir, "index.txt"))
+	if err != nil {
+		return helpers.CustomError{Message: "Unable to load/parse the index.txt database: " + err.Error()}
+	}
+	if isDupe {
+		return helpers.CustomError{Message: "Unable to proceed: that certificate is already present in the index.txt database", Err: ErrDuplicate}
+	}
+	return nil
+}
+
+// issue() : the part of the workflow that updates the CA database, once the private key exists
+// 1. Fetch the next serial number (sequential or random, as per the environment)
+// 2. Generate the CSR (if not a CA certificate)
+// 3. Generate the certificate, also sign it if non-CA certificate
+// 4. Update serial, index.txt.attr and index.txt
+// 5. Save/update the certificate config file in the config directory
+// 6. Record the operation in the audit log
+func (c *CertificateStruct) issue(pki *CA, privateKey crypto.Signer, issuer *issuingCA, issuerDir string) error {
+	var err error
+	env := pki.env
 
-	// 4. Generate a private key
-	// Destination is either ServerCertsDir/private or RootCAdir
-	if privateKey, err = certconfig.createPrivateKey(); err != nil {
+	// 1. Get the next serial number
+	if c.SerialNumber, err = nextSerialNumber(env, issuerDir); err != nil {
 		return err
 	}
 
-	// 5. Generate the CSR (if not a CA certconfig)
-	if !certconfig.IsCA {
-		if err = certconfig.generateCSR(env, privateKey); err != nil {
+	// 2. Generate the CSR (if not a CA certconfig)
+	if !c.IsCA {
+		if err = c.generateCSR(env, privateKey); err != nil {
 			return err
 		}
 	}
 
-	// 6. Generate the certificate, also sign it if non-CA certconfig
-	if certconfig.IsCA {
-		if err := certconfig.createCA(env, privateKey); err != nil {
+	// 3. Generate the certificate, also sign it if non-CA certconfig
+	if c.IsCA {
+		if err = c.createCA(env, privateKey, issuer); err != nil {
 			return err
 		}
 	} else {
-		if err := certconfig.signCert(env); err != nil {
+		if err = c.signCert(env, *issuer); err != nil {
 			return err
 		}
 	}
 
-	// 7. Update serial, index.txt.attr and index.txt
-	// serial
-	if err = setSerialNumber(certconfig.SerialNumber); err != nil {
-		return err
-	}
-	// index.txt.attr
-	if err = writeAttributeFile(); err != nil {
-		return err
-	}
-	// index.txt
-	if err = writeIndexFile(certconfig); err != nil {
+	// 4. Update serial, index.txt.attr and index.txt
+	if err = registerCertificate(env, *c, issuerDir); err != nil {
 		return err
 	}
 
-	// 8. Save JSON config file
-	if err = certconfig.SaveCertificateConfFile(""); err != nil {
+	// 5. Save JSON config file
+	if err = c.saveCertificateConfig(env); err != nil {
 		return err
 	}
 
-	if !certconfig.IsCA {
-		fmt.Printf("Certificate %s has been created.\n", helpers.Green(certconfig.CertificateName))
+	// 6. Audit log
+	certPEM, _ := os.ReadFile(c.certificateFilePath(env))
+	return auditCertificate(pki, "create", *c, certPEM, "")
+}
+
+// printIssued() : tells the user where the new certificate is, and which CA signed it
+func (c CertificateStruct) printIssued(env environment.EnvironmentStruct) {
+	switch {
+	case c.IsCA && c.Issuer == "":
+		fmt.Printf("Root CA certificate %s with a duration of %v years successfully created in %s\n",
+			helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(c.caDirectory(env)))
+	case c.IsCA:
+		fmt.Printf("Intermediate CA certificate %s with a duration of %v years successfully created in %s, signed by %s\n",
+			helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(c.caDirectory(env)), helpers.White(c.Issuer))
+	default:
+		issuer := c.Issuer
+		if issuer == "" {
+			issuer, _ = rootCAName(env)
+		}
+		fmt.Printf("Certificate %s with a duration of %v years successfully created in %s, signed by %s\n",
+			helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(filepath.Join(env.ServerCertsDir, "certs")), helpers.White(issuer))
 	}
-	return nil
 }
 
 // This is a beyond ugly method, only there because I want to ship this software ASAP
 // and won't bother (for now) for a better solution
-func populateCertificateStructure(cs *CertificateStruct) error {
-	var err error
+func populateCertificateStructure(env environment.EnvironmentStruct, cs *CertificateStruct) error {
 	//var ips []string
-	fmt.Println("Entries with multiple values (ip addresses, emails, key usage are separated with ENTER, with another ENTER pressed at the end.\n")
+	fmt.Println("Entries with multiple values (ip addresses, emails, key usage are separated with ENTER, with another ENTER pressed at the end.")
+	fmt.Println()
 	cs.CertificateName = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s ", helpers.Green("name")))
 	cs.CommonName = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the %s (CN): ", helpers.Green("common name")))
 	cs.IsCA = helpers.GetBoolValFromPrompt(fmt.Sprintf("[any values not starting with T,t or 1 will be treated as FALSE] Is this certificate a %s ? ", helpers.Green("CA certificate")))
+	cs.Issuer = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the %s (name of the signing CA), ENTER is the root CA: ", helpers.Green("issuer")))
 	cs.Country = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s (C): ", helpers.Green("country")))
 	cs.Province = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s (ST): ", helpers.Green("province/state")))
 	cs.Locality = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s (L): ", helpers.Green("locality")))
 	cs.Organization = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s (O): ", helpers.Green("organization")))
 	cs.OrganizationalUnit = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the certificate's %s (OU): ", helpers.Green("organizational unit")))
 	cs.EmailAddresses = helpers.GetStringSliceFromPrompt(fmt.Sprintf("Please enter the certificate's %s: ", helpers.Green("email address")))
-	cs.Duration = helpers.GetIntValFromPrompt(fmt.Sprintf("\nPlease enter the certificate's lifespan (%s) in years, ENTER is 1: ", helpers.Green("duration")))
+	cs.Duration = helpers.GetIntValFromPrompt(fmt.Sprintf("\nPlease enter the certificate's lifespan (%s) in years, ENTER is the profile's default, or 1: ", helpers.Green("duration")))
+	cs.KeyAlgorithm = helpers.GetStringValFromPrompt(fmt.Sprintf("Please enter the %s (rsa, ecdsa-p256, ecdsa-p384, ed25519), ENTER is rsa: ", helpers.Green("key algorithm")))
 
 	// Key usage is glitchy, suboptimal....
-	fmt.Printf("Please enter the %s intended for this certificate:\n", helpers.Green("key usage"))
-	cs.KeyUsage = helpers.GetKeyUsage()
+	fmt.Printf("Please enter the %s (or the profile) intended for this certificate:\n", helpers.Green("key usage"))
+	profiles, err := loadProfiles(env)
+	if err != nil {
+		return err
+	}
+	if profile, keyUsage := helpers.GetKeyUsage(profileNames(profiles)); profile != "" {
+		if err = cs.applyProfile(env, profile); err != nil {
+			return err
+		}
+	} else {
+		cs.KeyUsage = keyUsage
+	}
 	cs.DNSNames = helpers.GetStringSliceFromPrompt(fmt.Sprintf("Please enter all %s this cert is tied to: ", helpers.Green("DNS names")))
 	ips := helpers.GetStringSliceFromPrompt(fmt.Sprintf("\nPlease enter the certificate's %s: ", helpers.Green("IP address(es)")))
 	if len(ips) > 0 {
@@ -167,11 +322,6 @@ func populateCertificateStructure(cs *CertificateStruct) error {
 	} else {
 		cs.IPAddresses = []net.IP{}
 	}
-	if cs.SerialNumber, err = getSerialNumber(); err != nil {
-		return err
-	} else {
-		cs.SerialNumber++
-	}
 	cs.Comments = helpers.GetStringSliceFromPrompt(fmt.Sprintf("\nPlease enter optional %s: ", helpers.Green("comments")))
 	return nil
 }
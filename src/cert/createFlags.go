@@ -0,0 +1,118 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/createFlags.go
+// Original timestamp: 2026/10/18 19:45
+
+// Non-interactive certificate creation: every CertificateStruct field can be set from the command line,
+// Either on its own or layered over a certificate config file
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"net"
+	"strings"
+)
+
+// CertFlagValues : the values of the certificate field flags; only those listed in CertFlagsSet are applied
+var CertFlagValues CertificateStruct
+var CertFlagIPs []string
+
+// certificateFieldFlags : the flags setting a certificate field; any of them means a non-interactive creation
+// -p, -a, -i and -o are applied later, by prepare(), but they count as well: they set the profile, key algorithm, issuer and OCSP URL
+var certificateFieldFlags = []string{"name", "cn", "country", "province", "locality", "org", "ou", "ca", "email", "duration",
+	"dns", "ip", "comment", "keyusage", "extkeyusage", "profile", "keyalgo", "issuer", "ocsp"}
+
+// CertFlagsSet : the flags explicitly set on the command line, filled by the cobra command
+var CertFlagsSet = make(map[string]bool)
+
+// applyFlags() : overrides the certificate's fields with the flags set on the command line
+// Returns whether any certificate field was set from a flag
+func (c *CertificateStruct) applyFlags() (bool, error) {
+	applied := false
+
+	for name := range CertFlagsSet {
+		if valueInSlice(name, certificateFieldFlags) {
+			applied = true
+		}
+		switch name {
+		case "name":
+			c.CertificateName = CertFlagValues.CertificateName
+		case "cn":
+			c.CommonName = CertFlagValues.CommonName
+		case "country":
+			c.Country = CertFlagValues.Country
+		case "province":
+			c.Province = CertFlagValues.Province
+		case "locality":
+			c.Locality = CertFlagValues.Locality
+		case "org":
+			c.Organization = CertFlagValues.Organization
+		case "ou":
+			c.OrganizationalUnit = CertFlagValues.OrganizationalUnit
+		case "ca":
+			c.IsCA = CertFlagValues.IsCA
+		case "email":
+			c.EmailAddresses = CertFlagValues.EmailAddresses
+		case "duration":
+			c.Duration = CertFlagValues.Duration
+		case "dns":
+			c.DNSNames = CertFlagValues.DNSNames
+		case "ip":
+			c.IPAddresses = []net.IP{}
+			for _, value := range CertFlagIPs {
+				ip := net.ParseIP(strings.TrimSpace(value))
+				if ip == nil {
+					return applied, helpers.CustomError{Message: "Invalid IP address: " + helpers.Red(value)}
+				}
+				c.IPAddresses = append(c.IPAddresses, ip)
+			}
+		case "comment":
+			c.Comments = CertFlagValues.Comments
+		}
+	}
+	c.applyKeyUsageFlags()
+	return applied, nil
+}
+
+// applyKeyUsageFlags() : the key usage flags also take precedence over the profile, so they are applied again
+// After it
+func (c *CertificateStruct) applyKeyUsageFlags() {
+	if CertFlagsSet["keyusage"] {
+		c.KeyUsage = CertFlagValues.KeyUsage
+	}
+	if CertFlagsSet["extkeyusage"] {
+		c.ExtKeyUsage = CertFlagValues.ExtKeyUsage
+	}
+}
+
+// checkRequiredValues() : fails fast on missing values, instead of issuing a half-baked certificate
+// The certificate name defaults to a file-safe version of the common name
+func (c *CertificateStruct) checkRequiredValues() error {
+	var missing []string
+
+	if c.CommonName == "" {
+		missing = append(missing, "--cn")
+	}
+	if c.CertificateName == "" {
+		c.CertificateName = certificateNameFromCN(c.CommonName)
+	}
+	if c.CertificateName == "" && c.CommonName != "" {
+		missing = append(missing, "--name")
+	}
+	if len(c.KeyUsage) == 0 {
+		missing = append(missing, "--keyusage (or --profile)")
+	}
+	if len(missing) > 0 {
+		return helpers.CustomError{Message: "Missing required value(s): " + helpers.Red(strings.Join(missing, ", ")), Err: ErrInvalidCertificate}
+	}
+	for _, usage := range c.KeyUsage {
+		if getKeyUsageFromStrings([]string{usage}) == 0 {
+			return helpers.CustomError{Message: "Unknown key usage: " + helpers.Red(usage), Err: ErrInvalidCertificate}
+		}
+	}
+	if _, err := getExtKeyUsageFromStrings(c.ExtKeyUsage); err != nil {
+		return helpers.CustomError{Message: err.Error(), Err: ErrInvalidCertificate}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/crl.go
+// Original timestamp: 2026/10/18 10:41
+
+// Generates the certificate revocation lists (CRL) of the root and intermediate CAs
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var CrlNextUpdate = 30
+
+// crlReasonCodes : the RFC 5280 revocation reasons, as named by OpenSSL in index.txt
+// OpenSSL's ca command does not know the last two, which keep their RFC 5280 names
+var crlReasonCodes = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"CACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// GenerateCRL :
+// Builds a signed CRL from every revoked (R) entry of the CA's index.txt
+// Steps:
+// 1. Load the CA cert and private key (the root CA if no name is given)
+// 2. Collect the revoked entries from the CA's index.txt
+// 3. Increment the crlnumber
+// 4. Sign the CRL
+// 5. Save it to the CA's directory, in both DER (.crl) and PEM (.crl.pem) formats
+func GenerateCRL(caname string) error {
+	var entries []indexEntry
+	var revoked []x509.RevocationListEntry
+	var crlNumber uint64
+	var err error
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+
+	// 1. Load the issuing CA
+	ca, err := loadIssuer(pki, caname)
+	if err != nil {
+		return err
+	}
+	unlock, err := helpers.LockDirectory(ca.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 2. Collect the revoked entries
+	if entries, err = readIndexFile(ca.Dir); err != nil {
+		return helpers.CustomError{Message: "Unable to load/parse the index.txt database: " + err.Error()}
+	}
+	for _, entry := range entries {
+		if entry.Status != "R" {
+			continue
+		}
+		serial, err := entry.SerialNumber()
+		if err != nil {
+			return err
+		}
+		// Older releases did not record the revocation date, so we fall back on the entry's date
+		revokedAt := entry.RevocationDate
+		if revokedAt.IsZero() {
+			revokedAt = entry.Date
+		}
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: revokedAt, ReasonCode: crlReasonCodes[entry.Reason]})
+	}
+
+	// 3. Increment the crlnumber
+	if crlNumber, err = getCRLNumber(ca.Dir); err != nil {
+		return err
+	}
+	crlNumber++
+
+	// 4. Sign the CRL
+	now := time.Now()
+	template := x509.RevocationList{
+		Number:                    new(big.Int).SetUint64(crlNumber),
+		ThisUpdate:                now,
+		NextUpdate:                now.AddDate(0, 0, CrlNextUpdate),
+		RevokedCertificateEntries: revoked,
+	}
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &template, ca.Cert, ca.Key)
+	if err != nil {
+		return helpers.CustomError{Message: "Unable to create the CRL: " + err.Error()}
+	}
+
+	// 5. Save to disk
+	if err = os.WriteFile(filepath.Join(ca.Dir, ca.Name+".crl"), crlDER, 0644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(ca.Dir, ca.Name+".crl.pem"), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), 0644); err != nil {
+		return err
+	}
+	if err = setCRLNumber(crlNumber, ca.Dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("CRL #%s for %s (%s revoked certificates, next update in %s days) successfully created in %s\n",
+		helpers.White(fmt.Sprintf("%d", crlNumber)), helpers.White(ca.Name), helpers.White(fmt.Sprintf("%d", len(revoked))),
+		helpers.White(fmt.Sprintf("%d", CrlNextUpdate)), helpers.White(ca.Dir))
+	return nil
+}
+
+// getCRLNumber() : returns the number of the last CRL issued, as held in the crlnumber file, next to serial
+// A missing or empty file means that no CRL was ever issued
+func getCRLNumber(caDir string) (uint64, error) {
+	content, err := os.ReadFile(filepath.Join(caDir, "crlnumber"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	hexString := strings.TrimSpace(string(content))
+	if hexString == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(hexString, 16, 64)
+}
+
+// setCRLNumber() : records the number of the last CRL issued
+func setCRLNumber(crlNumber uint64, caDir string) error {
+	return os.WriteFile(filepath.Join(caDir, "crlnumber"), []byte(fmt.Sprintf("%04X\n", crlNumber)), 0644)
+}
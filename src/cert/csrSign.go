@@ -0,0 +1,314 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/csrSign.go
+// Original timestamp: 2026/10/18 15:48
+
+// Signs certificate signing requests (CSR) generated outside of the PKI: the private key never touches our machine
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var CertCSRFile = ""
+var CertCSRName = ""
+var CertCSRForce = false
+
+// SignCSR :
+// Issues a certificate from an external CSR, optionally merged with a certificate config file (profile)
+// Workflow :
+// 1. Load, parse and check the CSR's signature
+// 2. Merge the CSR's subject and SANs with the profile; the profile's values take precedence
+// 3. Load the issuing CA, check for duplicates, and for an existing certificate of the same name, which --force replaces
+// 4. Fetch the next serial number
+// 5. Copy the CSR into the PKI, sign the certificate
+// 6. Flag the replaced certificate as superseded, update serial, index.txt.attr and index.txt
+// 7. Save the certificate config file
+// 8. Record the operation in the audit log
+func SignCSR(profile string) error {
+	var env environment.EnvironmentStruct
+	var certconfig CertificateStruct
+	var csrRequest *x509.CertificateRequest
+	var csrBytes []byte
+	var err error
+
+	if env, err = environment.LoadEnvironmentFile(); err != nil {
+		return err
+	}
+	if err = createCertificateRootDirectories(); err != nil {
+		return err
+	}
+
+	// 1. Load, decode and parse the CSR file
+	if CertCSRFile == "" {
+		return helpers.CustomError{Message: "You need to provide the CSR file to sign with --csr"}
+	}
+	if csrBytes, err = os.ReadFile(CertCSRFile); err != nil {
+		return err
+	}
+	if csrRequest, err = parseCSR(csrBytes); err != nil {
+		return err
+	}
+
+	// 2. Merge with the profile, and the command line overrides
+	if profile != "" {
+		if certconfig, err = LoadCertificateConfFile(profile); err != nil {
+			return err
+		}
+	}
+	if CertCSRName != "" {
+		certconfig.CertificateName = CertCSRName
+	}
+	if CertIssuer != "" {
+		certconfig.Issuer = CertIssuer
+	}
+	if CertOCSPServer != "" {
+		certconfig.OCSPServers = []string{CertOCSPServer}
+	}
+	if CertProfile != "" {
+		if err = certconfig.applyProfile(env, CertProfile); err != nil {
+			return err
+		}
+	}
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	if err = certconfig.signCSR(context.Background(), pki, csrRequest, "CSR "+CertCSRFile, CertCSRForce); err != nil {
+		return err
+	}
+
+	certconfig.printIssued(env)
+	fmt.Printf("Certificate %s has been signed from %s.\n", helpers.Green(certconfig.CertificateName), helpers.White(CertCSRFile))
+	return nil
+}
+
+// signCSR() : steps 2 to 8 of SignCSR, once the CSR is parsed and checked, and the profile loaded
+// source describes where the CSR comes from, for the audit log; an existing certificate of the same name is only replaced
+// When asked to
+func (c *CertificateStruct) signCSR(ctx context.Context, pki *CA, csrRequest *x509.CertificateRequest, source string, replace bool) error {
+	var err error
+	env := pki.env
+
+	// 2. Merge the CSR with the profile
+	c.mergeCSR(csrRequest)
+	if c.CertificateName == "" {
+		return helpers.CustomError{Message: "Unable to derive a certificate name from the CSR; please provide one with --name", Err: ErrInvalidCertificate}
+	}
+	if c.Profile != "" && len(c.KeyUsage) == 0 {
+		if err = c.applyProfile(env, c.Profile); err != nil {
+			return err
+		}
+	}
+	if c.Duration == 0 {
+		c.Duration = 1
+	}
+	if len(c.KeyUsage) == 0 {
+		c.KeyUsage = []string{"digital signature", "key encipherment"}
+	}
+	if len(c.EmailAddresses) == 0 {
+		c.EmailAddresses = []string{"none"}
+	}
+	// We never issue CA certificates from an external CSR
+	c.IsCA = false
+
+	// 3. Issuing CA and duplicates
+	ca, err := loadIssuer(pki, c.Issuer)
+	if err != nil {
+		return err
+	}
+	// The name might come from the CSR's common name: it must not silently replace the config, certificate and CSR of
+	// Another certificate. When it does so on purpose, the certificate it replaces is superseded, as when renewed, even
+	// If its subject or issuer was not the same
+	var replaced CertificateStruct
+	replacedDir := ""
+	if replaced, err = loadCertificateConfig(env, c.CertificateName); err == nil {
+		if !replace {
+			return helpers.CustomError{Message: "A certificate named " + helpers.Red(c.CertificateName) + " already exists: choose another name with --name, or replace it with --force",
+				Err: ErrDuplicate}
+		}
+		if replaced.IsCA {
+			return helpers.CustomError{Message: helpers.Red(c.CertificateName) + " is a CA: it cannot be replaced by a certificate signed from a CSR", Err: ErrDuplicate}
+		}
+		if replacedDir, err = issuerDirectory(env, replaced.Issuer); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	// Both CA directories are locked, always in the same order, when the replaced certificate had another issuer
+	lockDirs := []string{ca.Dir}
+	if replacedDir != "" && replacedDir != ca.Dir {
+		lockDirs = append(lockDirs, replacedDir)
+		sort.Strings(lockDirs)
+	}
+	for _, dir := range lockDirs {
+		unlock, err := helpers.LockDirectoryContext(ctx, dir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	replacedSerial := ""
+	if replacedDir != "" {
+		if serial, err := findValidSerial(replacedDir, replaced.indexSubject()); err == nil {
+			replacedSerial = serial
+		}
+	}
+	// The entry being replaced is not a duplicate
+	if replacedSerial == "" || replacedDir != ca.Dir || replaced.indexSubject() != c.indexSubject() {
+		if err = c.checkDuplicate(env, ca.Dir); err != nil {
+			return err
+		}
+	}
+
+	// 4. Serial number
+	if c.SerialNumber, err = nextSerialNumber(env, ca.Dir); err != nil {
+		return err
+	}
+
+	// 5. Copy the CSR where signCert expects it, and sign; there is no private key, thus no Java keystore
+	if err = os.WriteFile(filepath.Join(env.ServerCertsDir, "csr", c.CertificateName+".csr"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrRequest.Raw}), 0644); err != nil {
+		return err
+	}
+	if err = c.signCert(env, ca); err != nil {
+		return err
+	}
+
+	// 6. Update serial, index.txt.attr and index.txt; the replaced certificate's private key and Java files, if we held
+	// Them, go with it, so that a renewal does not sign its key again
+	if replacedSerial != "" {
+		if err = supersedeIndexEntry(replacedDir, replacedSerial); err != nil {
+			return err
+		}
+		source += ", supersedes serial " + replacedSerial
+	}
+	if err = registerCertificate(env, *c, ca.Dir); err != nil {
+		return err
+	}
+	if replacedDir != "" {
+		for _, fn := range []string{filepath.Join(env.ServerCertsDir, "private", c.CertificateName+".key"),
+			filepath.Join(env.ServerCertsDir, "java", c.CertificateName+".p12"), filepath.Join(env.ServerCertsDir, "java", c.CertificateName+".jks"),
+			filepath.Join(env.ServerCertsDir, "java", c.CertificateName+"-truststore.jks")} {
+			if err = os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	// 7. Save JSON config file
+	if err = c.saveCertificateConfig(env); err != nil {
+		return err
+	}
+
+	// 8. Audit log
+	certPEM, _ := os.ReadFile(c.certificateFilePath(env))
+	return auditCertificate(pki, "sign", *c, certPEM, source)
+}
+
+// parseCSR() : decodes (PEM or DER) and parses a CSR, and checks its signature
+func parseCSR(csrBytes []byte) (*x509.CertificateRequest, error) {
+	if csrBlock, _ := pem.Decode(csrBytes); csrBlock != nil {
+		csrBytes = csrBlock.Bytes
+	}
+	csrRequest, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, helpers.CustomError{Message: "Unable to parse the CSR: " + err.Error(), Err: ErrInvalidCSR}
+	}
+	if err = csrRequest.CheckSignature(); err != nil {
+		return nil, helpers.CustomError{Message: "Invalid CSR signature: " + err.Error(), Err: ErrInvalidCSR}
+	}
+	return csrRequest, nil
+}
+
+// mergeCSR() : fills the empty subject fields with the CSR's, and adds the CSR's SANs to the certificate's
+func (c *CertificateStruct) mergeCSR(csr *x509.CertificateRequest) {
+	firstOf := func(current string, values []string) string {
+		if current == "" && len(values) > 0 {
+			return values[0]
+		}
+		return current
+	}
+	c.Country = firstOf(c.Country, csr.Subject.Country)
+	c.Province = firstOf(c.Province, csr.Subject.Province)
+	c.Locality = firstOf(c.Locality, csr.Subject.Locality)
+	c.Organization = firstOf(c.Organization, csr.Subject.Organization)
+	c.OrganizationalUnit = firstOf(c.OrganizationalUnit, csr.Subject.OrganizationalUnit)
+	c.CommonName = firstOf(c.CommonName, []string{csr.Subject.CommonName})
+	c.CertificateName = firstOf(c.CertificateName, []string{certificateNameFromCN(csr.Subject.CommonName)})
+
+	c.DNSNames = mergeStrings(c.DNSNames, csr.DNSNames)
+	c.EmailAddresses = mergeStrings(c.EmailAddresses, csr.EmailAddresses)
+	for _, ip := range csr.IPAddresses {
+		if !containsIP(c.IPAddresses, ip) {
+			c.IPAddresses = append(c.IPAddresses, ip)
+		}
+	}
+
+	// The key algorithm is not ours to choose, but we record it for documentation purposes
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		c.KeyAlgorithm = "rsa"
+	case *ecdsa.PublicKey:
+		if pub.Curve == elliptic.P384() {
+			c.KeyAlgorithm = "ecdsa-p384"
+		} else {
+			c.KeyAlgorithm = "ecdsa-p256"
+		}
+	case ed25519.PublicKey:
+		c.KeyAlgorithm = "ed25519"
+	}
+}
+
+// certificateNameFromCN() : derives a file-safe certificate name from the common name
+func certificateNameFromCN(cn string) string {
+	return strings.Trim(regexp.MustCompile(`[^A-Za-z0-9._-]+`).ReplaceAllString(cn, "_"), "_.")
+}
+
+// mergeStrings() : appends the values missing from the slice
+func mergeStrings(slice []string, values []string) []string {
+	for _, value := range values {
+		if !valueInSlice(value, slice) {
+			slice = append(slice, value)
+		}
+	}
+	return slice
+}
+
+func valueInSlice(value string, slice []string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/csrSign_test.go
+// Original timestamp: 2026/10/19 12:05
+
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testCSR() : a CSR for the common name, as made outside of the PKI
+func testCSR(t *testing.T, cn string) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn, Country: []string{"CA"}},
+		DNSNames: []string{cn}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrRequest, err := parseCSR(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csrRequest
+}
+
+// indexStatus() : the status and revocation reason of the serial number in the index.txt of the CA directory
+func indexStatus(t *testing.T, dir string, serial string) (string, string) {
+	t.Helper()
+	entries, err := readIndexFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Serial == serial {
+			return entry.Status, entry.Reason
+		}
+	}
+	return "", ""
+}
+
+// A name already in use is refused; with --force, the certificate it names is superseded, whatever its subject and issuer
+func TestSignCSRReplace(t *testing.T) {
+	pki := testPKI(t)
+	env := pki.env
+	if _, err := pki.Issue(context.Background(), testBatchCA("inter", "rootca")); err != nil {
+		t.Fatal(err)
+	}
+	interDir, err := issuerDirectory(env, "inter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(cn string, issuer string, replace bool) (string, error) {
+		c := CertificateStruct{CertificateName: "web", Profile: "server", Issuer: issuer}
+		if err := c.signCSR(context.Background(), pki, testCSR(t, cn), "CSR", replace); err != nil {
+			return "", err
+		}
+		return formatSerial(c.SerialNumber), nil
+	}
+	first, err := sign("web.example.com", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sign("www.example.com", "", false); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("without --force: got %v, want ErrDuplicate", err)
+	}
+	if status, _ := indexStatus(t, env.RootCAdir, first); status != "V" {
+		t.Errorf("without --force: the certificate is now %q", status)
+	}
+
+	tests := []struct {
+		name     string
+		cn       string
+		issuer   string
+		dir      string
+		previous string
+	}{
+		{"same subject", "web.example.com", "", env.RootCAdir, env.RootCAdir},
+		{"other subject", "www.example.com", "", env.RootCAdir, env.RootCAdir},
+		{"other issuer", "www.example.com", "inter", interDir, env.RootCAdir},
+	}
+	previous := first
+	for _, tt := range tests {
+		serial, err := sign(tt.cn, tt.issuer, true)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if status, reason := indexStatus(t, tt.previous, previous); status != "R" || reason != "superseded" {
+			t.Errorf("%s: the replaced serial %s is %q (%q), want R (superseded)", tt.name, previous, status, reason)
+		}
+		if status, _ := indexStatus(t, tt.dir, serial); status != "V" {
+			t.Errorf("%s: the new serial %s is %q, want V", tt.name, serial, status)
+		}
+		previous = serial
+	}
+}
+
+// A certificate whose key we generated keeps no private key once replaced by one signed from a CSR; a CA is not replaced
+func TestSignCSRReplaceKey(t *testing.T) {
+	pki := testPKI(t)
+	if _, err := pki.Issue(context.Background(), testLeaf("web", "web.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(pki.env.ServerCertsDir, "private", "web.key")
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	c := CertificateStruct{CertificateName: "web", Profile: "server"}
+	if err := c.signCSR(context.Background(), pki, testCSR(t, "web.example.com"), "CSR", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("the private key of the replaced certificate is still there: %v", err)
+	}
+
+	c = CertificateStruct{CertificateName: "rootca", Profile: "server"}
+	if err := c.signCSR(context.Background(), pki, testCSR(t, "ca.example.com"), "CSR", true); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("replacing the root CA: got %v, want ErrDuplicate", err)
+	}
+}
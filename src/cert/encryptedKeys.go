@@ -0,0 +1,289 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/encryptedKeys.go
+// Original timestamp: 2026/10/18 22:40
+
+// Passphrase-protected private keys: encrypted PKCS#8 (PBES2, PBKDF2-HMAC-SHA256 and AES-256-CBC), as written by
+// openssl pkcs8 -topk8 -v2 aes-256-cbc, and readable by any tool supporting the "ENCRYPTED PRIVATE KEY" PEM blocks
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+	"hash"
+	"os"
+	"strings"
+)
+
+// CertEncryptKey : encrypt the key of the certificate being created, whatever the environment's KeyEncryption policy
+var CertEncryptKey = false
+
+// CertKeyPassphraseFile : file holding the private keys' passphrase
+var CertKeyPassphraseFile = ""
+
+// keyPassphraseEnvVar : environment variable holding the private keys' passphrase
+const keyPassphraseEnvVar = "CM_KEY_PASSPHRASE"
+
+const keyPBKDF2Iterations = 100000
+
+// A key file asking for more PBKDF2 iterations than this is refused, rather than keeping the CPU busy for minutes
+const keyPBKDF2MaxIterations = 10000000
+
+// The passphrase is asked for once per run, even if several keys are read or written
+var keyPassphraseCache []byte
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	pbkdf2PRFs        = map[string]func() hash.Hash{oidHMACWithSHA1.String(): sha1.New, oidHMACWithSHA256.String(): sha256.New, oidHMACWithSHA384.String(): sha512.New384, oidHMACWithSHA512.String(): sha512.New}
+	aesCBCKeyLengths  = map[string]int{oidAES128CBC.String(): 16, oidAES192CBC.String(): 24, oidAES256CBC.String(): 32}
+)
+
+// RFC 5958 and RFC 8018 structures
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// encryptKey() : whether the certificate's private key is to be encrypted, as per the environment's KeyEncryption
+// Policy (none, ca, the default, or all) and the --encrypt-key flag (Options.EncryptKeys)
+func (c CertificateStruct) encryptKey(pki *CA) (bool, error) {
+	switch pki.env.KeyEncryptionPolicy() {
+	case environment.KeyEncryptionNone:
+		return pki.options.EncryptKeys, nil
+	case environment.KeyEncryptionCA:
+		return pki.options.EncryptKeys || c.IsCA, nil
+	case environment.KeyEncryptionAll:
+		return true, nil
+	}
+	return false, helpers.CustomError{Message: "Unknown key encryption policy: " + helpers.Red(pki.env.KeyEncryption) + " (valid policies are none, ca and all)",
+		Err: ErrInvalidEnvironment}
+}
+
+// encodePrivateKeyPEM() : the PKCS#8 PEM block of the private key, encrypted if asked to
+func (pki *CA) encodePrivateKeyPEM(pk crypto.Signer, encrypt bool) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypt {
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	passphrase, err := pki.passphrase(true)
+	if err != nil {
+		return nil, err
+	}
+	if der, err = encryptPKCS8(der, passphrase); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// decodePrivateKeyPEM() : parses a PEM-encoded private key, decrypting it first if needed
+// The description (file name) is used in the error messages
+func (pki *CA) decodePrivateKeyPEM(data []byte, description string) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, helpers.CustomError{Message: "Unable to PEM-decode the private key " + description}
+	}
+	if block.Type != "ENCRYPTED PRIVATE KEY" {
+		return parsePrivateKey(block.Bytes)
+	}
+
+	passphrase, err := pki.passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	der, err := decryptPKCS8(block.Bytes, passphrase)
+	if err != nil {
+		return nil, helpers.CustomError{Message: "Unable to decrypt the private key " + description + ": " + err.Error(), Err: ErrPassphrase}
+	}
+	return parsePrivateKey(der)
+}
+
+// passphrase() : the private keys' passphrase, as provided by Options.Passphrase
+func (pki *CA) passphrase(confirm bool) ([]byte, error) {
+	if pki.options.Passphrase == nil {
+		return nil, helpers.CustomError{Message: "The private key is encrypted, and no passphrase was provided", Err: ErrPassphrase}
+	}
+	passphrase, err := pki.options.Passphrase(confirm)
+	if err != nil {
+		if errors.Is(err, ErrPassphrase) {
+			return nil, err
+		}
+		return nil, helpers.CustomError{Message: err.Error(), Err: ErrPassphrase}
+	}
+	if len(passphrase) == 0 {
+		return nil, helpers.CustomError{Message: "The private key passphrase cannot be empty", Err: ErrPassphrase}
+	}
+	return passphrase, nil
+}
+
+// keyPassphrase() : the private keys' passphrase, from --key-passphrase-file, the CM_KEY_PASSPHRASE environment
+// Variable, or prompted for (twice, when encrypting a new key); this is the command line's Options.Passphrase
+func keyPassphrase(confirm bool) ([]byte, error) {
+	if keyPassphraseCache != nil {
+		return keyPassphraseCache, nil
+	}
+
+	passphrase := ""
+	switch {
+	case CertKeyPassphraseFile != "":
+		content, err := os.ReadFile(CertKeyPassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = strings.TrimRight(string(content), "\r\n")
+	case os.Getenv(keyPassphraseEnvVar) != "":
+		passphrase = os.Getenv(keyPassphraseEnvVar)
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		passphrase = helpers.GetPassword("Please provide the private key passphrase: ")
+		if confirm && passphrase != "" && helpers.GetPassword("Please confirm the passphrase: ") != passphrase {
+			return nil, helpers.CustomError{Message: "The passphrases do not match", Err: ErrPassphrase}
+		}
+	default:
+		return nil, helpers.CustomError{Message: "The private key is encrypted: please provide its passphrase with --key-passphrase-file or " + keyPassphraseEnvVar, Err: ErrPassphrase}
+	}
+	if passphrase == "" {
+		return nil, helpers.CustomError{Message: "The private key passphrase cannot be empty", Err: ErrPassphrase}
+	}
+	keyPassphraseCache = []byte(passphrase)
+	return keyPassphraseCache, nil
+}
+
+// encryptPKCS8() : encrypts a PKCS#8 private key with PBES2 (PBKDF2-HMAC-SHA256, AES-256-CBC)
+func encryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, keyPBKDF2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	encrypted := append(append([]byte{}, der...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{Salt: salt, IterationCount: keyPBKDF2Iterations,
+		PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: encrypted,
+	})
+}
+
+// decryptPKCS8() : decrypts a PBES2-encrypted PKCS#8 private key; PBKDF2 with any SHA-1 or SHA-2 HMAC, and AES-CBC
+// Are supported, which covers what OpenSSL writes
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	var scheme pbes2Params
+	var kdf pbkdf2Params
+	var iv []byte
+
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, helpers.CustomError{Message: "unsupported encryption " + info.Algorithm.Algorithm.String() + " (only PBES2 is supported)"}
+	}
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &scheme); err != nil {
+		return nil, err
+	}
+	if !scheme.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, helpers.CustomError{Message: "unsupported key derivation " + scheme.KeyDerivationFunc.Algorithm.String()}
+	}
+	if _, err := asn1.Unmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > keyPBKDF2MaxIterations {
+		return nil, helpers.CustomError{Message: fmt.Sprintf("unsupported PBKDF2 iteration count %d (at most %d)", kdf.IterationCount, keyPBKDF2MaxIterations)}
+	}
+	prf := sha1.New
+	if len(kdf.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[kdf.PRF.Algorithm.String()]; !ok {
+			return nil, helpers.CustomError{Message: "unsupported PBKDF2 function " + kdf.PRF.Algorithm.String()}
+		}
+	}
+	keyLength, ok := aesCBCKeyLengths[scheme.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, helpers.CustomError{Message: "unsupported cipher " + scheme.EncryptionScheme.Algorithm.String()}
+	}
+	if _, err := asn1.Unmarshal(scheme.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, helpers.CustomError{Message: "invalid encrypted data"}
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLength, prf))
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	// A wrong passphrase shows as an invalid padding
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, helpers.CustomError{Message: "wrong passphrase"}
+	}
+	return plain[:len(plain)-padding], nil
+}
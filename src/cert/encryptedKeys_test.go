@@ -0,0 +1,155 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/encryptedKeys_test.go
+// Original timestamp: 2026/10/19 06:50
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecryptPKCS8(t *testing.T) {
+	for _, algorithm := range []string{"rsa", "ecdsa-p256", "ed25519"} {
+		t.Run(algorithm, func(t *testing.T) {
+			der := marshalTestKey(t, algorithm)
+			encrypted, err := encryptPKCS8(der, []byte("s3cret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := decryptPKCS8(encrypted, []byte("s3cret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plain, der) {
+				t.Fatal("the decrypted key differs from the original one")
+			}
+			if _, err = decryptPKCS8(encrypted, []byte("wrong")); err == nil {
+				t.Fatal("a wrong passphrase was accepted")
+			}
+		})
+	}
+}
+
+// Hostile key files cannot keep the CPU busy with a huge PBKDF2 iteration count
+func TestDecryptPKCS8IterationCount(t *testing.T) {
+	encrypted, err := encryptPKCS8(marshalTestKey(t, "ecdsa-p256"), []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iterations := range []int{0, -1, keyPBKDF2MaxIterations + 1, 1 << 40} {
+		_, err = decryptPKCS8(withPBKDF2Iterations(t, encrypted, iterations), []byte("s3cret"))
+		if err == nil || !strings.Contains(err.Error(), "iteration count") {
+			t.Errorf("%d iterations: got %v, want an iteration count error", iterations, err)
+		}
+	}
+}
+
+// The keys encrypted by OpenSSL (openssl pkcs8 -topk8 -v2 aes-256-cbc) can be read
+func TestDecryptPKCS8OpenSSL(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	dir := t.TempDir()
+	plainFile := filepath.Join(dir, "plain.key")
+	der := marshalTestKey(t, "ecdsa-p256")
+	if err := os.WriteFile(plainFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("openssl", "pkcs8", "-topk8", "-v2", "aes-256-cbc", "-in", plainFile, "-passout", "pass:s3cret").Output()
+	if err != nil {
+		t.Skip("openssl cannot encrypt the key: ", err)
+	}
+	block, _ := pem.Decode(out)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("unexpected openssl output: %s", out)
+	}
+	plain, err := decryptPKCS8(block.Bytes, []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, der) {
+		t.Fatal("the decrypted key differs from the original one")
+	}
+}
+
+// An environment without KeyEncryption protects its CA keys
+func TestEncryptKeyPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		isCA        bool
+		encryptKeys bool
+		want        bool
+	}{
+		{"", true, false, true},
+		{"", false, false, false},
+		{"none", true, false, false},
+		{"none", false, true, true},
+		{"ca", true, false, true},
+		{"CA", false, false, false},
+		{"all", false, false, true},
+	}
+	for _, tt := range tests {
+		pki := &CA{env: environment.EnvironmentStruct{KeyEncryption: tt.policy}, options: Options{EncryptKeys: tt.encryptKeys}}
+		got, err := CertificateStruct{IsCA: tt.isCA}.encryptKey(pki)
+		if err != nil || got != tt.want {
+			t.Errorf("policy %q, CA %v, EncryptKeys %v: got %v, %v, want %v", tt.policy, tt.isCA, tt.encryptKeys, got, err, tt.want)
+		}
+	}
+	pki := &CA{env: environment.EnvironmentStruct{KeyEncryption: "some"}}
+	if _, err := (CertificateStruct{}).encryptKey(pki); err == nil {
+		t.Error("an unknown policy was accepted")
+	}
+}
+
+// marshalTestKey() : a new PKCS#8 private key of the algorithm
+func marshalTestKey(t *testing.T, algorithm string) []byte {
+	t.Helper()
+	key, err := CertificateStruct{KeyAlgorithm: algorithm}.generateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := (&CA{}).encodePrivateKeyPEM(key, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	return block.Bytes
+}
+
+// withPBKDF2Iterations() : the encrypted key, with another PBKDF2 iteration count
+func withPBKDF2Iterations(t *testing.T, der []byte, iterations int) []byte {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	var scheme pbes2Params
+	var kdf pbkdf2Params
+	mustUnmarshal := func(data []byte, v interface{}) {
+		if _, err := asn1.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustMarshal := func(v interface{}) []byte {
+		data, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	mustUnmarshal(der, &info)
+	mustUnmarshal(info.Algorithm.Parameters.FullBytes, &scheme)
+	mustUnmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+	kdf.IterationCount = iterations
+	scheme.KeyDerivationFunc = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: mustMarshal(kdf)}}
+	info.Algorithm.Parameters = asn1.RawValue{FullBytes: mustMarshal(scheme)}
+	return mustMarshal(info)
+}
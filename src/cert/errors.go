@@ -0,0 +1,37 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/errors.go
+// Original timestamp: 2026/10/19 00:20
+
+// The kinds of errors returned by the library API (pki.go), to be tested with errors.Is()
+// The errors themselves are helpers.CustomError, whose message is meant for humans
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"errors"
+)
+
+var (
+	// ErrInvalidEnvironment : the environment is missing some of its directories
+	ErrInvalidEnvironment = errors.New("invalid environment")
+	// ErrInvalidCertificate : the certificate config is incomplete or holds invalid values (key algorithm, profile, key usage...)
+	ErrInvalidCertificate = errors.New("invalid certificate config")
+	// ErrInvalidCSR : the CSR cannot be parsed, or its signature is invalid
+	ErrInvalidCSR = errors.New("invalid CSR")
+	// ErrInvalidReason : unknown revocation reason
+	ErrInvalidReason = errors.New("invalid revocation reason")
+	// ErrNotFound : no such certificate, or no valid entry for it in index.txt
+	ErrNotFound = errors.New("certificate not found")
+	// ErrUnknownIssuer : no such CA in the environment
+	ErrUnknownIssuer = errors.New("unknown issuer")
+	// ErrDuplicate : a valid certificate with the same subject is already in index.txt, and the environment forbids duplicates
+	ErrDuplicate = errors.New("duplicate certificate")
+	// ErrPassphrase : an encrypted private key cannot be read or written without a passphrase, or the passphrase is wrong
+	ErrPassphrase = errors.New("private key passphrase missing or wrong")
+	// ErrToken : the PKCS#11 token holding the CA keys cannot be used: module, token, PIN or key missing, or signature failed
+	ErrToken = errors.New("PKCS#11 token error")
+	// ErrLocked : a CA directory is locked by another process, and was not released in time
+	ErrLocked = helpers.ErrLocked
+)
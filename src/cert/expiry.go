@@ -0,0 +1,194 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/expiry.go
+// Original timestamp: 2026/10/18 15:02
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto/x509"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var CertExpiryWithin = "30d"
+var CertExpiryIncludeRevoked = false
+
+// expiryEntry : an issued certificate, as shown in the expiry report
+type expiryEntry struct {
+	Name        string
+	Cert        *x509.Certificate
+	Days        int
+	Status      string
+	IndexStatus string // the index.txt status letter, empty when the certificate is not in its issuer's index
+	Filepath    string
+}
+
+// ExpiryRecord : an issued certificate, as emitted by the machine-readable (--output) formats of the expiry report
+type ExpiryRecord struct {
+	Name            string    `json:"Name"`
+	CommonName      string    `json:"CommonName"`
+	Serial          string    `json:"Serial"`
+	NotAfter        time.Time `json:"NotAfter"`
+	DaysLeft        int       `json:"DaysLeft"`
+	IndexStatus     string    `json:"IndexStatus"`
+	Expired         bool      `json:"Expired"`
+	Expiring        bool      `json:"Expiring"`
+	CertificateFile string    `json:"CertificateFile"`
+}
+
+// parseWithin() : converts the threshold into a duration; we accept days (30d), weeks (4w), or any Go duration (72h)
+// A bare number is a number of days
+func parseWithin(within string) (time.Duration, error) {
+	within = strings.TrimSpace(within)
+	if n, err := strconv.Atoi(strings.TrimSuffix(within, "d")); err == nil {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(within, "w")); err == nil && strings.HasSuffix(within, "w") {
+		return time.Duration(n) * 7 * 24 * time.Hour, nil
+	}
+	if d, err := time.ParseDuration(within); err == nil {
+		return d, nil
+	}
+	return 0, helpers.CustomError{Message: "Invalid duration: " + helpers.Red(within) + " (examples: 30d, 4w, 72h)"}
+}
+
+// ExpiryReport :
+// Lists all issued certificates (CAs, OCSP signers, server certs) with their expiration date, days remaining, serial and
+// index.txt status. Expired certificates are shown in red, the ones expiring within the threshold in yellow
+// Revoked certificates (including the ones superseded by a renewal) are left out, unless CertExpiryIncludeRevoked is set
+// Returns the number of certificates that are expired or expire within the threshold
+func ExpiryReport() (int, error) {
+	var env environment.EnvironmentStruct
+	var err error
+	var entries []expiryEntry
+	var within time.Duration
+	var records []ExpiryRecord
+	flagged := 0
+
+	if within, err = parseWithin(CertExpiryWithin); err != nil {
+		return 0, err
+	}
+	if env, err = environment.LoadEnvironmentFile(); err != nil {
+		return 0, err
+	}
+	if entries, err = collectIssuedCerts(env); err != nil {
+		return 0, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Cert.NotAfter.Before(entries[j].Cert.NotAfter) })
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Cert name", "Common Name", "Serial", "Not after", "Days left", "Index status"})
+	for _, entry := range entries {
+		if entry.IndexStatus == "R" && !CertExpiryIncludeRevoked {
+			continue
+		}
+		record := ExpiryRecord{Name: entry.Name, CommonName: entry.Cert.Subject.CommonName, Serial: formatSerial(entry.Cert.SerialNumber),
+			NotAfter: entry.Cert.NotAfter, DaysLeft: entry.Days, IndexStatus: entry.Status, CertificateFile: entry.Filepath}
+		colour := helpers.Green
+		if entry.Cert.NotAfter.Before(time.Now()) {
+			colour = helpers.Red
+			record.Expired = true
+			flagged++
+		} else if time.Until(entry.Cert.NotAfter) <= within {
+			colour = helpers.Yellow
+			record.Expiring = true
+			flagged++
+		}
+		records = append(records, record)
+		t.AppendRow([]interface{}{colour(entry.Name), entry.Cert.Subject.CommonName, record.Serial,
+			colour(entry.Cert.NotAfter.Format("2006/01/02 15:04:05")), colour(fmt.Sprintf("%d", entry.Days)), entry.Status})
+	}
+	if helpers.StructuredOutput() {
+		if records == nil {
+			records = []ExpiryRecord{}
+		}
+		return flagged, helpers.PrintRecords(records)
+	}
+	t.SetStyle(table.StyleBold)
+	t.Style().Format.Header = text.FormatDefault
+	t.Render()
+
+	fmt.Printf("Certificates expired or expiring within %s: %s\n", CertExpiryWithin, helpers.White(fmt.Sprintf("%d", flagged)))
+	return flagged, nil
+}
+
+// collectIssuedCerts() : loads every issued certificate, and looks up its status in its issuer's index.txt
+func collectIssuedCerts(env environment.EnvironmentStruct) ([]expiryEntry, error) {
+	var entries []expiryEntry
+	var files []string
+
+	caDirs, err := caDirectories(env)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := caCertificates(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range caDirs {
+		for _, pattern := range []string{filepath.Join(dir, "*.crt"), filepath.Join(dir, "ocsp", "*.crt")} {
+			matches, _ := filepath.Glob(pattern)
+			files = append(files, matches...)
+		}
+	}
+	serverCerts, _ := filepath.Glob(filepath.Join(env.ServerCertsDir, "certs", "*.crt"))
+	files = append(files, serverCerts...)
+
+	// We cache the index.txt databases, as most certificates share the same issuer
+	indexes := make(map[string][]indexEntry)
+	for _, file := range files {
+		crt, err := readCertificateFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entry := expiryEntry{Name: strings.TrimSuffix(filepath.Base(file), ".crt"), Cert: crt, Status: "not found", Filepath: file,
+			Days: int(math.Floor(time.Until(crt.NotAfter).Hours() / 24))}
+
+		// Serials are only unique per CA, so we first find the CA that issued the certificate
+		for dir, caCert := range caCerts {
+			if !bytes.Equal(caCert.RawSubject, crt.RawIssuer) {
+				continue
+			}
+			if _, ok := indexes[dir]; !ok {
+				if indexes[dir], err = readIndexFile(dir); err != nil {
+					return nil, err
+				}
+			}
+			for _, ie := range indexes[dir] {
+				if serial, err := ie.SerialNumber(); err == nil && serial.Cmp(crt.SerialNumber) == 0 {
+					entry.Status = indexStatusName(ie.Status)
+					entry.IndexStatus = ie.Status
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// indexStatusName() : human-readable index.txt status
+func indexStatusName(status string) string {
+	switch status {
+	case "V":
+		return "valid"
+	case "R":
+		return "revoked"
+	case "E":
+		return "expired"
+	}
+	return status
+}
@@ -0,0 +1,287 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/export.go
+// Original timestamp: 2026/10/18 18:30
+
+// Exports a certificate, its chain and its key in the formats expected by the various consumers
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var CertExportFormats = []string{"fullchain"}
+var CertExportDir = "."
+
+// exportBundle : everything an export format might need
+type exportBundle struct {
+	Name  string
+	Cert  *x509.Certificate
+	Chain []*x509.Certificate // the issuing CA, followed by its own issuers, up to the root CA
+	Key   crypto.Signer       // only loaded when a format needs it
+}
+
+// exportFormat : a file written by `cm cert export`
+type exportFormat struct {
+	Suffix   string // appended to the certificate name
+	NeedKey  bool   // the file holds the private key, and is thus only readable by its owner
+	Manifest bool   // a Kubernetes manifest (k8sExport.go), only written when asked for by name, not with "all"
+	Encode   func(b exportBundle) ([]byte, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"fullchain": {Suffix: "-fullchain.pem", Encode: func(b exportBundle) ([]byte, error) {
+		return encodeCertificatesPEM(append([]*x509.Certificate{b.Cert}, b.Chain...)), nil
+	}},
+	"haproxy": {Suffix: "-haproxy.pem", NeedKey: true, Encode: func(b exportBundle) ([]byte, error) {
+		keyPEM, err := encodePKCS8PEM(b.Key)
+		if err != nil {
+			return nil, err
+		}
+		return append(encodeCertificatesPEM(append([]*x509.Certificate{b.Cert}, b.Chain...)), keyPEM...), nil
+	}},
+	"der": {Suffix: ".der", Encode: func(b exportBundle) ([]byte, error) {
+		return b.Cert.Raw, nil
+	}},
+	"pkcs7": {Suffix: ".p7b", Encode: func(b exportBundle) ([]byte, error) {
+		p7, err := encodePKCS7(append([]*x509.Certificate{b.Cert}, b.Chain...))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7}), nil
+	}},
+	"pkcs8": {Suffix: "-pkcs8.key", NeedKey: true, Encode: func(b exportBundle) ([]byte, error) {
+		return encodePKCS8PEM(b.Key)
+	}},
+	"k8s":          {Suffix: "-k8s-secret.yaml", NeedKey: true, Manifest: true, Encode: encodeK8sSecret},
+	"cert-manager": {Suffix: "-cert-manager.yaml", NeedKey: true, Manifest: true, Encode: encodeCertManagerIssuer},
+}
+
+// Export :
+// Writes the certificate in the requested formats, in the export directory
+// Steps:
+// 1. Load the certificate config, the certificate, and its issuer's chain
+// 2. Load the private key, if any of the formats needs it
+// 3. Encode each format, and write it with safe permissions: 0600 for files holding the key, 0644 for the others
+func Export(certname string) error {
+	var certconfig CertificateStruct
+	var bundle exportBundle
+	var err error
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	env := pki.env
+	requested := append([]string{}, CertExportFormats...)
+	if CertExportK8s {
+		requested = append(requested, "k8s")
+	}
+	if CertExportCertManager {
+		requested = append(requested, "cert-manager")
+	}
+	formats, err := exportFormatNames(requested)
+	if err != nil {
+		return err
+	}
+	for _, format := range formats {
+		if exportFormats[format].Manifest {
+			if _, err = k8sExportMetadata(strings.TrimSuffix(certname, ".json"), "", CertExportNamespace); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// 1. Certificate and chain
+	if certconfig, err = loadCertificateConfig(env, strings.TrimSuffix(certname, ".json")); err != nil {
+		return err
+	}
+	if bundle, err = certconfig.loadExportBundle(env); err != nil {
+		return err
+	}
+
+	// 2. Private key
+	for _, format := range formats {
+		if exportFormats[format].NeedKey {
+			if bundle.Key, err = certconfig.getPrivateKey(pki); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// 3. Encode and write
+	if err = os.MkdirAll(CertExportDir, 0700); err != nil {
+		return err
+	}
+	for _, format := range formats {
+		data, err := exportFormats[format].Encode(bundle)
+		if err != nil {
+			return helpers.CustomError{Message: fmt.Sprintf("Unable to encode %s in %s format: %s", bundle.Name, format, err.Error())}
+		}
+		perm := os.FileMode(0644)
+		if exportFormats[format].NeedKey {
+			perm = 0600
+		}
+		outfile := filepath.Join(CertExportDir, bundle.Name+exportFormats[format].Suffix)
+		if err = writeFileAtomic(outfile, data, perm); err != nil {
+			return err
+		}
+		fmt.Printf("%s (%s) written to %s\n", helpers.Green(bundle.Name), format, helpers.White(outfile))
+	}
+	return nil
+}
+
+// exportFormatNames : validates the requested formats; "all" means every format but the Kubernetes manifests
+func exportFormatNames(requested []string) ([]string, error) {
+	var formats []string
+	for _, format := range requested {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "all" {
+			for _, name := range exportFormatList() {
+				if !exportFormats[name].Manifest && !valueInSlice(name, formats) {
+					formats = append(formats, name)
+				}
+			}
+			continue
+		}
+		if _, ok := exportFormats[format]; !ok {
+			return nil, helpers.CustomError{Message: "Unknown export format: " + helpers.Red(format) + " (valid formats are " + strings.Join(exportFormatList(), ", ") + ", all)"}
+		}
+		if !valueInSlice(format, formats) {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return nil, helpers.CustomError{Message: "No export format given (valid formats are " + strings.Join(exportFormatList(), ", ") + ", all)"}
+	}
+	return formats, nil
+}
+
+func exportFormatList() []string {
+	var names []string
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadExportBundle : loads the issued certificate, and the chain of its issuing CA
+// A CA certificate's chain is its own chain.pem, minus itself
+func (c CertificateStruct) loadExportBundle(env environment.EnvironmentStruct) (exportBundle, error) {
+	var err error
+	bundle := exportBundle{Name: c.CertificateName}
+
+	if c.IsCA {
+		ca, err := loadIssuerChain(env, c.CertificateName)
+		if err != nil {
+			return exportBundle{}, err
+		}
+		bundle.Cert, bundle.Chain = ca.Cert, ca.Chain[1:]
+		return bundle, nil
+	}
+
+	if bundle.Cert, err = readCertificateFile(c.certificateFilePath(env)); err != nil {
+		return exportBundle{}, helpers.CustomError{Message: "Unable to load the certificate of " + helpers.Red(c.CertificateName) + ": " + err.Error()}
+	}
+	ca, err := loadIssuerChain(env, c.Issuer)
+	if err != nil {
+		return exportBundle{}, err
+	}
+	bundle.Chain = ca.Chain
+	return bundle, nil
+}
+
+// encodeCertificatesPEM : PEM-encodes the certificates, in order
+func encodeCertificatesPEM(certs []*x509.Certificate) []byte {
+	var data []byte
+	for _, crt := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})...)
+	}
+	return data
+}
+
+func encodePKCS8PEM(key crypto.Signer) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// PKCS#7 (RFC 2315) "certs-only" structure: a SignedData without content nor signers, as produced by
+// openssl crl2pkcs7 -nocrl
+var oidPKCS7Data = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+var oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+func encodePKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var rawCerts []byte
+	for _, crt := range certs {
+		rawCerts = append(rawCerts, crt.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: rawCerts},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// writeFileAtomic : writes the file through a temporary file created with the final permissions, so that an
+// existing file with looser permissions is replaced, and a partial file is never left behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
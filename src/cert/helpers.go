@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"certificateManager/environment"
 	"fmt"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
@@ -31,10 +32,15 @@ type CertificateStruct struct {
 	EmailAddresses     []string `json:"EmailAddresses,omitempty"`
 	Duration           int      `json:"Duration"`
 	KeyUsage           []string `json:"KeyUsage"`
+	ExtKeyUsage        []string `json:"ExtKeyUsage,omitempty"`
+	Profile            string   `json:"Profile,omitempty"`
+	KeyAlgorithm       string   `json:"KeyAlgorithm,omitempty"`
 	DNSNames           []string `json:"DNSNames,omitempty"`
 	IPAddresses        []net.IP `json:"IPAddresses,omitempty"`
 	CertificateName    string   `json:"CertificateName"`
-	SerialNumber       uint64   `json:"SerialNumber"`
+	Issuer             string   `json:"Issuer,omitempty"`
+	OCSPServers        []string `json:"OCSPServers,omitempty"`
+	SerialNumber       *big.Int `json:"SerialNumber"`
 	Comments           []string `json:"Comments,omitempty"`
 }
 
@@ -50,11 +56,13 @@ func CreateSampleCert() error {
 		EmailAddresses:     []string{"cert@myorg.net", "cert@org,net"},
 		Duration:           10,
 		KeyUsage:           []string{"cert sign", "crl sign", "digital signature"},
+		Profile:            "ca",
+		KeyAlgorithm:       "rsa",
 		DNSNames:           []string{"myorg.net", "myorg.com", "lan.myorg.net"},
 		IPAddresses:        []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1")},
 		CertificateName:    "sampleCert",
 		IsCA:               true,
-		SerialNumber:       1,
+		SerialNumber:       big.NewInt(1),
 		Comments: []string{"To see which values to put in the KeyUsage field, see https://pkg.go.dev/crypto/x509#KeyUsage",
 			"Strip off 'KeyUsage' from the const name and there you go.",
 			"",
@@ -81,11 +89,16 @@ func CreateExplanationfile() error {
 	"EmailAddresses" : ["cert@myorg.net", "cert@org.net"], -> Email addresses responsible for this cert
 	"Duration" : 10, -> CA duration, in years
 	"KeyUsage" : ["Digital Signature", "Certificate Sign", "CRL Sign"], -> Certificate usage. This here are common values for CAs
+	"ExtKeyUsage" : ["server auth"], -> Optional extended key usage: server auth, client auth, code signing, email protection, ocsp signing, time stamping, any
+	"Profile" : "ca", -> Optional profile (see profiles.json in the certificate root dir) that sets KeyUsage, ExtKeyUsage and the default Duration
+	"KeyAlgorithm" : "rsa", -> Private key algorithm: rsa (default, size set with -b), ecdsa-p256, ecdsa-p384 or ed25519
 	"DNSNames" : ["myorg.net","myorg.com","lan.myorg.net"], -> DNS names assigned to this cert
 	"IPAddresses" : ["10.1.1.11", "127.0.0.1"], -> IP addresses assigned to this cert (never a good idea to assign IPs to a CA)
 	"CertificateName" : "sample_cert", -> cert filename, no extension to the filename
 	"IsCA": true, -> Are we creating a CA or a "normal" server cert ?
-	"SerialNumber": this is an unsigned int64, handled by the software; put here any positive value
+	"Issuer": "", -> CertificateName of the CA signing this cert; empty means the root CA. A CA with an issuer is an intermediate CA
+	"OCSPServers": ["http://ocsp.myorg.net:8080"], -> Optional OCSP responder URLs, embedded in the certificate's AIA extension (see cm ocsp serve)
+	"SerialNumber": this is an integer of any size (128-bit with the random serial number strategy), handled by the software; put here any positive value
 	"Comments": ["To see which values to put in the KeyUsage field, see https://pkg.go.dev/crypto/x509#KeyUsage", "Strip off 'KeyUsage' from the const name and there you go.", "", "Please note that this field offers no functionality and is strictly here for documentation purposes"] -> Those won't appear in the certificate file
 }`
 
@@ -109,11 +122,16 @@ func createCertificateRootDirectories() error {
 	if err != nil {
 		return err
 	}
+	return createCertificateDirectories(e)
+}
+
+// createCertificateDirectories() : same as above, for the given environment
+func createCertificateDirectories(e environment.EnvironmentStruct) error {
 	dirRange := []string{e.RootCAdir, e.CertificatesConfigDir, filepath.Join(e.ServerCertsDir, "private"), filepath.Join(e.ServerCertsDir, "csr"),
 		filepath.Join(e.ServerCertsDir, "certs"), filepath.Join(e.ServerCertsDir, "java")}
 
 	for _, directory := range dirRange {
-		if err = os.MkdirAll(directory, os.ModePerm); err != nil {
+		if err := os.MkdirAll(directory, os.ModePerm); err != nil {
 			return err
 		}
 	}
@@ -10,30 +10,126 @@ package cert
 import (
 	"bufio"
 	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto/rand"
 	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// indexEntry : a parsed index.txt line
+type indexEntry struct {
+	Status         string    // V (valid), R (revoked), E (expired)
+	Date           time.Time // expiration date; older releases of this tool recorded the creation date instead
+	RevocationDate time.Time
+	Reason         string
+	Serial         string
+	Subject        string
+}
+
+// SerialNumber() : returns the hex serial of the entry as a big integer
+func (ie indexEntry) SerialNumber() (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(ie.Serial, 16)
+	if !ok {
+		return nil, helpers.CustomError{Message: "Invalid serial number in index.txt: " + ie.Serial}
+	}
+	return serial, nil
+}
+
+// String() : formats the entry as an OpenSSL index.txt line:
+// status, expiration date, revocation date[,reason], serial, filename (always "unknown"), subject
+func (ie indexEntry) String() string {
+	revoked := ""
+	if !ie.RevocationDate.IsZero() {
+		revoked = formatIndexTime(ie.RevocationDate)
+		if ie.Reason != "" {
+			revoked += "," + ie.Reason
+		}
+	}
+	return strings.Join([]string{ie.Status, formatIndexTime(ie.Date), revoked, ie.Serial, "unknown", ie.Subject}, "\t")
+}
+
+// formatIndexTime() : the reverse of parseIndexTime(), below
+func formatIndexTime(t time.Time) string {
+	if t.UTC().Year() >= 2050 {
+		return t.UTC().Format("20060102150405Z")
+	}
+	return t.UTC().Format("060102150405Z")
+}
+
+// parseIndexTime() : index.txt timestamps are ASN.1 UTCTime (YYMMDDHHMMSSZ), or GeneralizedTime (YYYYMMDDHHMMSSZ) past 2049
+func parseIndexTime(value string) (time.Time, error) {
+	if len(value) == 15 {
+		return time.Parse("20060102150405Z", value)
+	}
+	return time.Parse("060102150405Z", value)
+}
+
+// readIndexFile() : loads the index.txt database of the CA (caDir)
+// We read both the OpenSSL layout (status, expiration date, revocation date[,reason], serial, filename, subject)
+// and the layout written by older releases of this tool, which had no revocation date column
+// A missing index.txt is an empty database
+func readIndexFile(caDir string) ([]indexEntry, error) {
+	var entries []indexEntry
+
+	ndxFile, err := os.Open(filepath.Join(caDir, "index.txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer ndxFile.Close()
+
+	scanner := bufio.NewScanner(ndxFile)
+	for scanner.Scan() {
+		var entry indexEntry
+		fields := strings.Split(scanner.Text(), "\t")
+		switch {
+		case len(fields) >= 6 && fields[4] == "unknown":
+			entry.Serial = fields[3]
+			entry.Subject = strings.Join(fields[5:], " ")
+			if revoked := strings.SplitN(fields[2], ",", 2); revoked[0] != "" {
+				if entry.RevocationDate, err = parseIndexTime(revoked[0]); err != nil {
+					return nil, helpers.CustomError{Message: "Invalid revocation date in index.txt: " + fields[2]}
+				}
+				if len(revoked) == 2 {
+					entry.Reason = revoked[1]
+				}
+			}
+		case len(fields) >= 5 && fields[3] == "unknown":
+			entry.Serial = fields[2]
+			entry.Subject = strings.Join(fields[4:], " ")
+		default:
+			continue
+		}
+		entry.Status = fields[0]
+		if entry.Date, err = parseIndexTime(fields[1]); err != nil {
+			return nil, helpers.CustomError{Message: "Invalid date in index.txt: " + fields[1]}
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 // writeAttributeFile() : this function is trivial in the sense that we simply ensure that  index.attr.old
 // holds the same value every run, which is : "unique_subject = yes". This is used for a CA functionality that this software does
 // not act upon
 // Parameters:
-// - none
+// - caDir (string) : the directory of the issuing CA (RootCAdir, or the intermediate CA's directory)
 // Returns : the eventual IO error, if any
-func writeAttributeFile() error {
-	e, err := environment.LoadEnvironmentFile()
-	if err != nil {
-		return err
-	}
-
-	ffile, err := os.Create(filepath.Join(e.RootCAdir, "index.txt.attr"))
+func writeAttributeFile(caDir string) error {
+	ffile, err := os.Create(filepath.Join(caDir, "index.txt.attr"))
 	if err != nil {
 		return err
 	}
+	defer ffile.Close()
 	_, err = ffile.WriteString("unique_subject = yes")
 	if err != nil {
 		return err
@@ -41,93 +137,88 @@ func writeAttributeFile() error {
 	return nil
 }
 
-func writeIndexFile(c CertificateStruct) error {
-	var filedesc *os.File
-	e, err := environment.LoadEnvironmentFile()
-	if err != nil {
-		return err
-	}
-
-	if _, err = os.Stat(filepath.Join(e.RootCAdir, "index.txt")); os.IsNotExist(err) {
-		if filedesc, err = os.Create(filepath.Join(e.RootCAdir, "index.txt")); err != nil {
-			return err
-		}
-		defer filedesc.Close()
-		newline := fmt.Sprintf("V\t%sZ\t%s\tunknown\t/C=%s/ST=%s/L=%s/O=%s/OU=%s/CN=%s/emailAddress=%s", time.Now().UTC().Format("060102150405"),
-			fmt.Sprintf("%04x", c.SerialNumber), c.Country, c.Province, c.Locality, c.Organization, c.OrganizationalUnit, c.CommonName, c.EmailAddresses[0])
-		if _, err = filedesc.WriteString(newline); err != nil {
-			return err
-		}
-	} else {
-		if err := replaceStringInIndex(c, e.RootCAdir); err != nil {
+// registerCertificate() : records a newly issued certificate in its issuing CA's database (caDir):
+// serial, index.txt.attr and index.txt
+// Random serial numbers do not touch the serial file, which keeps counting the sequential ones
+func registerCertificate(env environment.EnvironmentStruct, c CertificateStruct, caDir string) error {
+	if env.SerialNumberStrategy() == environment.SerialSequential {
+		if err := setSerialNumber(c.SerialNumber, caDir); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := writeAttributeFile(caDir); err != nil {
+		return err
+	}
+	return writeIndexFile(c, caDir)
 }
 
-func replaceStringInIndex(c CertificateStruct, sourcedir string) error {
-	string2replace := fmt.Sprintf("/C=%s/ST=%s/L=%s/O=%s/OU=%s/CN=%s/emailaddress=%s", c.Country, c.Province,
-		c.Locality, c.Organization, c.OrganizationalUnit, c.CommonName, c.EmailAddresses[0])
-
-	sf, err := os.Open(filepath.Join(sourcedir, "index.txt"))
+// writeIndexFile() : adds the certificate to the index.txt database of its issuing CA (caDir)
+// A valid entry with the same subject is replaced; revoked entries are kept, as they still belong in the CRL
+func writeIndexFile(c CertificateStruct, caDir string) error {
+	entries, err := readIndexFile(caDir)
 	if err != nil {
 		return err
 	}
-	defer sf.Close()
-	of, err := os.Create(filepath.Join(sourcedir, "index.txt.tmp"))
+
+	subject := c.indexSubject()
+	kept := make([]indexEntry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if entry.Status == "V" && entry.Subject == subject {
+			continue // we will not write this line in the target file
+		}
+		kept = append(kept, entry)
+	}
+	kept = append(kept, indexEntry{Status: "V", Date: time.Now().AddDate(c.Duration, 0, 0),
+		Serial: formatSerial(c.SerialNumber), Subject: subject})
+
+	return writeIndexEntries(caDir, kept)
+}
+
+// writeIndexEntries() : rewrites the whole index.txt database of the CA (caDir) in the OpenSSL layout
+// We write to a temporary file first, so a failure never leaves a truncated database behind
+func writeIndexEntries(caDir string, entries []indexEntry) error {
+	of, err := os.Create(filepath.Join(caDir, "index.txt.tmp"))
 	if err != nil {
 		return err
 	}
 	defer of.Close()
 
-	scanner := bufio.NewScanner(sf)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, string2replace) {
-			continue // we will not write this line in the target file
-		}
-		_, err := fmt.Fprintln(of, line)
-		if err != nil {
+	for _, entry := range entries {
+		if _, err = fmt.Fprintln(of, entry.String()); err != nil {
 			return err
 		}
 	}
-	newline := fmt.Sprintf("V\t%sZ\t%s\tunknown\t%s", time.Now().UTC().Format("060102150405"),
-		fmt.Sprintf("%04X", c.SerialNumber), string2replace)
-	_, err = fmt.Fprintln(of, newline)
-	if err != nil {
-		return err
-	}
-	if err := os.Rename(filepath.Join(sourcedir, "index.txt.tmp"), filepath.Join(sourcedir, "index.txt")); err != nil {
+	if err = of.Close(); err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(filepath.Join(caDir, "index.txt.tmp"), filepath.Join(caDir, "index.txt"))
+}
+
+// indexSubject() : the certificate's subject, as written in index.txt
+func (c CertificateStruct) indexSubject() string {
+	return fmt.Sprintf("/C=%s/ST=%s/L=%s/O=%s/OU=%s/CN=%s/emailAddress=%s", c.Country, c.Province,
+		c.Locality, c.Organization, c.OrganizationalUnit, c.CommonName, c.EmailAddresses[0])
 }
 
 // getSerialNumber() : returns the current serial number on file (typically in CertificateRootDir/RootCAdir/serial)
 // Parameters:
-// - none
+// - caDir (string) : the directory of the issuing CA (RootCAdir, or the intermediate CA's directory)
 // Returns:
-// - uint64 representing the decimal value of the serial number, or zero if error
+// - the serial number, or zero if error
 // - the error code
-func getSerialNumber() (uint64, error) {
-	// We need the environment file
-	e, err := environment.LoadEnvironmentFile()
-	if err != nil {
-		return 0, err
-	}
-	serialPath := filepath.Join(e.RootCAdir, "serial")
+func getSerialNumber(caDir string) (*big.Int, error) {
+	serialPath := filepath.Join(caDir, "serial")
 
 	// if the serial file does not exist, this means we are using a brand new setup,
 	// thus the serial # is 1
-	_, err = os.Stat(serialPath)
+	_, err := os.Stat(serialPath)
 	if os.IsNotExist(err) {
-		return 0, nil
+		return big.NewInt(0), nil
 	}
 	// Read serial from file
 	content, err := os.ReadFile(serialPath)
 	if err != nil {
-		return 0, err
+		return big.NewInt(0), err
 	}
 
 	// Convert content to a string and remove any leading/trailing whitespace
@@ -137,32 +228,77 @@ func getSerialNumber() (uint64, error) {
 		hexString = "0"
 	}
 
-	// Convert hexadecimal string to a uint64
-	decimalValue, err := strconv.ParseUint(hexString, 16, 64)
-	if err != nil {
-		decimalValue = 0
+	// Convert hexadecimal string to a big integer: serials are not bound to 64 bits
+	serial, ok := new(big.Int).SetString(hexString, 16)
+	if !ok {
+		return big.NewInt(0), helpers.CustomError{Message: "Invalid serial number in " + serialPath + ": " + hexString}
 	}
-	return decimalValue, err
+	return serial, nil
 }
 
 // setSerialNumber() : Sets the serial value on file (typically in CertificateRootDir/RootCAdir/serial)
-// We will also keep a backup of the serial file
-func setSerialNumber(serialNo uint64) error {
-	// We need the environment file
-	e, err := environment.LoadEnvironmentFile()
-	if err != nil {
-		return err
-	}
-
-	ffile, err := os.Create(filepath.Join(e.RootCAdir, "serial"))
+func setSerialNumber(serialNo *big.Int, caDir string) error {
+	ffile, err := os.Create(filepath.Join(caDir, "serial"))
 	if err != nil {
 		return err
 	}
 	defer ffile.Close()
 
-	_, err = ffile.WriteString(fmt.Sprintf("%04X\n", serialNo))
+	_, err = ffile.WriteString(formatSerial(serialNo) + "\n")
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// nextSerialNumber() : the serial number of the next certificate issued by the CA (caDir), as per the environment's
+// Serial number strategy:
+// - sequential : the serial on file, plus one
+// - random : 128 random bits, drawn again in the (very) unlikely case where the CA already issued that serial
+func nextSerialNumber(env environment.EnvironmentStruct, caDir string) (*big.Int, error) {
+	switch env.SerialNumberStrategy() {
+	case environment.SerialSequential:
+		serial, err := getSerialNumber(caDir)
+		if err != nil {
+			return nil, err
+		}
+		return serial.Add(serial, big.NewInt(1)), nil
+	case environment.SerialRandom:
+		entries, err := readIndexFile(caDir)
+		if err != nil {
+			return nil, err
+		}
+		limit := new(big.Int).Lsh(big.NewInt(1), 128)
+		for {
+			serial, err := rand.Int(rand.Reader, limit)
+			if err != nil {
+				return nil, err
+			}
+			if serial.Sign() > 0 && !serialInIndex(entries, serial) {
+				return serial, nil
+			}
+		}
+	}
+	return nil, helpers.CustomError{Message: "Unknown serial number strategy: " + helpers.Red(env.SerialStrategy) +
+		" (valid strategies are " + environment.SerialSequential + " and " + environment.SerialRandom + ")"}
+}
+
+// serialInIndex() : whether the serial number is already in the index.txt entries
+func serialInIndex(entries []indexEntry, serial *big.Int) bool {
+	for _, entry := range entries {
+		if entrySerial, err := entry.SerialNumber(); err == nil && entrySerial.Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSerial() : the serial number as written in serial, index.txt and newcerts/: uppercase hex, at least 4 digits,
+// And an even number of them, like OpenSSL does
+func formatSerial(serial *big.Int) string {
+	hex := fmt.Sprintf("%04X", serial)
+	if len(hex)%2 != 0 {
+		hex = "0" + hex
+	}
+	return hex
+}
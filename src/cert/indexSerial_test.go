@@ -0,0 +1,199 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/indexSerial_test.go
+// Original timestamp: 2026/10/19 09:35
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestIndex() : a CA directory whose index.txt holds the lines
+func writeTestIndex(t *testing.T, lines string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.txt"), []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Both the OpenSSL layout (6 columns) and the one of the older releases (5 columns, no revocation date) are read
+func TestReadIndexFile(t *testing.T) {
+	date := time.Date(2027, 10, 18, 12, 0, 0, 0, time.UTC)
+	revoked := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		want indexEntry
+	}{
+		{"openssl, valid", "V\t271018120000Z\t\t0A1B\tunknown\t/CN=web", indexEntry{Status: "V", Date: date, Serial: "0A1B", Subject: "/CN=web"}},
+		{"openssl, revoked", "R\t271018120000Z\t261018093000Z\t0A1B\tunknown\t/CN=web",
+			indexEntry{Status: "R", Date: date, RevocationDate: revoked, Serial: "0A1B", Subject: "/CN=web"}},
+		{"openssl, revoked with a reason", "R\t271018120000Z\t261018093000Z,keyCompromise\t0A1B\tunknown\t/CN=web",
+			indexEntry{Status: "R", Date: date, RevocationDate: revoked, Reason: "keyCompromise", Serial: "0A1B", Subject: "/CN=web"}},
+		{"openssl, past 2049", "V\t20601018120000Z\t\t0A1B\tunknown\t/CN=web",
+			indexEntry{Status: "V", Date: time.Date(2060, 10, 18, 12, 0, 0, 0, time.UTC), Serial: "0A1B", Subject: "/CN=web"}},
+		{"openssl, tab in the subject", "V\t271018120000Z\t\t0A1B\tunknown\t/CN=web\tserver", indexEntry{Status: "V", Date: date, Serial: "0A1B", Subject: "/CN=web server"}},
+		{"older releases", "V\t271018120000Z\t0A1B\tunknown\t/CN=web", indexEntry{Status: "V", Date: date, Serial: "0A1B", Subject: "/CN=web"}},
+		{"older releases, revoked", "R\t271018120000Z\t0A1B\tunknown\t/CN=web", indexEntry{Status: "R", Date: date, Serial: "0A1B", Subject: "/CN=web"}},
+	}
+	for _, tt := range tests {
+		entries, err := readIndexFile(writeTestIndex(t, tt.line+"\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(entries) != 1 {
+			t.Errorf("%s: %d entries", tt.name, len(entries))
+			continue
+		}
+		got := entries[0]
+		if got.Status != tt.want.Status || !got.Date.Equal(tt.want.Date) || !got.RevocationDate.Equal(tt.want.RevocationDate) ||
+			got.Reason != tt.want.Reason || got.Serial != tt.want.Serial || got.Subject != tt.want.Subject {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// Lines in neither layout are skipped, a missing database is empty, and a bad date is an error
+	entries, err := readIndexFile(writeTestIndex(t, "\ngarbage\nV\t271018120000Z\t0A1B\tunknown\t/CN=web\n"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("skipped lines: got %d entries, %v", len(entries), err)
+	}
+	if entries, err = readIndexFile(t.TempDir()); err != nil || len(entries) != 0 {
+		t.Errorf("missing index.txt: got %d entries, %v", len(entries), err)
+	}
+	for _, line := range []string{"V\t2710181200\t\t0A1B\tunknown\t/CN=web", "R\t271018120000Z\tyesterday\t0A1B\tunknown\t/CN=web",
+		"V\tnever\t0A1B\tunknown\t/CN=web"} {
+		if _, err = readIndexFile(writeTestIndex(t, line+"\n")); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+// The database is always written back in the OpenSSL layout, whatever the layout it was read in
+func TestWriteIndexEntries(t *testing.T) {
+	dir := writeTestIndex(t, "V\t271018120000Z\t0A1B\tunknown\t/CN=old\n"+
+		"R\t271018120000Z\t261018093000Z,superseded\t0A1C\tunknown\t/CN=web\n"+
+		"V\t20601018120000Z\t\t0A1D\tunknown\t/CN=web\n")
+	want := "V\t271018120000Z\t\t0A1B\tunknown\t/CN=old\n" +
+		"R\t271018120000Z\t261018093000Z,superseded\t0A1C\tunknown\t/CN=web\n" +
+		"V\t20601018120000Z\t\t0A1D\tunknown\t/CN=web\n"
+
+	entries, err := readIndexFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeIndexEntries(dir, entries); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "index.txt.tmp")); !os.IsNotExist(err) {
+		t.Error("index.txt.tmp was left behind")
+	}
+
+	// Read again, the entries are the same
+	reread, err := readIndexFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		if reread[i].String() != entries[i].String() {
+			t.Errorf("entry %d: got %q, want %q", i, reread[i].String(), entries[i].String())
+		}
+	}
+}
+
+// Sequential serial numbers follow the serial file; a new CA starts at 1
+func TestNextSerialNumberSequential(t *testing.T) {
+	dir := t.TempDir()
+	for _, strategy := range []string{"", environment.SerialSequential, "Sequential"} {
+		env := environment.EnvironmentStruct{SerialStrategy: strategy}
+		if err := os.Remove(filepath.Join(dir, "serial")); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		serial, err := nextSerialNumber(env, dir)
+		if err != nil || serial.Cmp(big.NewInt(1)) != 0 {
+			t.Fatalf("%q, new CA: got %v, %v, want 1", strategy, serial, err)
+		}
+		if err = setSerialNumber(big.NewInt(0xFF), dir); err != nil {
+			t.Fatal(err)
+		}
+		if serial, err = nextSerialNumber(env, dir); err != nil || serial.Cmp(big.NewInt(0x100)) != 0 {
+			t.Errorf("%q, after 0xFF: got %v, %v, want 0x100", strategy, serial, err)
+		}
+	}
+
+	// Serial numbers are not bound to 64 bits
+	huge, _ := new(big.Int).SetString("FFFFFFFFFFFFFFFFFF", 16)
+	if err := setSerialNumber(huge, dir); err != nil {
+		t.Fatal(err)
+	}
+	serial, err := nextSerialNumber(environment.EnvironmentStruct{}, dir)
+	if err != nil || serial.Cmp(new(big.Int).Add(huge, big.NewInt(1))) != 0 {
+		t.Errorf("after %X: got %v, %v", huge, serial, err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "serial"), []byte("not hex\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nextSerialNumber(environment.EnvironmentStruct{}, dir); err == nil {
+		t.Error("an invalid serial file was accepted")
+	}
+}
+
+// Random serial numbers are positive, fit in 128 bits, leave the serial file alone, and are not already in index.txt
+func TestNextSerialNumberRandom(t *testing.T) {
+	dir := writeTestIndex(t, "V\t271018120000Z\t\t0A1B\tunknown\t/CN=web\n")
+	env := environment.EnvironmentStruct{SerialStrategy: environment.SerialRandom}
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	entries, err := readIndexFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		serial, err := nextSerialNumber(env, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial.Sign() <= 0 || serial.Cmp(limit) >= 0 {
+			t.Errorf("%X: out of range", serial)
+		}
+		if serialInIndex(entries, serial) || seen[serial.String()] {
+			t.Errorf("%X: drawn twice", serial)
+		}
+		seen[serial.String()] = true
+	}
+	if _, err = os.Stat(filepath.Join(dir, "serial")); !os.IsNotExist(err) {
+		t.Error("the random strategy wrote a serial file")
+	}
+
+	if !serialInIndex(entries, big.NewInt(0x0A1B)) || serialInIndex(entries, big.NewInt(0x0A1C)) {
+		t.Error("serialInIndex does not find the serial numbers of index.txt")
+	}
+	if _, err = nextSerialNumber(environment.EnvironmentStruct{SerialStrategy: "timestamp"}, dir); err == nil {
+		t.Error("an unknown strategy was accepted")
+	}
+}
+
+func TestFormatSerial(t *testing.T) {
+	for serial, want := range map[int64]string{1: "0001", 0xFF: "00FF", 0xABCDE: "0ABCDE", 0x123456: "123456"} {
+		if got := formatSerial(big.NewInt(serial)); got != want {
+			t.Errorf("%X: got %s, want %s", serial, got, want)
+		}
+	}
+}
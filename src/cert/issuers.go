@@ -0,0 +1,216 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/issuers.go
+// Original timestamp: 2026/10/18 09:12
+
+// Manages the issuing CAs: the root CA, which sits in RootCAdir, and the intermediate CAs, which
+// sit in RootCAdir/intermediates/NAME, each with its own key, serial, index.txt and newcerts/
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var CertIssuer = ""
+
+// issuingCA : everything needed to sign a certificate with a given CA
+type issuingCA struct {
+	Name  string
+	Dir   string
+	Cert  *x509.Certificate
+	Key   crypto.Signer
+	Chain []*x509.Certificate // the CA itself, followed by its own issuers, up to the root CA
+}
+
+// intermediatesDir : the directory holding all intermediate CAs
+func intermediatesDir(env environment.EnvironmentStruct) string {
+	return filepath.Join(env.RootCAdir, "intermediates")
+}
+
+// caDirectory : returns the directory where a CA certificate stores its own files
+// A CA without an issuer is a self-signed root CA
+func (c CertificateStruct) caDirectory(env environment.EnvironmentStruct) string {
+	if c.Issuer == "" {
+		return env.RootCAdir
+	}
+	return filepath.Join(intermediatesDir(env), c.CertificateName)
+}
+
+// caDirectories : returns the directories of all CAs: the root CA, then the intermediate CAs
+func caDirectories(env environment.EnvironmentStruct) ([]string, error) {
+	dirs := []string{env.RootCAdir}
+	intermediates, err := os.ReadDir(intermediatesDir(env))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dirs, nil
+		}
+		return nil, err
+	}
+	for _, dir := range intermediates {
+		if dir.IsDir() {
+			dirs = append(dirs, filepath.Join(intermediatesDir(env), dir.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// issuerDirectory : returns the directory of the named issuing CA
+// An empty issuer name means the root CA
+func issuerDirectory(env environment.EnvironmentStruct, issuer string) (string, error) {
+	if issuer == "" {
+		return env.RootCAdir, nil
+	}
+	if _, err := os.Stat(filepath.Join(env.RootCAdir, issuer+".crt")); err == nil {
+		return env.RootCAdir, nil
+	}
+	dir := filepath.Join(intermediatesDir(env), issuer)
+	if _, err := os.Stat(filepath.Join(dir, issuer+".crt")); err != nil {
+		return "", helpers.CustomError{Message: "Unknown issuer: " + helpers.Red(issuer), Err: ErrUnknownIssuer}
+	}
+	return dir, nil
+}
+
+// caCertificates : the certificates of all CAs (root and intermediates), keyed by their directory
+func caCertificates(env environment.EnvironmentStruct) (map[string]*x509.Certificate, error) {
+	caCerts := make(map[string]*x509.Certificate)
+
+	caDirs, err := caDirectories(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range caDirs {
+		// The CA certificates are named after their directory, or sit directly in RootCAdir
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.crt"))
+		for _, match := range matches {
+			crt, err := readCertificateFile(match)
+			if err != nil {
+				return nil, err
+			}
+			caCerts[dir] = crt
+		}
+	}
+	return caCerts, nil
+}
+
+// rootCAName : the root CA is the single .crt file in RootCAdir
+func rootCAName(env environment.EnvironmentStruct) (string, error) {
+	caCertFiles, err := filepath.Glob(filepath.Join(env.RootCAdir, "*.crt"))
+	if err != nil {
+		return "", helpers.CustomError{Message: "Error listing CA certificate files: " + err.Error(), Err: ErrUnknownIssuer}
+	}
+	if len(caCertFiles) != 1 {
+		return "", helpers.CustomError{Message: "Expected one CA certificate file, found " + helpers.Red(fmt.Sprintf("%d", len(caCertFiles))), Err: ErrUnknownIssuer}
+	}
+	return strings.TrimSuffix(filepath.Base(caCertFiles[0]), filepath.Ext(filepath.Base(caCertFiles[0]))), nil
+}
+
+// loadIssuer : loads, decodes and parses the named CA certificate, its private key and its chain
+// The private key is on the PKCS#11 token, or in the CA's key file
+func loadIssuer(pki *CA, issuer string) (issuingCA, error) {
+	ca, err := loadIssuerChain(pki.env, issuer)
+	if err != nil {
+		return issuingCA{}, err
+	}
+	if ca.Key, err = pki.caKey(ca.Name, ca.Dir); err != nil {
+		return issuingCA{}, err
+	}
+	return ca, nil
+}
+
+// loadIssuerChain : loads the named CA certificate and its chain, without its private key
+func loadIssuerChain(env environment.EnvironmentStruct, issuer string) (issuingCA, error) {
+	var caCertPEM []byte
+	var err error
+	ca := issuingCA{Name: issuer}
+
+	if ca.Name == "" {
+		if ca.Name, err = rootCAName(env); err != nil {
+			return issuingCA{}, err
+		}
+	}
+	if ca.Dir, err = issuerDirectory(env, ca.Name); err != nil {
+		return issuingCA{}, err
+	}
+
+	// Load, decode and parse the CA cert file
+	if caCertPEM, err = os.ReadFile(filepath.Join(ca.Dir, ca.Name+".crt")); err != nil {
+		return issuingCA{}, helpers.CustomError{Message: "Error reading CA certificate: " + err.Error()}
+	}
+	caCertBlock, _ := pem.Decode(caCertPEM)
+	if caCertBlock == nil {
+		return issuingCA{}, helpers.CustomError{Message: "Error PEM-decoding the CA certificate"}
+	}
+	if ca.Cert, err = x509.ParseCertificate(caCertBlock.Bytes); err != nil {
+		return issuingCA{}, err
+	}
+
+	// Intermediate CAs keep their chain (themselves + their issuers) in chain.pem; a root CA is its own chain
+	ca.Chain = []*x509.Certificate{ca.Cert}
+	if chainPEM, err := os.ReadFile(filepath.Join(ca.Dir, "chain.pem")); err == nil {
+		if ca.Chain, err = parseCertificates(chainPEM); err != nil {
+			return issuingCA{}, err
+		}
+	}
+	return ca, nil
+}
+
+// parseCertificates : parses all CERTIFICATE blocks of a PEM bundle
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, bundle = pem.Decode(bundle); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, crt)
+	}
+	if len(certs) == 0 {
+		return nil, helpers.CustomError{Message: "No certificate found in the PEM bundle"}
+	}
+	return certs, nil
+}
+
+// readCertificateFile : loads and parses the first certificate of a PEM file
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, helpers.CustomError{Message: path + ": " + err.Error()}
+	}
+	return certs[0], nil
+}
+
+// writeCertificates : PEM-encodes the certificates, in order, into a single file
+func writeCertificates(path string, certs []*x509.Certificate) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, crt := range certs {
+		if err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
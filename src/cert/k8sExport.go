@@ -0,0 +1,179 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/k8sExport.go
+// Original timestamp: 2026/10/19 04:00
+
+// Kubernetes manifests for `cm cert export`: a kubernetes.io/tls Secret holding the certificate, or the Secret
+// And cert-manager Issuer (or ClusterIssuer) of a root or intermediate CA, built from the files signCert writes in
+// The CA directory (NAME.crt, chain.pem, and the CA key)
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/helpers"
+	"crypto/x509"
+	"encoding/base64"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+var CertExportK8s = false
+var CertExportCertManager = false
+var CertExportNamespace = ""
+var CertExportLabels []string
+var CertExportSecretName = ""
+var CertExportClusterIssuer = false
+
+// cert-manager reads the secrets of its ClusterIssuers from its own namespace, which is this one by default
+const certManagerNamespace = "cert-manager"
+
+// Kubernetes object names are DNS-1123 subdomains; label names may have a DNS-1123 prefix
+var k8sNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+var k8sLabelRegexp = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type certManagerIssuer struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   k8sMetadata `yaml:"metadata"`
+	Spec       struct {
+		CA struct {
+			SecretName string `yaml:"secretName"`
+		} `yaml:"ca"`
+	} `yaml:"spec"`
+}
+
+// encodeK8sSecret() : the kubernetes.io/tls Secret of the certificate: tls.crt is the full chain, tls.key the
+// Unencrypted private key, and ca.crt the root CA
+func encodeK8sSecret(b exportBundle) ([]byte, error) {
+	metadata, err := k8sExportMetadata(b.Name, "-tls", CertExportNamespace)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := tlsSecret(metadata, b)
+	if err != nil {
+		return nil, err
+	}
+	return encodeK8sManifests(secret)
+}
+
+// encodeCertManagerIssuer() : the Secret holding the CA certificate and key, and the cert-manager CA Issuer using it
+// A ClusterIssuer reads its secret from the cert-manager namespace, unless --namespace says otherwise
+func encodeCertManagerIssuer(b exportBundle) ([]byte, error) {
+	if !b.Cert.IsCA {
+		return nil, helpers.CustomError{Message: helpers.Red(b.Name) + " is not a CA: cert-manager CA issuers need a root or intermediate CA", Err: ErrInvalidCertificate}
+	}
+
+	namespace := CertExportNamespace
+	if CertExportClusterIssuer && namespace == "" {
+		namespace = certManagerNamespace
+	}
+	metadata, err := k8sExportMetadata(b.Name, "-ca", namespace)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := tlsSecret(metadata, b)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := certManagerIssuer{APIVersion: "cert-manager.io/v1", Kind: "Issuer",
+		Metadata: k8sMetadata{Name: k8sObjectName(b.Name), Namespace: CertExportNamespace, Labels: metadata.Labels}}
+	if CertExportClusterIssuer {
+		issuer.Kind = "ClusterIssuer"
+		issuer.Metadata.Namespace = ""
+	}
+	issuer.Spec.CA.SecretName = metadata.Name
+	return encodeK8sManifests(secret, issuer)
+}
+
+// tlsSecret() : the kubernetes.io/tls Secret of the bundle; the key must be exportable, thus not on a PKCS#11 token
+func tlsSecret(metadata k8sMetadata, b exportBundle) (k8sSecret, error) {
+	keyPEM, err := encodePKCS8PEM(b.Key)
+	if err != nil {
+		return k8sSecret{}, helpers.CustomError{Message: "The private key of " + helpers.Red(b.Name) + " cannot be exported (is it held by a PKCS#11 token?): " + err.Error()}
+	}
+	chain := append([]*x509.Certificate{b.Cert}, b.Chain...)
+
+	return k8sSecret{APIVersion: "v1", Kind: "Secret", Metadata: metadata, Type: "kubernetes.io/tls",
+		Data: map[string]string{
+			"tls.crt": base64.StdEncoding.EncodeToString(encodeCertificatesPEM(chain)),
+			"tls.key": base64.StdEncoding.EncodeToString(keyPEM),
+			"ca.crt":  base64.StdEncoding.EncodeToString(encodeCertificatesPEM(chain[len(chain)-1:])),
+		}}, nil
+}
+
+// k8sExportMetadata() : the Secret's name (--secret-name, or the certificate name and the suffix), namespace and labels
+func k8sExportMetadata(name string, suffix string, namespace string) (k8sMetadata, error) {
+	metadata := k8sMetadata{Name: CertExportSecretName, Namespace: namespace}
+	if metadata.Name == "" {
+		metadata.Name = k8sObjectName(name) + suffix
+	}
+	if len(metadata.Name) > 253 || !k8sNameRegexp.MatchString(metadata.Name) {
+		return k8sMetadata{}, helpers.CustomError{Message: "Invalid Kubernetes secret name: " + helpers.Red(metadata.Name)}
+	}
+	if namespace != "" && (len(namespace) > 63 || strings.Contains(namespace, ".") || !k8sNameRegexp.MatchString(namespace)) {
+		return k8sMetadata{}, helpers.CustomError{Message: "Invalid Kubernetes namespace: " + helpers.Red(namespace)}
+	}
+
+	for _, label := range CertExportLabels {
+		key, value, ok := strings.Cut(label, "=")
+		prefix, labelName, hasPrefix := strings.Cut(key, "/")
+		if !hasPrefix {
+			prefix, labelName = "", key
+		}
+		if !ok || labelName == "" || len(labelName) > 63 || !k8sLabelRegexp.MatchString(labelName) || len(value) > 63 || !k8sLabelRegexp.MatchString(value) ||
+			(hasPrefix && (len(prefix) > 253 || !k8sNameRegexp.MatchString(prefix))) {
+			return k8sMetadata{}, helpers.CustomError{Message: "Invalid Kubernetes label: " + helpers.Red(label) + " (expected name=value)"}
+		}
+		if metadata.Labels == nil {
+			metadata.Labels = make(map[string]string)
+		}
+		metadata.Labels[key] = value
+	}
+	return metadata, nil
+}
+
+// k8sObjectName() : the certificate name, turned into a valid Kubernetes object name: web_Server.lan gives web-server.lan
+func k8sObjectName(name string) string {
+	var object strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			object.WriteRune(r)
+		} else {
+			object.WriteRune('-')
+		}
+	}
+	return strings.Trim(object.String(), "-.")
+}
+
+// encodeK8sManifests() : the objects as a multi-document YAML stream, ready for kubectl apply -f
+func encodeK8sManifests(objects ...interface{}) ([]byte, error) {
+	var manifests bytes.Buffer
+	encoder := yaml.NewEncoder(&manifests)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return manifests.Bytes(), nil
+}
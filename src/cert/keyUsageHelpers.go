@@ -6,6 +6,7 @@
 package cert
 
 import (
+	"certificateManager/helpers"
 	"crypto/x509"
 	"strings"
 )
@@ -95,3 +96,26 @@ func reindexKeyUsage(cfg CertificateStruct) x509.KeyUsage {
 	}
 	return getKeyUsageFromStrings(s)
 }
+
+// getExtKeyUsageFromStrings() : converts a slice of strings into x509.ExtKeyUsage values
+// Both the OpenSSL names (serverAuth) and the human-readable ones (server auth) are accepted
+func getExtKeyUsageFromStrings(usageStrings []string) ([]x509.ExtKeyUsage, error) {
+	var extKeyUsages []x509.ExtKeyUsage
+	normalize := func(s string) string {
+		return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(s))
+	}
+	for _, usage := range usageStrings {
+		found := false
+		for eku, name := range extKeyUsageNames {
+			if normalize(usage) == normalize(name) {
+				extKeyUsages = append(extKeyUsages, eku)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, helpers.CustomError{Message: "Unknown extended key usage: " + helpers.Red(usage)}
+		}
+	}
+	return extKeyUsages, nil
+}
@@ -14,8 +14,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// CertificateRecord : a certificate, as emitted by the machine-readable (--output) formats
+type CertificateRecord struct {
+	ConfigFile       string            `json:"ConfigFile"`
+	FileSize         int64             `json:"FileSize"`
+	ModificationTime time.Time         `json:"ModificationTime"`
+	CertificateFile  string            `json:"CertificateFile"`
+	Config           CertificateStruct `json:"Config"`
+	X509             *X509Details      `json:"X509"`
+}
+
 func ListCertificates() error {
 	// Read env file
 	var err error
@@ -30,20 +41,14 @@ func ListCertificates() error {
 	oldCfg := CertConfigFile
 
 	// list certificate files
-	err = filepath.Walk(env.CertificatesConfigDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
-			fileInfos = append(fileInfos, info)
-		}
-		return nil
-	})
-
-	if err != nil {
+	if fileInfos, err = certificateConfigFiles(env); err != nil {
 		return err
 	}
 
+	if helpers.StructuredOutput() {
+		return listCertificateRecords(env, fileInfos)
+	}
+
 	fmt.Printf("Number of certificates: %s\n", helpers.Green(fmt.Sprintf("%d", len(fileInfos))))
 
 	t := table.NewWriter()
@@ -70,6 +75,61 @@ func ListCertificates() error {
 	return nil
 }
 
+// certificateConfigFiles : the certificate config files of the environment
+func certificateConfigFiles(env environment.EnvironmentStruct) ([]os.FileInfo, error) {
+	var fileInfos []os.FileInfo
+
+	err := filepath.Walk(env.CertificatesConfigDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			fileInfos = append(fileInfos, info)
+		}
+		return nil
+	})
+	return fileInfos, err
+}
+
+// listCertificateRecords : the config files, along with the parsed certificates, when they were issued
+func listCertificateRecords(env environment.EnvironmentStruct, fileInfos []os.FileInfo) error {
+	records, err := certificateRecords(env, fileInfos)
+	if err != nil {
+		return err
+	}
+	return helpers.PrintRecords(records)
+}
+
+// certificateRecords : builds the records of the given config files
+func certificateRecords(env environment.EnvironmentStruct, fileInfos []os.FileInfo) ([]CertificateRecord, error) {
+	records := []CertificateRecord{}
+
+	for _, fi := range fileInfos {
+		c, err := loadCertificateConfig(env, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		record := CertificateRecord{ConfigFile: filepath.Join(env.CertificatesConfigDir, fi.Name()), FileSize: fi.Size(),
+			ModificationTime: fi.ModTime(), Config: c, CertificateFile: c.certificateFilePath(env)}
+		if crt, err := readCertificateFile(record.CertificateFile); err == nil {
+			details := newX509Details(crt)
+			record.X509 = &details
+		} else {
+			record.CertificateFile = ""
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// certificateFilePath : where the certificate of a config file is stored, once issued
+func (c CertificateStruct) certificateFilePath(env environment.EnvironmentStruct) string {
+	if c.IsCA {
+		return filepath.Join(c.caDirectory(env), c.CertificateName+".crt")
+	}
+	return filepath.Join(env.ServerCertsDir, "certs", c.CertificateName+".crt")
+}
+
 func fetchCN(domain string) (string, error) {
 	var domainCert CertificateStruct
 	var err error
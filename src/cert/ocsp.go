@@ -0,0 +1,382 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/ocsp.go
+// Original timestamp: 2026/10/18 13:05
+
+// A minimal RFC 6960 OCSP responder, answering from a CA's index.txt database,
+// and the creation of the delegated OCSP signing certificate it can use
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var OcspListen = ":8080"
+var OcspDelegated = false
+var OcspValidity = 60
+var CertOCSPServer = ""
+
+// id-pkix-ocsp-nocheck : tells clients not to check the revocation status of the delegated signer itself
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// ocspResponder : the CA we answer for, and the certificate + key used to sign the responses
+type ocspResponder struct {
+	ca         issuingCA
+	signerCert *x509.Certificate
+	signerKey  crypto.Signer
+	keyHash    []byte // SHA-1 of the CA's public key, as sent in most requests
+}
+
+// ocspSignerDir : the delegated OCSP signing certificate and key sit in the CA's ocsp/ subdirectory
+func ocspSignerDir(ca issuingCA) string {
+	return filepath.Join(ca.Dir, "ocsp")
+}
+
+// ServeOCSP :
+// Runs an OCSP responder over HTTP for the named CA (the root CA if no name is given)
+// Responses are signed by the CA itself, or by its delegated OCSP signing certificate if OcspDelegated is set
+// The index.txt database is re-read on every request, so revocations are seen immediately
+func ServeOCSP(caname string) error {
+	var err error
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	env := pki.env
+	// With a delegated signer, the CA key (possibly encrypted) is not needed: the responder can run unattended
+	var ca issuingCA
+	if OcspDelegated {
+		ca, err = loadIssuerChain(env, caname)
+	} else {
+		ca, err = loadIssuer(pki, caname)
+	}
+	if err != nil {
+		return err
+	}
+
+	responder := ocspResponder{ca: ca, signerCert: ca.Cert, signerKey: ca.Key}
+	if OcspDelegated {
+		if responder.signerCert, responder.signerKey, err = loadOCSPSigner(pki, ca); err != nil {
+			return err
+		}
+	}
+	// The OCSP package cannot sign with Ed25519 keys
+	if _, isEd25519 := responder.signerKey.(ed25519.PrivateKey); isEd25519 {
+		return helpers.CustomError{Message: "OCSP responses cannot be signed with an Ed25519 key; create an RSA or ECDSA delegated signer with cm ocsp signer, and use -d"}
+	}
+	if responder.keyHash, err = issuerKeyHash(ca.Cert, crypto.SHA1); err != nil {
+		return err
+	}
+
+	fmt.Printf("OCSP responder for %s listening on %s, responses signed by %s\n", helpers.White(ca.Name),
+		helpers.White(OcspListen), helpers.White(responder.signerCert.Subject.CommonName))
+	// The requests are small, and answered at once: slow or idle clients are not kept around
+	httpServer := &http.Server{Addr: OcspListen, Handler: responder, ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout: 10 * time.Second, WriteTimeout: 30 * time.Second, IdleTimeout: 2 * time.Minute}
+	return httpServer.ListenAndServe()
+}
+
+// ServeHTTP : handles both GET (base64 request in the URL path) and POST (DER request in the body) requests
+func (r ocspResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqBytes []byte
+	var err error
+
+	switch req.Method {
+	case http.MethodGet:
+		reqBytes, err = ocspGETRequest(req.URL.EscapedPath())
+	case http.MethodPost:
+		reqBytes, err = io.ReadAll(io.LimitReader(req.Body, 64*1024))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(r.respond(req.RemoteAddr, reqBytes))
+}
+
+// ocspGETRequest() : the DER request of a GET, base64-encoded at the end of the URL path (RFC 6960, appendix A.1)
+// The responder might sit behind a path prefix (http://host/ocsp/...), and base64 has slashes of its own: we try each
+// Part of the path following a slash, longest first, until one decodes into an OCSP request
+func ocspGETRequest(escapedPath string) ([]byte, error) {
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, char := range path {
+		if char != '/' {
+			continue
+		}
+		if reqBytes, err := base64.StdEncoding.DecodeString(path[i+1:]); err == nil {
+			if _, err = ocsp.ParseRequest(reqBytes); err == nil {
+				return reqBytes, nil
+			}
+		}
+	}
+	return nil, helpers.CustomError{Message: "no OCSP request found in the URL path"}
+}
+
+// respond : builds the signed response for a DER-encoded OCSP request
+func (r ocspResponder) respond(client string, reqBytes []byte) []byte {
+	ocspReq, err := ocsp.ParseRequest(reqBytes)
+	if err != nil {
+		log.Printf("%s: malformed request: %v", client, err)
+		return ocsp.MalformedRequestErrorResponse
+	}
+
+	// We only answer for our own CA
+	keyHash := r.keyHash
+	if ocspReq.HashAlgorithm != crypto.SHA1 {
+		if keyHash, err = issuerKeyHash(r.ca.Cert, ocspReq.HashAlgorithm); err != nil {
+			log.Printf("%s: %v", client, err)
+			return ocsp.InternalErrorErrorResponse
+		}
+	}
+	if string(keyHash) != string(ocspReq.IssuerKeyHash) {
+		log.Printf("%s: serial %X: unknown issuer", client, ocspReq.SerialNumber)
+		return ocsp.UnauthorizedErrorResponse
+	}
+
+	entries, err := readIndexFile(r.ca.Dir)
+	if err != nil {
+		log.Printf("%s: unable to read index.txt: %v", client, err)
+		return ocsp.InternalErrorErrorResponse
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Duration(OcspValidity) * time.Minute),
+	}
+	if r.signerCert != r.ca.Cert {
+		template.Certificate = r.signerCert
+	}
+	for _, entry := range entries {
+		serial, err := entry.SerialNumber()
+		if err != nil || serial.Cmp(ocspReq.SerialNumber) != 0 {
+			continue
+		}
+		switch entry.Status {
+		case "V":
+			template.Status = ocsp.Good
+		case "R":
+			template.Status = ocsp.Revoked
+			template.RevokedAt = entry.RevocationDate
+			if template.RevokedAt.IsZero() {
+				template.RevokedAt = entry.Date
+			}
+			template.RevocationReason = crlReasonCodes[entry.Reason]
+		}
+	}
+
+	resp, err := ocsp.CreateResponse(r.ca.Cert, r.signerCert, template, r.signerKey)
+	if err != nil {
+		log.Printf("%s: unable to sign the response: %v", client, err)
+		return ocsp.InternalErrorErrorResponse
+	}
+	log.Printf("%s: serial %X: %s", client, ocspReq.SerialNumber, ocspStatusName(template.Status))
+	return resp
+}
+
+// ocspStatusName : human-readable OCSP status, for the logs
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// issuerKeyHash : hash of the CA's subjectPublicKey bit string, as defined in RFC 6960's CertID
+func issuerKeyHash(caCert *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if !hash.Available() {
+		return nil, helpers.CustomError{Message: "Unsupported hash algorithm in OCSP request"}
+	}
+	if _, err := asn1.Unmarshal(caCert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	return h.Sum(nil), nil
+}
+
+// loadOCSPSigner : loads the delegated OCSP signing certificate and its private key
+func loadOCSPSigner(pki *CA, ca issuingCA) (*x509.Certificate, crypto.Signer, error) {
+	var signerCert *x509.Certificate
+	var signerKey crypto.Signer
+	var certPEM, keyPEM []byte
+	var err error
+
+	if certPEM, err = os.ReadFile(filepath.Join(ocspSignerDir(ca), ca.Name+"-ocsp.crt")); err != nil {
+		return nil, nil, helpers.CustomError{Message: "Error reading the OCSP signing certificate (create it with cm ocsp signer): " + err.Error()}
+	}
+	if keyPEM, err = os.ReadFile(filepath.Join(ocspSignerDir(ca), ca.Name+"-ocsp.key")); err != nil {
+		return nil, nil, helpers.CustomError{Message: "Error reading the OCSP signing private key: " + err.Error()}
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, helpers.CustomError{Message: "Error PEM-decoding the OCSP signing certificate"}
+	}
+	if signerCert, err = x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return nil, nil, err
+	}
+	if signerKey, err = pki.decodePrivateKeyPEM(keyPEM, ca.Name+"-ocsp.key"); err != nil {
+		return nil, nil, err
+	}
+	if signerCert.NotAfter.Before(time.Now()) {
+		return nil, nil, helpers.CustomError{Message: "The OCSP signing certificate has expired; please create a new one with cm ocsp signer"}
+	}
+	return signerCert, signerKey, nil
+}
+
+// CreateOCSPSigner :
+// Issues the delegated OCSP signing certificate of the named CA (the root CA if no name is given)
+// The certificate is registered in the CA's serial, index.txt and newcerts/ like any other
+// Steps:
+// 1. Load the CA
+// 2. Fetch the next serial number
+// 3. Generate the private key
+// 4. Sign the certificate, with the OCSPSigning extended key usage and the ocsp-nocheck extension
+// 5. Flag the previous signer as superseded, update serial, index.txt, and save to disk
+func CreateOCSPSigner(caname string) error {
+	var privateKey crypto.Signer
+	var keyBytes []byte
+	var err error
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	env := pki.env
+
+	// 1. Load the CA
+	ca, err := loadIssuer(pki, caname)
+	if err != nil {
+		return err
+	}
+	unlock, err := helpers.LockDirectory(ca.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	subject := ca.Cert.Subject
+	subject.CommonName += " OCSP responder"
+	c := CertificateStruct{
+		Country:            strings.Join(subject.Country, ""),
+		Province:           strings.Join(subject.Province, ""),
+		Locality:           strings.Join(subject.Locality, ""),
+		Organization:       strings.Join(subject.Organization, ""),
+		OrganizationalUnit: strings.Join(subject.OrganizationalUnit, ""),
+		CommonName:         subject.CommonName,
+		EmailAddresses:     []string{"none"},
+		Duration:           1,
+		KeyUsage:           []string{"digital signature"},
+		KeyAlgorithm:       CertKeyAlgorithm,
+		CertificateName:    ca.Name + "-ocsp",
+		Issuer:             ca.Name,
+	}
+	if c.KeyAlgorithm, err = normalizeKeyAlgorithm(c.KeyAlgorithm); err != nil {
+		return err
+	}
+	if c.KeyAlgorithm == "ed25519" {
+		return helpers.CustomError{Message: "OCSP responses cannot be signed with an Ed25519 key; please use rsa, ecdsa-p256 or ecdsa-p384"}
+	}
+
+	// 2. Serial number
+	if c.SerialNumber, err = nextSerialNumber(env, ca.Dir); err != nil {
+		return err
+	}
+
+	// 3. Private key
+	if privateKey, err = c.generateKey(pki.options.KeySize); err != nil {
+		return err
+	}
+	// The responder runs unattended: its key is only encrypted when the environment encrypts all keys, or with --encrypt-key
+	encrypt, err := c.encryptKey(pki)
+	if err != nil {
+		return err
+	}
+	if keyBytes, err = pki.encodePrivateKeyPEM(privateKey, encrypt); err != nil {
+		return err
+	}
+
+	// 4. Sign
+	template := x509.Certificate{
+		SerialNumber:          c.SerialNumber,
+		Subject:               subject,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(c.Duration, 0, 0),
+		KeyUsage:              getKeyUsageFromStrings(c.KeyUsage),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidOCSPNoCheck, Value: asn1.NullBytes}},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, ca.Cert, privateKey.Public(), ca.Key)
+	if err != nil {
+		return err
+	}
+
+	// 5. Update the CA's database: the previous signer is superseded, like a renewed certificate, then save to disk
+	details := ""
+	if oldSerial, err := findValidSerial(ca.Dir, c.indexSubject()); err == nil {
+		if err = supersedeIndexEntry(ca.Dir, oldSerial); err != nil {
+			return err
+		}
+		details = "supersedes serial " + oldSerial
+	}
+	if err = registerCertificate(env, c, ca.Dir); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(ocspSignerDir(ca), os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(ocspSignerDir(ca), c.CertificateName+".key"), keyBytes, 0600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(ocspSignerDir(ca), c.CertificateName+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		return err
+	}
+	if err = saveNewcert(ca.Dir, c.SerialNumber, certDER); err != nil {
+		return err
+	}
+	if err = auditCertificate(pki, "ocsp-signer", c, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), details); err != nil {
+		return err
+	}
+
+	fmt.Printf("OCSP signing certificate %s with a duration of %v years successfully created in %s\n",
+		helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(ocspSignerDir(ca)))
+	return nil
+}
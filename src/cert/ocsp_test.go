@@ -0,0 +1,82 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/ocsp_test.go
+// Original timestamp: 2026/10/19 08:05
+
+package cert
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"golang.org/x/crypto/ocsp"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The GET requests are found at the end of the URL path, whatever its prefix, and even with slashes in their base64 form
+func TestOCSPGETRequest(t *testing.T) {
+	reqBytes := testOCSPRequest(t)
+	encoded := base64.StdEncoding.EncodeToString(reqBytes)
+
+	tests := []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{"root", "/" + encoded, true},
+		{"prefix", "/ocsp/" + encoded, true},
+		{"deep prefix", "/pki/ocsp/root/" + encoded, true},
+		{"escaped", "/ocsp/" + strings.NewReplacer("/", "%2F", "+", "%2B", "=", "%3D").Replace(encoded), true},
+		{"no request", "/ocsp/", false},
+		{"garbage", "/ocsp/bm90IGFuIE9DU1AgcmVxdWVzdA==", false},
+		{"truncated", "/ocsp/" + encoded[:len(encoded)-8], false},
+	}
+	for _, tt := range tests {
+		got, err := ocspGETRequest(tt.path)
+		if tt.ok && (err != nil || !bytes.Equal(got, reqBytes)) {
+			t.Errorf("%s: got %v, want the request", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: a request was found in %s", tt.name, tt.path)
+		}
+	}
+}
+
+// testOCSPRequest() : an OCSP request, for a certificate whose base64 request holds a slash
+func testOCSPRequest(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"}, IsCA: true, BasicConstraintsValid: true,
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageCertSign}
+	caDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the serial number of the certificate goes into the request
+	for serial := int64(2); serial < 10000; serial++ {
+		reqBytes, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(serial)}, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(base64.StdEncoding.EncodeToString(reqBytes), "/") {
+			return reqBytes
+		}
+	}
+	t.Fatal("no request with a slash in its base64 form")
+	return nil
+}
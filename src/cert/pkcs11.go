@@ -0,0 +1,380 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/pkcs11.go
+// Original timestamp: 2026/10/19 01:10
+
+//go:build cgo
+
+// The PKCS#11 side of tokenKeys.go: the module is loaded, and its token logged into, once per process
+// RSA and ECDSA (P-256, P-384) keys are supported; Ed25519 is not, as few tokens implement it
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/miekg/pkcs11"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// tokenSession : a logged-in session on a token; PKCS#11 sessions cannot be used by two goroutines at once
+type tokenSession struct {
+	mutex   sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+// tokenKey : a private key on the token, as a crypto.Signer
+type tokenKey struct {
+	token  *tokenSession
+	handle pkcs11.ObjectHandle
+	public crypto.PublicKey
+}
+
+// The loaded modules, and the sessions opened on their tokens, keyed by module path (and token)
+var tokenModules = make(map[string]*pkcs11.Ctx)
+var tokenSessions = make(map[string]*tokenSession)
+var tokenMutex sync.Mutex
+
+var (
+	oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidCurveP256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidCurveP384   = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// The DigestInfo prefixes of the hashes, as CKM_RSA_PKCS signs the DigestInfo and not the bare digest (RFC 8017, 9.2)
+var rsaDigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// The PKCS#11 hash and MGF1 mechanisms of RSA-PSS
+var rsaPSSMechanisms = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// tokenError() : a PKCS#11 error, as an ErrToken
+func tokenError(message string, err error) error {
+	return helpers.CustomError{Message: message + ": " + err.Error(), Err: ErrToken}
+}
+
+// openToken() : the session on the environment's token, logged in as its user
+// Workflow :
+// 1. Load and initialize the module, unless already done
+// 2. Find the token's slot, by token label or slot number
+// 3. Open a read-write session, and log in with the PIN
+func openToken(pki *CA) (*tokenSession, error) {
+	settings := pki.env.PKCS11
+	if err := settings.Check(); err != nil {
+		return nil, helpers.CustomError{Message: err.Error(), Err: ErrToken}
+	}
+
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	id := settings.Module + "|" + settings.TokenLabel
+	if settings.Slot != nil {
+		id += fmt.Sprintf("|%d", *settings.Slot)
+	}
+	if token, ok := tokenSessions[id]; ok {
+		return token, nil
+	}
+
+	// 1. Module
+	ctx, ok := tokenModules[settings.Module]
+	if !ok {
+		if ctx = pkcs11.New(settings.Module); ctx == nil {
+			return nil, helpers.CustomError{Message: "Unable to load the PKCS#11 module " + helpers.Red(settings.Module), Err: ErrToken}
+		}
+		if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+			ctx.Destroy()
+			return nil, tokenError("Unable to initialize the PKCS#11 module "+settings.Module, err)
+		}
+		tokenModules[settings.Module] = ctx
+	}
+
+	// 2. Slot
+	slot, err := findTokenSlot(ctx, settings.TokenLabel, settings.Slot)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Session
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, tokenError("Unable to open a session on the token", err)
+	}
+	pin, err := pki.tokenPIN()
+	if err != nil {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	if err = ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		ctx.CloseSession(session)
+		return nil, tokenError("Unable to log into the token", err)
+	}
+
+	token := &tokenSession{ctx: ctx, session: session}
+	tokenSessions[id] = token
+	return token, nil
+}
+
+// findTokenSlot() : the slot holding the token with that label, or the given slot number
+func findTokenSlot(ctx *pkcs11.Ctx, label string, slot *uint) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, tokenError("Unable to list the PKCS#11 slots", err)
+	}
+	for _, s := range slots {
+		if label == "" {
+			if s == *slot {
+				return s, nil
+			}
+			continue
+		}
+		info, err := ctx.GetTokenInfo(s)
+		if err == nil && info.Label == label {
+			return s, nil
+		}
+	}
+	if label == "" {
+		return 0, helpers.CustomError{Message: fmt.Sprintf("No token found in slot %d", *slot), Err: ErrToken}
+	}
+	return 0, helpers.CustomError{Message: "No token labelled " + helpers.Red(label) + " found", Err: ErrToken}
+}
+
+// findObject() : the handle of the object of that class and label, if any
+func (t *tokenSession) findObject(class uint, label string) (pkcs11.ObjectHandle, bool, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class), pkcs11.NewAttribute(pkcs11.CKA_LABEL, label)}
+	if err := t.ctx.FindObjectsInit(t.session, template); err != nil {
+		return 0, false, tokenError("Unable to search the token", err)
+	}
+	handles, _, err := t.ctx.FindObjects(t.session, 2)
+	t.ctx.FindObjectsFinal(t.session)
+	if err != nil {
+		return 0, false, tokenError("Unable to search the token", err)
+	}
+	switch len(handles) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return handles[0], true, nil
+	}
+	return 0, false, helpers.CustomError{Message: "Several objects are labelled " + helpers.Red(label) + " on the token", Err: ErrToken}
+}
+
+// loadTokenKey() : the private key with that label on the environment's token
+// Its public key is read from the public key object with the same label
+func loadTokenKey(pki *CA, label string) (crypto.Signer, error) {
+	token, err := openToken(pki)
+	if err != nil {
+		return nil, err
+	}
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	private, found, err := token.findObject(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, helpers.CustomError{Message: "No private key labelled " + helpers.Red(label) + " on the token", Err: errNoTokenKey}
+	}
+	public, found, err := token.findObject(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, helpers.CustomError{Message: "No public key labelled " + helpers.Red(label) + " on the token", Err: ErrToken}
+	}
+	key := &tokenKey{token: token, handle: private}
+	if key.public, err = token.publicKey(public); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// generateTokenKey() : generates a key pair on the environment's token; the private key cannot leave the token
+func generateTokenKey(pki *CA, label string, algo string, rsaBits int) (crypto.Signer, error) {
+	var mechanism *pkcs11.Mechanism
+	var publicTemplate []*pkcs11.Attribute
+
+	algo, err := normalizeKeyAlgorithm(algo)
+	if err != nil {
+		return nil, err
+	}
+	switch algo {
+	case "rsa":
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)
+		publicTemplate = []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, rsaBits), pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1})}
+	case "ecdsa-p256", "ecdsa-p384":
+		curve := oidCurveP256
+		if algo == "ecdsa-p384" {
+			curve = oidCurveP384
+		}
+		params, err := asn1.Marshal(curve)
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)
+		publicTemplate = []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC), pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params)}
+	default:
+		return nil, helpers.CustomError{Message: "The " + helpers.Red(algo) + " keys cannot be generated on a PKCS#11 token (valid algorithms are rsa, ecdsa-p256, ecdsa-p384)",
+			Err: ErrInvalidCertificate}
+	}
+
+	token, err := openToken(pki)
+	if err != nil {
+		return nil, err
+	}
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	// Labels are how we find the keys: there cannot be two keys with the same one
+	if _, found, err := token.findObject(pkcs11.CKO_PRIVATE_KEY, label); err != nil {
+		return nil, err
+	} else if found {
+		return nil, helpers.CustomError{Message: "A private key labelled " + helpers.Red(label) + " is already on the token: it has to be removed first " +
+			"(pkcs11-tool --delete-object --type privkey --label " + label + ")", Err: ErrToken}
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	publicTemplate = append(publicTemplate, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY), pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true), pkcs11.NewAttribute(pkcs11.CKA_LABEL, label), pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	privateTemplate := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY), pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true), pkcs11.NewAttribute(pkcs11.CKA_SIGN, true), pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false), pkcs11.NewAttribute(pkcs11.CKA_LABEL, label), pkcs11.NewAttribute(pkcs11.CKA_ID, id)}
+
+	public, private, err := token.ctx.GenerateKeyPair(token.session, []*pkcs11.Mechanism{mechanism}, publicTemplate, privateTemplate)
+	if err != nil {
+		return nil, tokenError("Unable to generate the key pair on the token", err)
+	}
+	key := &tokenKey{token: token, handle: private}
+	if key.public, err = token.publicKey(public); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// publicKey() : reads the RSA or ECDSA public key object
+func (t *tokenSession) publicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attributes, err := t.ctx.GetAttributeValue(t.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, tokenError("Unable to read the public key", err)
+	}
+	switch tokenULong(attributes[0].Value) {
+	case pkcs11.CKK_RSA:
+		if attributes, err = t.ctx.GetAttributeValue(t.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil)}); err != nil {
+			return nil, tokenError("Unable to read the RSA public key", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attributes[0].Value), E: int(new(big.Int).SetBytes(attributes[1].Value).Int64())}, nil
+	case pkcs11.CKK_EC:
+		if attributes, err = t.ctx.GetAttributeValue(t.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)}); err != nil {
+			return nil, tokenError("Unable to read the ECDSA public key", err)
+		}
+		// CKA_EC_POINT is a DER OCTET STRING, but some tokens return the bare point
+		point := attributes[1].Value
+		if rest, err := asn1.Unmarshal(attributes[1].Value, &point); err != nil || len(rest) > 0 {
+			point = attributes[1].Value
+		}
+		spki, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey, Parameters: asn1.RawValue{FullBytes: attributes[0].Value}},
+			asn1.BitString{Bytes: point, BitLength: 8 * len(point)}})
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.ParsePKIXPublicKey(spki)
+		if err != nil {
+			return nil, helpers.CustomError{Message: "Unable to parse the ECDSA public key of the token: " + err.Error(), Err: ErrToken}
+		}
+		return public, nil
+	}
+	return nil, helpers.CustomError{Message: "Unsupported key type on the token (only RSA and ECDSA keys are supported)", Err: ErrToken}
+}
+
+// tokenULong() : a CK_ULONG attribute value, in the host's byte order and size
+func tokenULong(value []byte) uint64 {
+	if len(value) == 4 {
+		return uint64(binary.NativeEndian.Uint32(value))
+	}
+	if len(value) == 8 {
+		return binary.NativeEndian.Uint64(value)
+	}
+	return ^uint64(0)
+}
+
+// Public : the key's public key, as needed by crypto.Signer
+func (k *tokenKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign : signs the digest on the token; RSA (PKCS#1 v1.5 or PSS) or ECDSA, as per the key and the options
+func (k *tokenKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	data := digest
+
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			hashes, found := rsaPSSMechanisms[pss.Hash]
+			if !found {
+				return nil, helpers.CustomError{Message: "Unsupported RSA-PSS hash: " + pss.Hash.String(), Err: ErrToken}
+			}
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+				saltLength = pss.Hash.Size()
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hashes[0], hashes[1], uint(saltLength)))
+		} else {
+			prefix, found := rsaDigestInfoPrefixes[opts.HashFunc()]
+			if !found {
+				return nil, helpers.CustomError{Message: "Unsupported RSA hash: " + opts.HashFunc().String(), Err: ErrToken}
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = append(append([]byte{}, prefix...), digest...)
+		}
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	default:
+		return nil, helpers.CustomError{Message: "Unsupported key type on the token", Err: ErrToken}
+	}
+
+	k.token.mutex.Lock()
+	defer k.token.mutex.Unlock()
+	if err := k.token.ctx.SignInit(k.token.session, []*pkcs11.Mechanism{mechanism}, k.handle); err != nil {
+		return nil, tokenError("Unable to sign with the token", err)
+	}
+	signature, err := k.token.ctx.Sign(k.token.session, data)
+	if err != nil {
+		return nil, tokenError("Unable to sign with the token", err)
+	}
+	if _, isECDSA := k.public.(*ecdsa.PublicKey); !isECDSA {
+		return signature, nil
+	}
+
+	// CKM_ECDSA returns r and s side by side, Go (and X.509) expect their ASN.1 sequence
+	half := len(signature) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(signature[:half]), new(big.Int).SetBytes(signature[half:])})
+}
@@ -0,0 +1,25 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/pkcs11_nocgo.go
+// Original timestamp: 2026/10/19 01:10
+
+//go:build !cgo
+
+// PKCS#11 modules are C libraries: a binary built without cgo (CGO_ENABLED=0) cannot use a token
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"crypto"
+)
+
+// loadTokenKey() : without cgo, there is no token
+func loadTokenKey(pki *CA, label string) (crypto.Signer, error) {
+	return nil, helpers.CustomError{Message: "This build of cm has no PKCS#11 support (built without cgo): the key " + helpers.Red(label) + " cannot be used", Err: ErrToken}
+}
+
+// generateTokenKey() : without cgo, there is no token
+func generateTokenKey(pki *CA, label string, algo string, rsaBits int) (crypto.Signer, error) {
+	return nil, helpers.CustomError{Message: "This build of cm has no PKCS#11 support (built without cgo): the key " + helpers.Red(label) + " cannot be generated", Err: ErrToken}
+}
@@ -0,0 +1,232 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/pkcs11_softhsm_test.go
+// Original timestamp: 2026/10/19 07:40
+
+//go:build softhsm && cgo
+
+// The PKCS#11 keys, against a SoftHSM2 token initialized for the test: go test -tags softhsm ./cert/
+// The module is found in the usual places, or given with SOFTHSM2_MODULE; the tests are skipped without softhsm2-util
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const softHSMTokenLabel = "cm"
+const softHSMPIN = "1234"
+
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// softHSMCA() : a PKI in a temporary directory, whose CA keys go on a freshly initialized SoftHSM2 token
+// The token sessions are cached per process, so all the tests share the same token
+func softHSMCA(t *testing.T) *CA {
+	t.Helper()
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util is not installed")
+	}
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, candidate := range softHSMModules {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			module = candidate
+		}
+	}
+	if module == "" {
+		t.Skip("libsofthsm2.so not found: set SOFTHSM2_MODULE")
+	}
+
+	softHSMOnce(t)
+	root := t.TempDir()
+	env := environment.EnvironmentStruct{CertificateRootDir: root, RootCAdir: filepath.Join(root, "rootCA"), ServerCertsDir: filepath.Join(root, "servers"),
+		CertificatesConfigDir: filepath.Join(root, "conf"), RemoveDuplicates: true, KeyEncryption: environment.KeyEncryptionNone,
+		PKCS11: &environment.PKCS11Struct{Module: module, TokenLabel: softHSMTokenLabel, KeyLabel: t.Name() + "-{name}", PIN: "env:CM_TEST_PIN"}}
+	t.Setenv("CM_TEST_PIN", softHSMPIN)
+	pki, err := Open(env, Options{KeySize: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = createCertificateDirectories(env); err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+var softHSMInitialized = false
+
+// softHSMOnce() : initializes the token, in its own token directory, once per test run
+func softHSMOnce(t *testing.T) {
+	t.Helper()
+	if softHSMInitialized {
+		return
+	}
+	dir, err := os.MkdirTemp("", "cm-softhsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err = os.MkdirAll(filepath.Join(dir, "tokens"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(conf, []byte("directories.tokendir = "+filepath.Join(dir, "tokens")+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The module reads its configuration when loaded, which happens after this, and once per process
+	os.Setenv("SOFTHSM2_CONF", conf)
+	out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", softHSMTokenLabel, "--pin", softHSMPIN, "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("softhsm2-util --init-token: %v\n%s", err, out)
+	}
+	softHSMInitialized = true
+}
+
+// tokenKey.Sign, through the three mechanisms: RSA PKCS #1 v1.5, RSA-PSS and ECDSA
+func TestSoftHSMSign(t *testing.T) {
+	pki := softHSMCA(t)
+	digest := sha256.Sum256([]byte("certificateManager"))
+
+	rsaKey, err := generateTokenKey(pki, t.Name()+"-rsa", "rsa", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := rsaKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.VerifyPKCS1v15(rsaKey.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("RSA PKCS #1 v1.5: %v", err)
+	}
+	for _, saltLength := range []int{rsa.PSSSaltLengthEqualsHash, rsa.PSSSaltLengthAuto} {
+		opts := &rsa.PSSOptions{SaltLength: saltLength, Hash: crypto.SHA256}
+		if signature, err = rsaKey.Sign(rand.Reader, digest[:], opts); err != nil {
+			t.Fatal(err)
+		}
+		if err = rsa.VerifyPSS(rsaKey.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], signature, &rsa.PSSOptions{Hash: crypto.SHA256}); err != nil {
+			t.Errorf("RSA-PSS, salt length %d: %v", saltLength, err)
+		}
+	}
+
+	for _, algorithm := range []string{"ecdsa-p256", "ecdsa-p384"} {
+		ecKey, err := generateTokenKey(pki, t.Name()+"-"+algorithm, algorithm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature, err = ecKey.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+			t.Fatal(err)
+		}
+		if !ecdsa.VerifyASN1(ecKey.Public().(*ecdsa.PublicKey), digest[:], signature) {
+			t.Errorf("%s: invalid signature", algorithm)
+		}
+	}
+
+	// The key is found again by its label, with the same public key
+	loaded, err := loadTokenKey(pki, t.Name()+"-rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Public().(*rsa.PublicKey).Equal(rsaKey.Public()) {
+		t.Error("the reloaded key has another public key")
+	}
+}
+
+// A CA whose key is generated on the token issues a certificate that verifies against it; its key cannot be rotated
+func TestSoftHSMIssue(t *testing.T) {
+	pki := softHSMCA(t)
+	ctx := context.Background()
+	subject := CertificateStruct{Country: "CA", Province: "QC", Locality: "Montreal", Organization: "cm", OrganizationalUnit: "tests"}
+
+	for _, algorithm := range []string{"rsa", "ecdsa-p256"} {
+		caConfig := subject
+		caConfig.CertificateName, caConfig.CommonName, caConfig.IsCA, caConfig.Profile, caConfig.KeyAlgorithm = "root-"+algorithm, "Root "+algorithm, true, "ca", algorithm
+		ca, err := pki.Issue(ctx, caConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(pki.env.RootCAdir, caConfig.CertificateName+".key")); !os.IsNotExist(err) {
+			t.Errorf("%s: the CA key was written to a file", algorithm)
+		}
+
+		leafConfig := subject
+		leafConfig.CertificateName, leafConfig.CommonName, leafConfig.Issuer, leafConfig.Profile = "web-"+algorithm, algorithm+".example.com", caConfig.CertificateName, "server"
+		leafConfig.DNSNames = []string{leafConfig.CommonName}
+		leaf, err := pki.Issue(ctx, leafConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Certificate)
+		if _, err = leaf.Certificate.Verify(x509.VerifyOptions{Roots: roots, DNSName: leafConfig.CommonName}); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+
+		if _, err = caConfig.rotatedKey(pki); !errors.Is(err, ErrToken) {
+			t.Errorf("%s: rotating the token key: got %v, want ErrToken", algorithm, err)
+		}
+	}
+}
+
+// A batch creating CAs generates their keys on the token as well, and never writes them to files
+func TestSoftHSMBatch(t *testing.T) {
+	pki := softHSMCA(t)
+	subject := CertificateStruct{Country: "CA", Province: "QC", Locality: "Montreal", Organization: "cm", OrganizationalUnit: "tests"}
+	entry := func(name string, cn string, isCA bool, issuer string, profile string) *batchEntry {
+		c := subject
+		c.CertificateName, c.CommonName, c.IsCA, c.Issuer, c.Profile, c.KeyAlgorithm = name, cn, isCA, issuer, profile, "ecdsa-p256"
+		return &batchEntry{Source: name, Config: c}
+	}
+	entries := []*batchEntry{entry("batch-root", "Batch Root CA", true, "", "ca"), entry("batch-sub", "Batch Sub CA", true, "batch-root", "ca"),
+		entry("batch-web", "web.example.com", false, "batch-sub", "server")}
+
+	issueBatch(pki, entries)
+	for _, entry := range entries {
+		if entry.Err != nil {
+			t.Fatalf("%s: %v", entry.Source, entry.Err)
+		}
+	}
+	for _, entry := range entries[:2] {
+		if _, err := os.Stat(filepath.Join(entry.Config.caDirectory(pki.env), entry.Config.CertificateName+".key")); !os.IsNotExist(err) {
+			t.Errorf("%s: the CA key was written to a file", entry.Source)
+		}
+		if _, err := loadTokenKey(pki, pki.env.PKCS11.CAKeyLabel(entry.Config.CertificateName)); err != nil {
+			t.Errorf("%s: %v", entry.Source, err)
+		}
+	}
+
+	issued, err := pki.Get(context.Background(), "batch-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, ca := range issued.Chain {
+		if ca.Subject.CommonName == "Batch Root CA" {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+	if _, err = issued.Certificate.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Error(err)
+	}
+}
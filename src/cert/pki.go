@@ -0,0 +1,247 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/pki.go
+// Original timestamp: 2026/10/19 00:20
+
+// The library API: a PKI environment, opened with Open(), on which certificates are issued, signed from CSRs, listed,
+// Fetched, revoked and verified. Nothing is printed, and none of the command line settings (package variables) is used
+// The cm commands are wrappers over it, opening the environment of the -e flag with openCommandCA()
+//
+//	pki, err := cert.Open(env, cert.Options{Name: "prod"})
+//	issued, err := pki.Issue(ctx, cert.CertificateStruct{CertificateName: "web", CommonName: "web.lan", Profile: "server", ...})
+//	if errors.Is(err, cert.ErrDuplicate) { ... }
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options : what the command line sets with its flags; the zero value is fine
+type Options struct {
+	Name        string                             // environment name, recorded in the audit log
+	Client      string                             // on whose behalf the operations are done (an API token...), recorded in the audit log
+	KeySize     int                                // RSA key size in bits, 4096 if not set
+	EncryptKeys bool                               // encrypt the new private keys, whatever the environment's KeyEncryption policy
+	RemoveFiles bool                               // a revoked certificate's files are removed from the PKI
+	Passphrase  func(confirm bool) ([]byte, error) // passphrase of the encrypted private keys; confirm is set when encrypting
+	PIN         func() ([]byte, error)             // PIN of the PKCS#11 token, when the environment's PIN source is prompt
+}
+
+// CA : a PKI environment, opened with Open()
+// A CA can be used from several goroutines: the lock of each CA directory serializes the updates of that CA, within the
+// Process as well as between processes, and the operations on other CAs or environments do not wait for it
+type CA struct {
+	env     environment.EnvironmentStruct
+	options Options
+}
+
+// Issued : a certificate, with its issuer's chain and its private key
+type Issued struct {
+	Config          CertificateStruct
+	Certificate     *x509.Certificate
+	Chain           []*x509.Certificate // the issuing CA, followed by its own issuers up to the root CA; empty for a root CA
+	CertificateFile string
+	CertificatePEM  []byte
+	PrivateKeyPEM   []byte // as stored, thus encrypted or not; only returned by Issue, and never for a CA
+}
+
+// VerifyOptions : how a certificate is verified; by default, against the environment's CAs, now
+type VerifyOptions struct {
+	Roots         []*x509.Certificate // trusted roots, instead of the environment's CAs
+	Intermediates []*x509.Certificate // other certificates of the chain
+	Hostname      string              // also check that the certificate is valid for this host name or IP address
+	At            time.Time           // validate at this time instead of now
+}
+
+// Open :
+// Opens the environment's PKI; its directories are created as needed
+func Open(env environment.EnvironmentStruct, options Options) (*CA, error) {
+	if env.RootCAdir == "" || env.ServerCertsDir == "" || env.CertificatesConfigDir == "" {
+		return nil, helpers.CustomError{Message: "The environment needs its RootCAdir, ServerCertsDir and CertificatesConfigDir", Err: ErrInvalidEnvironment}
+	}
+	if options.KeySize == 0 {
+		options.KeySize = 4096
+	}
+	return &CA{env: env, options: options}, nil
+}
+
+// openCommandCA() : the environment of the -e flag, with the settings of the command line flags
+func openCommandCA() (*CA, error) {
+	env, err := environment.LoadEnvironmentFile()
+	if err != nil {
+		return nil, err
+	}
+	return Open(env, Options{Name: environment.EnvConfigFile, KeySize: CertPKsize, EncryptKeys: CertEncryptKey,
+		RemoveFiles: CertRemoveFiles, Passphrase: keyPassphrase, PIN: tokenPINPrompt})
+}
+
+// Environment : the environment the PKI was opened with
+func (pki *CA) Environment() environment.EnvironmentStruct {
+	return pki.env
+}
+
+// Issue :
+// Creates the certificate (CA or not) and its private key, from its config; the config's profile is applied
+func (pki *CA) Issue(ctx context.Context, c CertificateStruct) (*Issued, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.complete(pki.env, true); err != nil {
+		return nil, err
+	}
+	if err := createCertificateDirectories(pki.env); err != nil {
+		return nil, err
+	}
+	if err := c.create(ctx, pki); err != nil {
+		return nil, err
+	}
+	return pki.issued(c, true)
+}
+
+// SignCSR :
+// Issues a certificate from a CSR (PEM or DER); the config holds what the CSR does not: name, issuer, profile, duration...
+// The private key stays with whoever made the CSR; the certificate name must not be in use (ErrDuplicate)
+func (pki *CA) SignCSR(ctx context.Context, csr []byte, c CertificateStruct) (*Issued, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	csrRequest, err := parseCSR(csr)
+	if err != nil {
+		return nil, err
+	}
+	if err = createCertificateDirectories(pki.env); err != nil {
+		return nil, err
+	}
+	if err = c.signCSR(ctx, pki, csrRequest, "CSR", false); err != nil {
+		return nil, err
+	}
+	return pki.issued(c, false)
+}
+
+// Revoke :
+// Flags the named certificate as revoked in its issuer's index.txt; the reason may be empty
+func (pki *CA) Revoke(ctx context.Context, name string, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	reason, err := normalizeRevokeReason(reason)
+	if err != nil {
+		return err
+	}
+	_, err = revokeCertificate(ctx, pki, name, reason)
+	return err
+}
+
+// List :
+// The certificate config files, along with their certificates once issued
+func (pki *CA) List(ctx context.Context) ([]CertificateRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fileInfos, err := certificateConfigFiles(pki.env)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return certificateRecords(pki.env, fileInfos)
+}
+
+// Get :
+// The named certificate, and its issuer's chain
+func (pki *CA) Get(ctx context.Context, name string) (*Issued, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c, err := loadCertificateConfig(pki.env, name)
+	if err != nil {
+		return nil, err
+	}
+	return pki.issued(c, false)
+}
+
+// Verify :
+// Validates the certificate: validity period, chain of trust, host name, and status in its issuer's index.txt
+// A certificate failing the validation is not an error: the result lists the reasons
+func (pki *CA) Verify(ctx context.Context, crt *x509.Certificate, opts VerifyOptions) (*Validation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result, err := validateCertificate(&pki.env, crt, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Bundle :
+// The certificates of all CAs, the root CA first; or, if a CA is named, its chain up to the root CA
+func (pki *CA) Bundle(ctx context.Context, issuer string) ([]*x509.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if issuer != "" {
+		ca, err := loadIssuerChain(pki.env, issuer)
+		if err != nil {
+			return nil, err
+		}
+		return ca.Chain, nil
+	}
+
+	caDirs, err := caDirectories(pki.env)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := caCertificates(pki.env)
+	if err != nil {
+		return nil, err
+	}
+	bundle := []*x509.Certificate{}
+	for _, dir := range caDirs {
+		if crt, found := caCerts[dir]; found {
+			bundle = append(bundle, crt)
+		}
+	}
+	if len(bundle) == 0 {
+		return nil, helpers.CustomError{Message: "No CA certificate found in " + pki.env.RootCAdir, Err: ErrUnknownIssuer}
+	}
+	return bundle, nil
+}
+
+// issued() : loads the certificate, its issuer's chain and, if asked to, its private key
+func (pki *CA) issued(c CertificateStruct, withKey bool) (*Issued, error) {
+	var err error
+	issued := &Issued{Config: c, CertificateFile: c.certificateFilePath(pki.env)}
+
+	if issued.CertificatePEM, err = os.ReadFile(issued.CertificateFile); err != nil {
+		return nil, helpers.CustomError{Message: "Certificate " + c.CertificateName + " has not been issued: " + err.Error(), Err: ErrNotFound}
+	}
+	certs, err := parseCertificates(issued.CertificatePEM)
+	if err != nil {
+		return nil, err
+	}
+	issued.Certificate = certs[0]
+
+	if !c.IsCA || c.Issuer != "" {
+		ca, err := loadIssuerChain(pki.env, c.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		issued.Chain = ca.Chain
+	}
+
+	// A certificate signed from a CSR has no private key here
+	if withKey && !c.IsCA {
+		keyPEM, err := os.ReadFile(filepath.Join(pki.env.ServerCertsDir, "private", c.CertificateName+".key"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		issued.PrivateKeyPEM = keyPEM
+	}
+	return issued, nil
+}
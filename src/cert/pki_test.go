@@ -0,0 +1,81 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/pki_test.go
+// Original timestamp: 2026/10/19 10:40
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testSubject : the subject fields of the test certificates
+var testSubject = CertificateStruct{Country: "CA", Province: "QC", Locality: "Montreal", Organization: "cm", OrganizationalUnit: "tests"}
+
+// testPKI() : an environment in a temporary directory, with its root CA; the keys are not encrypted
+func testPKI(t *testing.T) *CA {
+	t.Helper()
+	root := t.TempDir()
+	env := environment.EnvironmentStruct{CertificateRootDir: root, RootCAdir: filepath.Join(root, "rootCA"), ServerCertsDir: filepath.Join(root, "servers"),
+		CertificatesConfigDir: filepath.Join(root, "conf"), RemoveDuplicates: true, KeyEncryption: environment.KeyEncryptionNone}
+	pki, err := Open(env, Options{Name: "test.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCA := testSubject
+	rootCA.CertificateName, rootCA.CommonName, rootCA.IsCA, rootCA.Profile, rootCA.KeyAlgorithm = "rootca", "Root CA", true, "ca", "ecdsa-p256"
+	if _, err = pki.Issue(context.Background(), rootCA); err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+// testLeaf() : the config of a server certificate, signed by the root CA
+func testLeaf(name string, cn string) CertificateStruct {
+	c := testSubject
+	c.CertificateName, c.CommonName, c.Profile, c.KeyAlgorithm, c.DNSNames = name, cn, "server", "ecdsa-p256", []string{cn}
+	return c
+}
+
+// An environment whose CA directory is locked by someone else does not hold up the others
+func TestIssueLockedEnvironment(t *testing.T) {
+	locked, other := testPKI(t), testPKI(t)
+	unlock, err := helpers.LockDirectory(locked.env.RootCAdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// Issuing in the locked environment waits for the lock, until its context is done
+	waiting := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+		defer cancel()
+		_, err := locked.Issue(ctx, testLeaf("web", "web.example.com"))
+		waiting <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	if _, err = other.Issue(context.Background(), testLeaf("web", "web.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the other environment waited %v", elapsed)
+	}
+	select {
+	case err = <-waiting:
+		t.Fatalf("the locked environment did not wait: %v", err)
+	default:
+	}
+
+	if err = <-waiting; !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, helpers.ErrLocked) {
+		t.Errorf("the locked environment: got %v, want a lock timeout", err)
+	}
+}
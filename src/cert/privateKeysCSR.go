@@ -10,6 +10,10 @@ package cert
 import (
 	"certificateManager/environment"
 	"certificateManager/helpers"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -17,92 +21,153 @@ import (
 	"encoding/pem"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+var CertKeyAlgorithm = ""
+
+// normalizeKeyAlgorithm : maps the user-provided key algorithm to its canonical name
+// An empty value means RSA, as it has always been the default
+func normalizeKeyAlgorithm(algo string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(algo)) {
+	case "", "rsa":
+		return "rsa", nil
+	case "ecdsa-p256", "p256", "p-256", "ec256":
+		return "ecdsa-p256", nil
+	case "ecdsa-p384", "p384", "p-384", "ec384":
+		return "ecdsa-p384", nil
+	case "ed25519":
+		return "ed25519", nil
+	}
+	return "", helpers.CustomError{Message: "Unsupported key algorithm: " + helpers.Red(algo) + " (valid values are rsa, ecdsa-p256, ecdsa-p384, ed25519)", Err: ErrInvalidCertificate}
+}
+
+// generateKey : generates a new key pair according to the certificate's KeyAlgorithm; rsaBits is the size of RSA keys
+func (c CertificateStruct) generateKey(rsaBits int) (crypto.Signer, error) {
+	algo, err := normalizeKeyAlgorithm(c.KeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algo {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		return pk, err
+	default:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	}
+}
+
+// parsePrivateKey : parses a DER-encoded private key
+// Keys are now stored as PKCS#8, but we still need to read the PKCS#1 (RSA) keys created by previous releases
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, helpers.CustomError{Message: "The private key cannot be used for signing"}
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, helpers.CustomError{Message: "Unable to parse the private key: unknown key format"}
+}
+
 // createPrivateKey : creates either a CA private key, or a standard cert private key
 // Parameters:
 // - filename (string): the name of the certificate appended with ".key"
-// - pkrootdir (string) : corresponds to CertificateRootDir + RootCAdir + "private/" + filename + ".key" for a CA, or
+// - pkrootdir (string) : corresponds to the CA's own directory (RootCAdir, or RootCAdir/intermediates/NAME) + filename + ".key" for a CA, or
 // - CertificateRootDir + ServerCertsDir + "private/ + filename + ".key" for a standard cert
+// When the environment has a PKCS#11 token, CA keys are generated on it instead, and there is no key file
 // Returns:
-// - A pointer to the private key
+// - The private key, as a crypto.Signer (RSA, ECDSA or Ed25519)
 // - the error code, if any
-func (c CertificateStruct) createPrivateKey() (*rsa.PrivateKey, error) {
-	var pk *rsa.PrivateKey
+func (c CertificateStruct) createPrivateKey(pki *CA) (crypto.Signer, error) {
+	var pk crypto.Signer
 	var err error = nil
-	var pkFile *os.File
-	var pkfile string
-	var env environment.EnvironmentStruct
 
-	if env, err = environment.LoadEnvironmentFile(); err != nil {
+	if c.keyOnToken(pki) {
+		return c.createTokenKey(pki)
+	}
+	if pk, err = c.generateKey(pki.options.KeySize); err != nil {
 		return nil, err
 	}
-
-	if pk, err = rsa.GenerateKey(rand.Reader, CertPKsize); err != nil {
+	if err = c.savePrivateKey(pki, pk); err != nil {
 		return nil, err
 	}
+	return pk, nil
+}
 
-	// rootCA keys are not stored at the same place as other SSL keys
+// savePrivateKey : writes the private key, PKCS#8-encoded, where the certificate expects it
+// The key is only readable by its owner, and encrypted with a passphrase as per the environment's KeyEncryption policy
+func (c CertificateStruct) savePrivateKey(pki *CA, pk crypto.Signer) error {
+	var pkBytes []byte
+	var err error
+	var pkfile string
+	env := pki.env
+
+	// CA keys are not stored at the same place as other SSL keys
 	if c.IsCA {
-		if err = os.MkdirAll(env.RootCAdir, os.ModePerm); err != nil {
-			return nil, err
+		if err = os.MkdirAll(c.caDirectory(env), os.ModePerm); err != nil {
+			return err
 		}
-		pkfile = filepath.Join(env.RootCAdir, c.CertificateName+".key")
+		pkfile = filepath.Join(c.caDirectory(env), c.CertificateName+".key")
 	} else {
 		if err = os.MkdirAll(filepath.Join(env.ServerCertsDir, "private"), os.ModePerm); err != nil {
-			return nil, err
+			return err
 		}
 		pkfile = filepath.Join(env.ServerCertsDir, "private", c.CertificateName+".key")
 	}
 
-	if pkFile, err = os.Create(pkfile); err != nil {
-		return nil, err
+	encrypt, err := c.encryptKey(pki)
+	if err != nil {
+		return err
 	}
-	defer pkFile.Close()
-
-	pkBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
-	if err = pem.Encode(pkFile, pkBlock); err != nil {
-		return nil, err
+	if pkBytes, err = pki.encodePrivateKeyPEM(pk, encrypt); err != nil {
+		return err
 	}
-	return pk, err
+	// The key file may predate this release, and be world-readable
+	if err = os.WriteFile(pkfile, pkBytes, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(pkfile, 0600)
 }
 
-func (c CertificateStruct) getPrivateKey(env environment.EnvironmentStruct) (*rsa.PrivateKey, error) {
+func (c CertificateStruct) getPrivateKey(pki *CA) (crypto.Signer, error) {
 	var err error
 	var pKeyFile []byte
-	var pkey *rsa.PrivateKey
-	keyDir := env.CertificateRootDir
+	var pkey crypto.Signer
+	env := pki.env
 
-	// root CAs store their key somewhere else
+	// CAs store their key somewhere else, possibly on the token
 	if c.IsCA {
-		keyDir = env.RootCAdir
-	} else {
-		keyDir = filepath.Join(env.ServerCertsDir, "private")
+		return pki.caKey(c.CertificateName, c.caDirectory(env))
 	}
+	keyDir := filepath.Join(env.ServerCertsDir, "private")
 	// Load keyfile
 	if pKeyFile, err = os.ReadFile(filepath.Join(keyDir, c.CertificateName+".key")); err != nil {
 		return nil, helpers.CustomError{Message: "Error reading the private key: " + err.Error()}
 	}
 
-	// Decode keyfile
-	if key, _ := pem.Decode(pKeyFile); key == nil {
-		return nil, helpers.CustomError{Message: "Unable to PEM-decode the private key"}
-	} else {
-		// Parse keyfile
-		if pkey, err = x509.ParsePKCS1PrivateKey(key.Bytes); err != nil {
-			return nil, err
-		}
+	// Decode, decrypt if needed, and parse keyfile
+	if pkey, err = pki.decodePrivateKeyPEM(pKeyFile, c.CertificateName+".key"); err != nil {
+		return nil, err
 	}
 	return pkey, nil
 }
 
 // generateCSR : generate a certificate signing request, and save it to disk
-func (c CertificateStruct) generateCSR(env environment.EnvironmentStruct, privateK *rsa.PrivateKey) error {
+func (c CertificateStruct) generateCSR(env environment.EnvironmentStruct, privateK crypto.Signer) error {
 	var err error
 	var csrFile *os.File
-	if env, err = environment.LoadEnvironmentFile(); err != nil {
-		return err
-	}
+
 	csrTemplate := x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName:   c.CommonName,
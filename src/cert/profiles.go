@@ -0,0 +1,106 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/profiles.go
+// Original timestamp: 2026/10/18 19:10
+
+// Certificate profiles: named sets of key usage, extended key usage and default duration
+// They are stored with the environment, in CertificateRootDir/profiles.json, which is created with the defaults below
+// On first use. The file can then be edited to add or tune profiles
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var CertProfile = ""
+
+// ProfileStruct : what a profile sets on a certificate
+type ProfileStruct struct {
+	KeyUsage    []string `json:"KeyUsage"`
+	ExtKeyUsage []string `json:"ExtKeyUsage,omitempty"`
+	Duration    int      `json:"Duration"` // in years, only used when the certificate config does not set one
+}
+
+var defaultProfiles = map[string]ProfileStruct{
+	"ca":           {KeyUsage: []string{"digital signature", "cert sign", "crl sign"}, Duration: 10},
+	"server":       {KeyUsage: []string{"digital signature", "key encipherment"}, ExtKeyUsage: []string{"server auth"}, Duration: 1},
+	"client":       {KeyUsage: []string{"digital signature", "key encipherment"}, ExtKeyUsage: []string{"client auth"}, Duration: 1},
+	"peer":         {KeyUsage: []string{"digital signature", "key encipherment"}, ExtKeyUsage: []string{"server auth", "client auth"}, Duration: 1},
+	"code-signing": {KeyUsage: []string{"digital signature"}, ExtKeyUsage: []string{"code signing"}, Duration: 1},
+	"smime":        {KeyUsage: []string{"digital signature", "key encipherment", "content commitment"}, ExtKeyUsage: []string{"email protection"}, Duration: 1},
+	"ocsp":         {KeyUsage: []string{"digital signature"}, ExtKeyUsage: []string{"ocsp signing"}, Duration: 1},
+}
+
+// profilesFilePath : the profiles live in the environment's root directory
+func profilesFilePath(env environment.EnvironmentStruct) string {
+	return filepath.Join(env.CertificateRootDir, "profiles.json")
+}
+
+// loadProfiles() : loads the environment's profiles, creating the file with the default profiles if missing
+func loadProfiles(env environment.EnvironmentStruct) (map[string]ProfileStruct, error) {
+	profiles := make(map[string]ProfileStruct)
+
+	jFile, err := os.ReadFile(profilesFilePath(env))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		jStream, err := json.MarshalIndent(defaultProfiles, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(env.CertificateRootDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(profilesFilePath(env), jStream, 0644); err != nil {
+			return nil, err
+		}
+		return defaultProfiles, nil
+	}
+	if err = json.Unmarshal(jFile, &profiles); err != nil {
+		return nil, helpers.CustomError{Message: "Unable to parse " + profilesFilePath(env) + ": " + err.Error()}
+	}
+	return profiles, nil
+}
+
+// profileNames() : the sorted profile names, as offered at the prompt
+func profileNames(profiles map[string]ProfileStruct) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile() : sets the profile's key usage and extended key usage on the certificate, and its duration when
+// The certificate does not have one
+func (c *CertificateStruct) applyProfile(env environment.EnvironmentStruct, name string) error {
+	profiles, err := loadProfiles(env)
+	if err != nil {
+		return err
+	}
+	name = strings.ToLower(name)
+	profile, ok := profiles[name]
+	if !ok {
+		return helpers.CustomError{Message: "Unknown profile: " + helpers.Red(name) + " (valid profiles are " + strings.Join(profileNames(profiles), ", ") + ")", Err: ErrInvalidCertificate}
+	}
+	if _, err = getExtKeyUsageFromStrings(profile.ExtKeyUsage); err != nil {
+		return helpers.CustomError{Message: "Profile " + name + ": " + err.Error(), Err: ErrInvalidCertificate}
+	}
+
+	c.Profile = name
+	c.KeyUsage = profile.KeyUsage
+	c.ExtKeyUsage = profile.ExtKeyUsage
+	if c.Duration == 0 {
+		c.Duration = profile.Duration
+	}
+	return nil
+}
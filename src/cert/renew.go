@@ -0,0 +1,243 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/renew.go
+// Original timestamp: 2026/10/18 14:20
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var CertRotateKey = false
+
+// Renew :
+// Reissues a certificate (CA or not) from its config file, with a new serial number and validity period
+// Workflow :
+// 1. Load the certificate config and its issuing CA
+// 2. Find the current (valid) index.txt entry of the certificate
+// 3. Fetch the next serial number
+// 4. Reuse the private key, or generate a new one if asked to; a certificate signed from an external CSR has no key,
+// Its stored CSR is signed again
+// 5. Sign the certificate (or create the CA certificate), in memory
+// 6. Flag the previous entry as superseded, update serial, index.txt.attr and index.txt
+// 7. Only then write the new key, CSR and certificate; Java files are regenerated if present
+// 8. Save the certificate config file
+// 9. Record the operation in the audit log
+func Renew(certname string) error {
+	var certconfig CertificateStruct
+	var privateKey crypto.Signer
+	var publicKey crypto.PublicKey
+	var issuer *issuingCA
+	var certDER []byte
+	var err error
+	newKey := false
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	env := pki.env
+
+	// 1. Load the config and the issuing CA
+	if certconfig, err = loadCertificateConfig(env, strings.TrimSuffix(certname, ".json")); err != nil {
+		return err
+	}
+	if len(certconfig.EmailAddresses) == 0 {
+		certconfig.EmailAddresses = []string{"none"}
+	}
+	issuerDir := env.RootCAdir
+	if !certconfig.IsCA || certconfig.Issuer != "" {
+		ca, err := loadIssuer(pki, certconfig.Issuer)
+		if err != nil {
+			return err
+		}
+		issuer = &ca
+		issuerDir = ca.Dir
+	}
+
+	unlock, err := helpers.LockDirectory(issuerDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 2. Find the current entry
+	oldSerial, err := findValidSerial(issuerDir, certconfig.indexSubject())
+	if err != nil {
+		return err
+	}
+
+	// 3. New serial number
+	if certconfig.SerialNumber, err = nextSerialNumber(env, issuerDir); err != nil {
+		return err
+	}
+
+	// 4. Private key, or the public key of the stored CSR
+	_, err = os.Stat(filepath.Join(env.ServerCertsDir, "private", certconfig.CertificateName+".key"))
+	csrOnly := !certconfig.IsCA && os.IsNotExist(err)
+	switch {
+	case CertRotateKey && csrOnly:
+		return helpers.CustomError{Message: "The private key of " + helpers.Red(certconfig.CertificateName) +
+			" is held by whoever made its CSR, it cannot be rotated here: sign their new CSR with cm cert sign --force", Err: ErrInvalidCertificate}
+	case CertRotateKey:
+		if privateKey, err = certconfig.rotatedKey(pki); err != nil {
+			return err
+		}
+		newKey = true
+		publicKey = privateKey.Public()
+	case csrOnly:
+		if publicKey, err = storedCSRPublicKey(env, certconfig.CertificateName); err != nil {
+			return err
+		}
+	default:
+		if privateKey, err = certconfig.getPrivateKey(pki); err != nil {
+			return err
+		}
+		publicKey = privateKey.Public()
+	}
+
+	// 5. Sign, in memory
+	if certconfig.IsCA {
+		certDER, err = certconfig.signCA(privateKey, issuer)
+	} else {
+		certDER, err = certconfig.signPublicKey(*issuer, publicKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 6. Update serial, index.txt.attr and index.txt
+	if err = supersedeIndexEntry(issuerDir, oldSerial); err != nil {
+		return err
+	}
+	if err = registerCertificate(env, certconfig, issuerDir); err != nil {
+		return err
+	}
+
+	// 7. Write the files
+	if newKey && !certconfig.keyOnToken(pki) {
+		if err = certconfig.savePrivateKey(pki, privateKey); err != nil {
+			return err
+		}
+	}
+	if certconfig.IsCA {
+		err = certconfig.saveCACert(env, issuer, certDER)
+	} else {
+		if !csrOnly {
+			if err = certconfig.generateCSR(env, privateKey); err != nil {
+				return err
+			}
+		}
+		err = certconfig.saveSignedCert(env, *issuer, certDER)
+	}
+	if err != nil {
+		return err
+	}
+	if !certconfig.IsCA && !csrOnly {
+		// The Java files are only regenerated if the previous certificate had them
+		javaFiles := false
+		for _, ext := range []string{".p12", ".jks"} {
+			if _, err = os.Stat(filepath.Join(env.ServerCertsDir, "java", certconfig.CertificateName+ext)); err == nil {
+				javaFiles = true
+			}
+		}
+		if javaFiles || CertJava {
+			if err = certconfig.createJavaCert(pki); err != nil {
+				return err
+			}
+		}
+	}
+	certconfig.printIssued(env)
+
+	// 8. Save JSON config file
+	if err = certconfig.saveCertificateConfig(env); err != nil {
+		return err
+	}
+
+	// 9. Audit log
+	certPEM, _ := os.ReadFile(certconfig.certificateFilePath(env))
+	if err = auditCertificate(pki, "renew", certconfig, certPEM, "supersedes serial "+oldSerial); err != nil {
+		return err
+	}
+
+	fmt.Printf("Certificate %s has been renewed (serial %s supersedes %s)\n", helpers.Green(certconfig.CertificateName),
+		helpers.White(formatSerial(certconfig.SerialNumber)), helpers.White(oldSerial))
+	return nil
+}
+
+// rotatedKey() : the new private key of the renewed certificate, kept in memory until the certificate is registered
+// A CA key already held by the PKCS#11 token cannot be rotated: the token has one key per CA label; a CA key still in
+// A file moves to the token
+func (c CertificateStruct) rotatedKey(pki *CA) (crypto.Signer, error) {
+	var err error
+
+	if CertKeyAlgorithm != "" {
+		c.KeyAlgorithm = CertKeyAlgorithm
+	}
+	if c.KeyAlgorithm, err = normalizeKeyAlgorithm(c.KeyAlgorithm); err != nil {
+		return nil, err
+	}
+	if !c.keyOnToken(pki) {
+		return c.generateKey(pki.options.KeySize)
+	}
+	if _, err = loadTokenKey(pki, pki.env.PKCS11.CAKeyLabel(c.CertificateName)); !errors.Is(err, errNoTokenKey) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, helpers.CustomError{Message: "The key of " + helpers.Red(c.CertificateName) +
+			" is held by the PKCS#11 token, where it cannot be rotated: renew it without -k, or create a new CA", Err: ErrToken}
+	}
+	return c.createTokenKey(pki)
+}
+
+// storedCSRPublicKey() : the public key of the CSR kept for the certificate, which was signed from an external CSR
+func storedCSRPublicKey(env environment.EnvironmentStruct, certname string) (crypto.PublicKey, error) {
+	csrBytes, err := os.ReadFile(filepath.Join(env.ServerCertsDir, "csr", certname+".csr"))
+	if err != nil {
+		return nil, helpers.CustomError{Message: "The certificate " + helpers.Red(certname) + " has neither a private key nor a CSR: " + err.Error(), Err: ErrNotFound}
+	}
+	csrRequest, err := parseCSR(csrBytes)
+	if err != nil {
+		return nil, err
+	}
+	return csrRequest.PublicKey, nil
+}
+
+// findValidSerial : returns the serial of the valid index.txt entry matching the subject
+func findValidSerial(caDir string, subject string) (string, error) {
+	entries, err := readIndexFile(caDir)
+	if err != nil {
+		return "", helpers.CustomError{Message: "Unable to load/parse the index.txt database: " + err.Error()}
+	}
+	for _, entry := range entries {
+		if entry.Status == "V" && entry.Subject == subject {
+			return entry.Serial, nil
+		}
+	}
+	return "", helpers.CustomError{Message: "Unable to find a valid entry for " + helpers.Red(subject) + " in index.txt"}
+}
+
+// supersedeIndexEntry : flags the entry as revoked, with the "superseded" reason
+func supersedeIndexEntry(caDir string, serial string) error {
+	entries, err := readIndexFile(caDir)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if entry.Serial == serial && entry.Status == "V" {
+			entries[i].Status = "R"
+			entries[i].RevocationDate = time.Now()
+			entries[i].Reason = "superseded"
+		}
+	}
+	return writeIndexEntries(caDir, entries)
+}
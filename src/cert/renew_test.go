@@ -0,0 +1,109 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/renew_test.go
+// Original timestamp: 2026/10/19 12:30
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testCommandPKI() : testPKI, as the environment the cm commands load (-e)
+func testCommandPKI(t *testing.T) *CA {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	pki := testPKI(t)
+	configDir := filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	jStream, err := json.Marshal(pki.env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(configDir, "test.json"), jStream, 0644); err != nil {
+		t.Fatal(err)
+	}
+	envConfigFile := environment.EnvConfigFile
+	environment.EnvConfigFile = "test.json"
+	t.Cleanup(func() { environment.EnvConfigFile = envConfigFile })
+	return pki
+}
+
+// The renewed certificate supersedes the previous one; its key is only replaced with -k
+func TestRenew(t *testing.T) {
+	pki := testCommandPKI(t)
+	if _, err := pki.Issue(context.Background(), testLeaf("web", "web.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(pki.env.ServerCertsDir, "private", "web.key")
+	defer func() { CertRotateKey = false }()
+
+	for _, newKey := range []bool{false, true} {
+		before, err := pki.Get(context.Background(), "web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyBefore, err := os.ReadFile(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		CertRotateKey = newKey
+		if err = Renew("web"); err != nil {
+			t.Fatalf("newkey %v: %v", newKey, err)
+		}
+		after, err := pki.Get(context.Background(), "web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyAfter, err := os.ReadFile(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oldSerial, newSerial := formatSerial(before.Certificate.SerialNumber), formatSerial(after.Certificate.SerialNumber)
+		if status, reason := indexStatus(t, pki.env.RootCAdir, oldSerial); status != "R" || reason != "superseded" {
+			t.Errorf("newkey %v: the previous serial %s is %q (%q), want R (superseded)", newKey, oldSerial, status, reason)
+		}
+		if status, _ := indexStatus(t, pki.env.RootCAdir, newSerial); status != "V" || newSerial == oldSerial {
+			t.Errorf("newkey %v: the new serial %s is %q, want V", newKey, newSerial, status)
+		}
+		if changed := !bytes.Equal(before.Certificate.RawSubjectPublicKeyInfo, after.Certificate.RawSubjectPublicKeyInfo); changed != newKey {
+			t.Errorf("newkey %v: the public key of the certificate changed: %v", newKey, changed)
+		}
+		if changed := !bytes.Equal(keyBefore, keyAfter); changed != newKey {
+			t.Errorf("newkey %v: the private key file changed: %v", newKey, changed)
+		}
+	}
+}
+
+// A certificate signed from a CSR is renewed with the CSR's key, which cannot be rotated here
+func TestRenewCSR(t *testing.T) {
+	pki := testCommandPKI(t)
+	c := CertificateStruct{CertificateName: "ext", Profile: "server"}
+	if err := c.signCSR(context.Background(), pki, testCSR(t, "ext.example.com"), "CSR", false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { CertRotateKey = false }()
+
+	CertRotateKey = true
+	if err := Renew("ext"); !errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("with -k: got %v, want ErrInvalidCertificate", err)
+	}
+	CertRotateKey = false
+	if err := Renew("ext"); err != nil {
+		t.Fatal(err)
+	}
+	if status, reason := indexStatus(t, pki.env.RootCAdir, formatSerial(c.SerialNumber)); status != "R" || reason != "superseded" {
+		t.Errorf("the previous serial is %q (%q), want R (superseded)", status, reason)
+	}
+}
@@ -6,61 +6,131 @@
 package cert
 
 import (
-	"bufio"
-	"certificateManager/environment"
 	"certificateManager/helpers"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
+var CertRevokeReason = ""
+
 // Revoke:
-// We do not implement a full CRL-CDP mechanism to revoke a certificate, as this tool is mainly intended
-// For local infrasctures, local PKIs
-// Instead, we proceed this way:
-// 1. The certificate is removed from index.txt, within the PKI. The cert is looked according to its signature (CN, O, OU, etc)
-// from its config file
-// 2. The certificate is removed from the PKI's rootCA/newcerts directory
-// 3. Optionally, the CSR, private key and certificate and config file are also removed.
+// We do not implement a CDP mechanism to publish revocations, as this tool is mainly intended
+// For local infrasctures, local PKIs; the CRL itself is generated from index.txt with GenerateCRL (cm crl generate)
+// We proceed this way:
+// 1. The certificate is flagged as revoked in index.txt, within the PKI, with its revocation date and optional reason.
+// The cert is looked according to its signature (CN, O, OU, etc) from its config file
+// 2. Optionally, the certificate is removed from the PKI's rootCA/newcerts directory, along with
+// the CSR, private key and certificate and config file.
 // The default setting is to leave them
 
 func Revoke(certname string) error {
 	var err error
-	e := environment.EnvironmentStruct{}
 	c := CertificateStruct{}
 
-	if e, err = environment.LoadEnvironmentFile(); err != nil {
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+
+	reason, err := normalizeRevokeReason(CertRevokeReason)
+	if err != nil {
+		return err
+	}
+
+	if c, err = revokeCertificate(context.Background(), pki, certname, reason); err != nil {
 		return err
 	}
 
+	fmt.Printf("Certificate %s has successfully been %s\n", c.CertificateName, helpers.Green("revoked"))
+	return nil
+}
+
+// revokeCertificate() : flags the named certificate as revoked in its issuer's index.txt, and records it in the audit log
+// The reason is already normalized
+func revokeCertificate(ctx context.Context, pki *CA, certname string, reason string) (CertificateStruct, error) {
+	var c CertificateStruct
+	var err error
+	e := pki.env
+
 	if !strings.HasSuffix(certname, ".json") {
 		certname += ".json"
 	}
 
 	// We need to load the cert's config in order to find the info needed to remove it from the index DB
-	if c, err = LoadCertificateConfFile(filepath.Join(e.CertificatesConfigDir, certname)); err != nil {
-		return err
+	if c, err = loadCertificateConfig(e, certname); err != nil {
+		return c, err
 	}
 
-	if err = putRevokeFlag(e, certname, c.Country, c.Province, c.Locality, c.Organization,
-		c.OrganizationalUnit, c.CommonName); err != nil {
-		return err
+	// The certificate is registered in its issuer's index.txt
+	caDir, err := issuerDirectory(e, c.Issuer)
+	if err != nil {
+		return c, err
 	}
+	unlock, err := helpers.LockDirectoryContext(ctx, caDir)
+	if err != nil {
+		return c, err
+	}
+	defer unlock()
 
-	fmt.Printf("Certificate %s has successfully been %s\n", c.CertificateName, helpers.Green("revoked"))
-	return nil
+	// The certificate file may be removed with the other artefacts: we read it now, for the audit log
+	certPEM, _ := os.ReadFile(c.certificateFilePath(e))
+	if err = putRevokeFlag(pki, caDir, certname, c.Country, c.Province, c.Locality, c.Organization,
+		c.OrganizationalUnit, c.CommonName, reason); err != nil {
+		return c, err
+	}
+	details := "reason: " + reason
+	if reason == "" {
+		details = "no reason given"
+	}
+	if pki.options.RemoveFiles {
+		details += ", files removed"
+	}
+	return c, auditCertificate(pki, "revoke", c, certPEM, details)
+}
+
+// normalizeRevokeReason: maps the user-provided reason to its OpenSSL name, case-insensitively
+// An empty reason is allowed, and is recorded as such (no reason code in the CRL)
+func normalizeRevokeReason(reason string) (string, error) {
+	if reason == "" {
+		return "", nil
+	}
+	for _, name := range revokeReasons() {
+		if strings.EqualFold(name, reason) {
+			return name, nil
+		}
+	}
+	return "", helpers.CustomError{Message: "Invalid revocation reason: " + helpers.Red(reason) + " (valid values are " +
+		strings.Join(revokeReasons(), ", ") + ")", Err: ErrInvalidReason}
 }
 
-// putRevokeFlag: remove entry from index.txt, and unlink (delete) the certificate from newcerts/
-// We scan the index.txt file, tracking an entry that contains "targetString"
-// If the entry is found, we extract the serial number (the 4 digit hex number, 3rd field of the line)
-// We then rewrite index.txt without that entry
-// If found, we then unlink newcerts/$SERIAL_NUM.pem
-func putRevokeFlag(e environment.EnvironmentStruct, certname string, country string, province string,
-	locality string, org string, ou string, cn string) error {
+// revokeReasons() : the reasons a certificate can be revoked for, in the order of their codes
+// removeFromCRL only makes sense in a delta CRL, to undo a certificateHold
+func revokeReasons() []string {
+	var reasons []string
+	for name := range crlReasonCodes {
+		if name != "removeFromCRL" {
+			reasons = append(reasons, name)
+		}
+	}
+	slices.SortFunc(reasons, func(a, b string) int { return crlReasonCodes[a] - crlReasonCodes[b] })
+	return reasons
+}
+
+// putRevokeFlag: flags the entry as revoked in the issuing CA's (caDir) index.txt, and unlink (delete) the certificate from newcerts/
+// We scan the index.txt file, tracking a valid entry that contains "targetString"
+// If the entry is found, its status becomes R, and the revocation date and reason are written in the 3rd field, as OpenSSL does
+// We then rewrite index.txt
+// If asked to (Options.RemoveFiles), we then unlink newcerts/$SERIAL_NUM.pem and the other artefacts
+func putRevokeFlag(pki *CA, caDir string, certname string, country string, province string,
+	locality string, org string, ou string, cn string, reason string) error {
 	serialField := ""
 	certfilename := ""
+	e := pki.env
 
 	// Remove extension from filename
 	dotPos := strings.LastIndex(certname, ".")
@@ -71,52 +141,36 @@ func putRevokeFlag(e environment.EnvironmentStruct, certname string, country str
 	}
 
 	// This is the substring we use to track the correct certificate
-	targetString := fmt.Sprintf("/C=%s/ST=%s/L=%s/O=%s/OU=%s/CN=%s", country, province, locality, org, ou, cn)
+	targetString := fmt.Sprintf("/C=%s/ST=%s/L=%s/O=%s/OU=%s/CN=%s/", country, province, locality, org, ou, cn)
 
-	// Open in and out files
-	inFile, err := os.Open(filepath.Join(e.RootCAdir, "index.txt"))
-	if err != nil {
-		return helpers.CustomError{Message: "Unable to open index.txt: " + err.Error()}
-	}
-	defer inFile.Close()
-	outFile, err := os.Create(filepath.Join(e.RootCAdir, "index.txt.new"))
+	entries, err := readIndexFile(caDir)
 	if err != nil {
-		return helpers.CustomError{Message: "Unable to create temp index file: " + err.Error()}
+		return helpers.CustomError{Message: "Unable to read index.txt: " + err.Error()}
 	}
-	defer outFile.Close()
-
-	// Now we scan the infile to find the substring in parameters
-	scanner := bufio.NewScanner(inFile)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
 
-		// Check if the line contains the search string
-		if strings.Contains(line, targetString) {
-			// Extract the 3rd field (certificate number)
-			if len(fields) >= 3 {
-				fields[0] = "R"
-				serialField = fields[2]
-
-				// Write the modified line to the output file
-				newLine := strings.Join(fields, "\t") + "\n"
-				outFile.WriteString(newLine)
-			}
-		} else {
-			// Write unmodified lines to the output file
-			outFile.WriteString(line + "\n")
+	// Now we scan the entries to find the substring in parameters
+	now := time.Now()
+	for i, entry := range entries {
+		if entry.Status == "V" && strings.Contains(entry.Subject+"/", targetString) {
+			entries[i].Status = "R"
+			entries[i].RevocationDate = now
+			entries[i].Reason = reason
+			serialField = entry.Serial
 		}
 	}
+	if serialField == "" {
+		return helpers.CustomError{Message: "Unable to find a valid entry for " + helpers.Red(certfilename) + " in index.txt", Err: ErrNotFound}
+	}
 
-	if err := scanner.Err(); err != nil {
-		return helpers.CustomError{Message: "Unable to read index.txt: " + err.Error()}
+	if err = writeIndexEntries(caDir, entries); err != nil {
+		return helpers.CustomError{Message: "Unable to write index.txt: " + err.Error()}
 	}
 
-	if CertRemoveFiles {
-		os.Remove(filepath.Join(e.RootCAdir, "newcerts", strings.ToUpper(serialField)+".pem"))
+	if pki.options.RemoveFiles {
+		os.Remove(filepath.Join(caDir, "newcerts", strings.ToUpper(serialField)+".pem"))
 		os.Remove(filepath.Join(e.CertificatesConfigDir, certfilename+".json"))
-		os.Remove(filepath.Join(e.ServerCertsDir, "cert", certfilename+".crt"))
+		os.Remove(filepath.Join(e.ServerCertsDir, "certs", certfilename+".crt"))
+		os.Remove(filepath.Join(e.ServerCertsDir, "certs", certfilename+"-fullchain.pem"))
 		os.Remove(filepath.Join(e.ServerCertsDir, "csr", certfilename+".csr"))
 		os.Remove(filepath.Join(e.ServerCertsDir, "private", certfilename+".key"))
 		if _, err = os.Stat(filepath.Join(e.ServerCertsDir, "java", certfilename+".p12")); err != nil && os.IsNotExist(err) {
@@ -129,10 +183,12 @@ func putRevokeFlag(e environment.EnvironmentStruct, certname string, country str
 		} else {
 			os.Remove(filepath.Join(e.ServerCertsDir, "java", certfilename+".jks"))
 		}
+		if _, err = os.Stat(filepath.Join(e.ServerCertsDir, "java", certfilename+"-truststore.jks")); err != nil && os.IsNotExist(err) {
+			// nop
+		} else {
+			os.Remove(filepath.Join(e.ServerCertsDir, "java", certfilename+"-truststore.jks"))
+		}
 	}
 
-	// Rename new file to index.txt
-	os.Rename(filepath.Join(e.RootCAdir, "index.txt.new"), filepath.Join(e.RootCAdir, "index.txt"))
-
 	return nil
 }
@@ -0,0 +1,52 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/revoke_test.go
+// Original timestamp: 2026/10/19 09:05
+
+package cert
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeRevokeReason(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+		code   int
+	}{
+		{"", "", 0},
+		{"keycompromise", "keyCompromise", 1},
+		{"CACOMPROMISE", "CACompromise", 2},
+		{"superseded", "superseded", 4},
+		{"certificateHold", "certificateHold", 6},
+		{"privilegeWithdrawn", "privilegeWithdrawn", 9},
+		{"aacompromise", "aACompromise", 10},
+	}
+	for _, tt := range tests {
+		got, err := normalizeRevokeReason(tt.reason)
+		if err != nil || got != tt.want || crlReasonCodes[got] != tt.code {
+			t.Errorf("%q: got %q (code %d), %v, want %q (code %d)", tt.reason, got, crlReasonCodes[got], err, tt.want, tt.code)
+		}
+	}
+	for _, reason := range []string{"removeFromCRL", "compromised", "7"} {
+		if _, err := normalizeRevokeReason(reason); !errors.Is(err, ErrInvalidReason) {
+			t.Errorf("%q: got %v, want ErrInvalidReason", reason, err)
+		}
+	}
+}
+
+// Every RFC 5280 reason code but removeFromCRL (8) can be given, and 7 is not used
+func TestRevokeReasons(t *testing.T) {
+	want := []int{0, 1, 2, 3, 4, 5, 6, 9, 10}
+	reasons := revokeReasons()
+	if len(reasons) != len(want) {
+		t.Fatalf("got %q", reasons)
+	}
+	for i, name := range reasons {
+		if crlReasonCodes[name] != want[i] {
+			t.Errorf("%s: code %d, want %d", name, crlReasonCodes[name], want[i])
+		}
+	}
+}
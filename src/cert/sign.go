@@ -6,107 +6,86 @@
 package cert
 
 import (
+	"bytes"
 	"certificateManager/environment"
 	"certificateManager/helpers"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
 	"math/big"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"software.sslmate.com/src/go-pkcs12"
 	"strings"
 	"time"
 )
 
-// signCert: Sign the certificate against the root CA currently held in our custom PKI
+// signCert: Sign the certificate against the issuing CA (root or intermediate) currently held in our custom PKI
 // Steps:
-// 0. Ensure that there is a single CA (.crt) file in the CA directory
-// 1. Load the CA cert and private key
-// 2. Decode and parse them
-// 3. Load & parse the CSR file
-// 4. Populate a x509 cert template with the CertificateStruct values
-// 5. Sign (create) the certificate
-// 6. Save to disk
-func (c CertificateStruct) signCert(env environment.EnvironmentStruct) error {
-	var csrBytes, caCertPEM, caKeyPEM []byte
+// 1. Load & parse the CSR file
+// 2. Populate a x509 cert template with the CertificateStruct values, and sign (create) the certificate
+// 3. Save to disk, along with the full chain
+func (c CertificateStruct) signCert(env environment.EnvironmentStruct, ca issuingCA) error {
+	var csrBytes []byte
 	var csrRequest *x509.CertificateRequest
-	var caCert *x509.Certificate
-	var caKey *rsa.PrivateKey
 	var err error
 
-	// Ensure there is a single file in the CA directory and fetch its name
-	caCertFiles, err := filepath.Glob(filepath.Join(env.RootCAdir, "*.crt"))
-	if err != nil {
-		return helpers.CustomError{Message: "Error listing CA certificate files: " + err.Error()}
-	}
-	if len(caCertFiles) != 1 {
-		return helpers.CustomError{Message: "Expected one CA certificate file, found " + helpers.Red(string(len(caCertFiles)))}
-	}
-	baseFN := strings.TrimSuffix(filepath.Base(caCertFiles[0]), filepath.Ext(filepath.Base(caCertFiles[0])))
-
-	// 1. Load the CA cert and key files
-	if caCertPEM, err = os.ReadFile(filepath.Join(env.RootCAdir, baseFN+".crt")); err != nil {
-		return helpers.CustomError{Message: "Error reading CA certificate: " + err.Error()}
-	}
-	if caKeyPEM, err = os.ReadFile(filepath.Join(env.RootCAdir, baseFN+".key")); err != nil {
-		return helpers.CustomError{Message: "Error reading CA private key: " + err.Error()}
-	}
-
-	// 2. Parse the CA cert and key files
-	caCertBlock, _ := pem.Decode(caCertPEM)
-	caKeyBlock, _ := pem.Decode(caKeyPEM)
-	if caCertBlock == nil || caKeyBlock == nil {
-		return helpers.CustomError{Message: "Error PEM-decoding the CA certificate or its private key"}
-	}
-	if caCert, err = x509.ParseCertificate(caCertBlock.Bytes); err != nil {
-		return err
-	}
-	if caKey, err = x509.ParsePKCS1PrivateKey(caKeyBlock.Bytes); err != nil {
-		return err
-	}
-
-	// 3. Load, decode and parse the CSR file
+	// 1. Load, decode and parse the CSR file
 	if csrBytes, err = os.ReadFile(filepath.Join(env.ServerCertsDir, "csr", c.CertificateName+".csr")); err != nil {
 		return err
 	}
 	if csrBlock, _ := pem.Decode(csrBytes); csrBlock == nil {
-		return helpers.CustomError{"Error PEM-decoding the CSR file"}
+		return helpers.CustomError{Message: "Error PEM-decoding the CSR file"}
 	} else {
 		if csrRequest, err = x509.ParseCertificateRequest(csrBlock.Bytes); err != nil {
 			return err
 		}
 	}
 
-	// 4. Populate x509 template
+	// 2. Sign
+	certDER, err := c.signPublicKey(ca, csrRequest.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	// 3. Save to disk
+	return c.saveSignedCert(env, ca, certDER)
+}
+
+// signPublicKey() : the DER certificate of the public key, signed by the issuing CA; nothing is written to disk
+func (c CertificateStruct) signPublicKey(ca issuingCA, publicKey crypto.PublicKey) ([]byte, error) {
+	extKeyUsage, err := getExtKeyUsageFromStrings(c.ExtKeyUsage)
+	if err != nil {
+		return nil, err
+	}
 	template := x509.Certificate{
-		SerialNumber:          big.NewInt(int64(c.SerialNumber)),
+		SerialNumber:          c.SerialNumber,
 		Subject:               pkix.Name{CommonName: c.CommonName, Locality: []string{c.Locality}, Country: []string{c.Country}, Organization: []string{c.Organization}, OrganizationalUnit: []string{c.OrganizationalUnit}, Province: []string{c.Province}},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(c.Duration, 0, 0),
 		KeyUsage:              getKeyUsageFromStrings(c.KeyUsage),
+		ExtKeyUsage:           extKeyUsage,
 		IsCA:                  c.IsCA,
 		BasicConstraintsValid: true,
 		DNSNames:              c.DNSNames,
 		IPAddresses:           c.IPAddresses,
 		EmailAddresses:        c.EmailAddresses,
+		OCSPServer:            c.OCSPServers,
 	}
 	//// why the next ???
 	//if c.IsCA {
 	//	template.KeyUsage = reindexKeyUsage(c)
 	//}
 
-	// 5. Create (sign) the certificate
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, caCert, csrRequest.PublicKey, caKey)
-	if err != nil {
-		return err
-	}
+	return x509.CreateCertificate(rand.Reader, &template, ca.Cert, publicKey, ca.Key)
+}
 
-	// 6. Encode, save to disk
+// saveSignedCert() : writes the certificate, its full chain, and its copy in the issuing CA's newcerts/ directory
+func (c CertificateStruct) saveSignedCert(env environment.EnvironmentStruct, ca issuingCA, certDER []byte) error {
 	certFile, err := os.Create(filepath.Join(env.ServerCertsDir, "certs", c.CertificateName+".crt"))
 	if err != nil {
 		return err
@@ -117,90 +96,134 @@ func (c CertificateStruct) signCert(env environment.EnvironmentStruct) error {
 		return err
 	}
 
-	// We also need to save the new certificate in the rootCA "newcerts" directory
-	if err = os.Mkdir(filepath.Join(env.RootCAdir, "newcerts"), os.ModePerm); err != nil && !os.IsExist(err) {
-		return err
-	}
-	newcertFile, err := os.Create(filepath.Join(env.RootCAdir, "newcerts", fmt.Sprintf("%04X.pem", c.SerialNumber)))
+	// The full chain (certificate, intermediates, root) is what most servers want to present
+	leaf, err := x509.ParseCertificate(certDER)
 	if err != nil {
-		return helpers.CustomError{Message: "Unable to create the certificate within root CA's PKI: " + err.Error()}
+		return err
 	}
-	defer newcertFile.Close()
-	if pem.Encode(newcertFile, &pem.Block{Type: "CERTIFICATE", Bytes: certDER}); err != nil {
+	if err = writeCertificates(filepath.Join(env.ServerCertsDir, "certs", c.CertificateName+"-fullchain.pem"), append([]*x509.Certificate{leaf}, ca.Chain...)); err != nil {
 		return err
 	}
 
-	if CertJava {
-		return c.createJavaCert(env, caCert, caKey)
+	// We also need to save the new certificate in the issuing CA's "newcerts" directory
+	if err = saveNewcert(ca.Dir, c.SerialNumber, certDER); err != nil {
+		return err
 	}
 
-	fmt.Printf("Certificate %s with a duration of %v years successfully created in %s\n",
-		helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(filepath.Join(env.ServerCertsDir, "certs")))
 	return nil
 }
 
+// saveNewcert : keeps a copy of the issued certificate in the CA's newcerts/ directory, named after its serial number
+func saveNewcert(caDir string, serial *big.Int, certDER []byte) error {
+	if err := os.Mkdir(filepath.Join(caDir, "newcerts"), os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	newcertFile, err := os.Create(filepath.Join(caDir, "newcerts", formatSerial(serial)+".pem"))
+	if err != nil {
+		return helpers.CustomError{Message: "Unable to create the certificate within root CA's PKI: " + err.Error()}
+	}
+	defer newcertFile.Close()
+	return pem.Encode(newcertFile, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+}
+
 // createCA and signCert are very similar: one is for non-CA cert, the other (below) for CA cert
 // I *could* fold both into a single function, with tons of "if c.IsCA{}" clauses, but it's not worth
 // the readability headache that it'd bring
-func (c CertificateStruct) createCA(env environment.EnvironmentStruct, privateKey *rsa.PrivateKey) error {
-	var caBytes []byte
-	var err error
+// A CA without an issuer is a self-signed root CA; otherwise it is an intermediate CA, signed by its issuer,
+// and stored in its own directory with its chain
+func (c CertificateStruct) createCA(env environment.EnvironmentStruct, privateKey crypto.Signer, issuer *issuingCA) error {
+	caBytes, err := c.signCA(privateKey, issuer)
+	if err != nil {
+		return err
+	}
+	return c.saveCACert(env, issuer, caBytes)
+}
 
+// signCA() : the DER certificate of the CA, self-signed or signed by its issuer; nothing is written to disk
+func (c CertificateStruct) signCA(privateKey crypto.Signer, issuer *issuingCA) ([]byte, error) {
+	extKeyUsage, err := getExtKeyUsageFromStrings(c.ExtKeyUsage)
+	if err != nil {
+		return nil, err
+	}
 	template := x509.Certificate{
-		SerialNumber:          big.NewInt(1),
+		SerialNumber:          c.SerialNumber,
 		Subject:               pkix.Name{CommonName: c.CommonName, Locality: []string{c.Locality}, Country: []string{c.Country}, Organization: []string{c.Organization}, OrganizationalUnit: []string{c.OrganizationalUnit}, Province: []string{c.Province}},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(c.Duration, 0, 0),
 		KeyUsage:              getKeyUsageFromStrings(c.KeyUsage),
+		ExtKeyUsage:           extKeyUsage,
 		IsCA:                  c.IsCA,
 		BasicConstraintsValid: true,
 		DNSNames:              c.DNSNames,
 		IPAddresses:           c.IPAddresses,
 		EmailAddresses:        c.EmailAddresses,
 	}
-	if caBytes, err = x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey); err != nil {
+
+	// Root CA: self-signed
+	if issuer == nil {
+		return x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+	}
+
+	// Intermediate CA: signed by its issuer, which might run an OCSP responder
+	template.OCSPServer = c.OCSPServers
+	return x509.CreateCertificate(rand.Reader, &template, issuer.Cert, privateKey.Public(), issuer.Key)
+}
+
+// saveCACert() : writes the CA certificate in its directory, along with the chain and the issuer's newcerts/ copy of an
+// Intermediate CA
+func (c CertificateStruct) saveCACert(env environment.EnvironmentStruct, issuer *issuingCA, caBytes []byte) error {
+	caDir := c.caDirectory(env)
+	if err := os.MkdirAll(filepath.Join(caDir, "newcerts"), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(caDir, c.CertificateName+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}), 0644); err != nil {
 		return err
 	}
+	if issuer == nil {
+		return nil
+	}
 
-	cafile, err := os.Create(filepath.Join(env.RootCAdir, c.CertificateName+".crt"))
+	caCert, err := x509.ParseCertificate(caBytes)
 	if err != nil {
 		return err
 	}
-	defer cafile.Close()
-
-	if err = pem.Encode(cafile, &pem.Block{Type: "CERTIFICATE", Bytes: caBytes}); err != nil {
+	if err = writeCertificates(filepath.Join(caDir, "chain.pem"), append([]*x509.Certificate{caCert}, issuer.Chain...)); err != nil {
 		return err
 	}
-
-	fmt.Printf("Root CA certificate %s with a duration of %v years successfully created in %s\n",
-		helpers.White(c.CertificateName), helpers.White(fmt.Sprintf("%v", c.Duration)), helpers.White(env.RootCAdir))
-	return nil
+	return saveNewcert(issuer.Dir, c.SerialNumber, caBytes)
 }
 
 // createJavaCert:
 // Much software still use the Java Keystore (JKS) format, which has been deemed obsolete for some time.
 // The process is thus, so far:
-// 1. Load the CA cert file, key, server cert file, key
+// 1. Load the server cert file and key, and the CA chain of the issuer
 // NEW step: remove old (outdated) .p12 and .jks files, if present
 // 2. Convert the server .crt to PKCS#12 (.p12) format
-// 3. Convert the .p12 file to .JKS
+// 3. Write the keystore (.jks): the private key entry, with the server cert and its CA chain
+// 4. Write the truststore (-truststore.jks): the CA chain only, as trusted certificate entries
 // I'll keep the .p12 file in storage, just in case that whatever software needing a JKS comes to its senses
 // And asks for a .p12 instead
 // All files will be stored in the java/ directory
 
-// SIGNATURE: (environment, parsed cacert, parsed cacert key) returns error
-func (c CertificateStruct) createJavaCert(e environment.EnvironmentStruct, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
+// SIGNATURE: (PKI) returns error
+func (c CertificateStruct) createJavaCert(pki *CA) error {
 	var certPEM []byte
 	var certBlock *pem.Block
 	var err error
 	var serverCert *x509.Certificate
-	var serverKey *rsa.PrivateKey
+	var serverKey crypto.Signer
 	certPasswd := ""
+	e := pki.env
 
-	// Fetch the server's private key
-	if serverKey, err = c.getPrivateKey(e); err != nil {
+	// Fetch the server's private key, and the chain of its issuer
+	if serverKey, err = c.getPrivateKey(pki); err != nil {
 		return err
 	}
+	ca, err := loadIssuerChain(e, c.Issuer)
+	if err != nil {
+		return err
+	}
+	caChain := ca.Chain
 
 	// Load, decode and parse the current server cert
 	if certPEM, err = os.ReadFile(filepath.Join(e.ServerCertsDir, "certs", c.CertificateName+".crt")); err != nil {
@@ -211,61 +234,78 @@ func (c CertificateStruct) createJavaCert(e environment.EnvironmentStruct, caCer
 		return err
 	}
 
-	// PKCS#12 requires the file to be password-protected
+	// PKCS#12 requires the file to be password-protected, and so does JKS (6 characters minimum, as keytool)
 	certPasswd = helpers.GetPassword("Please provide a password for this Java certificate: ")
+	if len(certPasswd) < javaMinPasswordLen {
+		return helpers.CustomError{Message: fmt.Sprintf("The Java keystore password must be at least %d characters long", javaMinPasswordLen)}
+	}
 
 	// Remove outdated .p12 and .jks files, if present
 	basename := filepath.Join(e.ServerCertsDir, "java", c.CertificateName)
-	for _, fn := range []string{basename + ".p12", basename + ".jks"} {
+	for _, fn := range []string{basename + ".p12", basename + ".jks", basename + "-truststore.jks"} {
 		errfn := os.Remove(fn)
 		if errfn != nil {
 			if os.IsNotExist(errfn) {
 				continue
 			} else {
-				return helpers.CustomError{Message: fmt.Sprintf("Unable to remove %s : ", fn) + err.Error()}
+				return helpers.CustomError{Message: fmt.Sprintf("Unable to remove %s : ", fn) + errfn.Error()}
 			}
 		}
 	}
 
 	// Convert cert to PKCS#12
-	pkcs12Data, err := pkcs12.Encode(rand.Reader, serverKey, serverCert, []*x509.Certificate{caCert}, certPasswd)
+	pkcs12Data, err := pkcs12.Encode(rand.Reader, serverKey, serverCert, caChain, certPasswd)
 	if err != nil {
 		return helpers.CustomError{Message: "Error encoding the certificate in PKCS#12: " + err.Error()}
 	}
 
-	if err = os.WriteFile(filepath.Join(e.ServerCertsDir, "java", c.CertificateName+".p12"), pkcs12Data, 0644); err != nil {
+	// The .p12 holds the private key: owner-only, even when it replaces a file written by a previous release
+	if err = writeFileAtomic(basename+".p12", pkcs12Data, 0600); err != nil {
 		return err
 	}
 
-	// FOLLOWING COMMENTED CODE IS THERE IN CASE I FIND A SOLUTION TO REPLACE cmd:= exec.Command(), a bit below
-
-	// Now, it's a bit stupid, but I need to re-decode the P12 file to then encode it in JKS
-	//if p12Data, err = os.ReadFile(filepath.Join(e.CertificateRootDir, e.ServerCertsDir, "java", c.CertificateName+".p12")); err != nil {
-	//	return err
-	//}
-	//if p12, _, err := pkcs12.Decode(p12Data, certPasswd); err != nil {
-	//	return err
-	//}
-	//
-	//// Create Keystore, encode
-	//if jksFile, err = os.Create(filepath.Join(e.CertificateRootDir, e.ServerCertsDir, "java", c.CertificateName+".jks")); err != nil {
-	//	return err
-	//}
-	//defer jksFile.Close()
+	// The keystore: a single private key entry, aliased after the certificate, holding the whole chain
+	keyDER, err := x509.MarshalPKCS8PrivateKey(serverKey)
+	if err != nil {
+		return helpers.CustomError{Message: "Unable to marshal the private key: " + err.Error()}
+	}
+	chain := []keystore.Certificate{{Type: "X509", Content: serverCert.Raw}}
+	for _, crt := range caChain {
+		chain = append(chain, keystore.Certificate{Type: "X509", Content: crt.Raw})
+	}
+	ks := keystore.New()
+	if err = ks.SetPrivateKeyEntry(c.CertificateName, keystore.PrivateKeyEntry{
+		CreationTime:     time.Now(),
+		PrivateKey:       keyDER,
+		CertificateChain: chain,
+	}, []byte(certPasswd)); err != nil {
+		return helpers.CustomError{Message: "Error encoding the certificate in JKS: " + err.Error()}
+	}
+	if err = writeJavaKeystore(basename+".jks", ks, certPasswd, 0600); err != nil {
+		return err
+	}
 
-	// No other way for now <sigh>
-	cmd := exec.Command("keytool", "-importkeystore", "-srcstorepass", certPasswd,
-		"-deststorepass", certPasswd,
-		"-destkeystore", filepath.Join(e.ServerCertsDir, "java", c.CertificateName+".jks"),
-		"-srckeystore", filepath.Join(e.ServerCertsDir, "java", c.CertificateName+".p12"),
-		"-srcstoretype", "PKCS12")
+	// The truststore: the CA chain only, one trusted certificate entry per CA
+	ts := keystore.New(keystore.WithOrderedAliases())
+	for _, crt := range caChain {
+		if err = ts.SetTrustedCertificateEntry(strings.ToLower(certificateNameFromCN(crt.Subject.CommonName)), keystore.TrustedCertificateEntry{
+			CreationTime: time.Now(),
+			Certificate:  keystore.Certificate{Type: "X509", Content: crt.Raw},
+		}); err != nil {
+			return helpers.CustomError{Message: "Error encoding the truststore in JKS: " + err.Error()}
+		}
+	}
+	return writeJavaKeystore(basename+"-truststore.jks", ts, certPasswd, 0644)
+}
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// javaMinPasswordLen : keytool refuses keystore passwords shorter than this
+const javaMinPasswordLen = 6
 
-	if err := cmd.Run(); err != nil {
-		return helpers.CustomError{Message: "Keytool command failed: " + err.Error()}
+// writeJavaKeystore : stores the keystore on disk, protected by the password; an existing file is replaced, mode included
+func writeJavaKeystore(path string, ks keystore.KeyStore, password string, perm os.FileMode) error {
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(password)); err != nil {
+		return helpers.CustomError{Message: "Unable to write " + path + ": " + err.Error()}
 	}
-
-	return nil
+	return writeFileAtomic(path, buf.Bytes(), perm)
 }
@@ -0,0 +1,40 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/sign_test.go
+// Original timestamp: 2026/10/19 11:10
+
+package cert
+
+import (
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A keystore written by a previous release, readable by all, is replaced with the requested mode
+func TestWriteJavaKeystoreMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.jks")
+	if err := os.WriteFile(path, []byte("old keystore"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJavaKeystore(path, keystore.New(), "changeit", 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %o, want 0600", info.Mode().Perm())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = keystore.New().Load(f, []byte("changeit")); err != nil {
+		t.Errorf("the keystore cannot be read back: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/sshCA.go
+// Original timestamp: 2026/10/19 02:05
+
+// The SSH certificate authorities of an environment: each sits in RootCAdir/ssh/NAME, with its key (NAME.key, or on the
+// PKCS#11 token), its public key in authorized_keys format (NAME.pub), and its own serial, index.txt and newcerts/
+// Their public keys are exported as known_hosts @cert-authority lines (host certificates), or as a TrustedUserCAKeys
+// File for sshd (user certificates)
+
+package cert
+
+import (
+	"certificateManager/helpers"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var SSHKeyAlgorithm = ""
+var SSHKnownHosts = ""
+var SSHTrustedUserCAKeys = false
+var SSHExportOutput = ""
+
+// sshCA : an SSH certificate authority, with its signer once loaded
+type sshCA struct {
+	Name   string
+	Dir    string
+	Public ssh.PublicKey
+	Signer ssh.Signer
+}
+
+// sshCAsDir : the directory holding all SSH CAs
+func (pki *CA) sshCAsDir() string {
+	return filepath.Join(pki.env.RootCAdir, "ssh")
+}
+
+// sshCANames : the names of the environment's SSH CAs, sorted
+func (pki *CA) sshCANames() ([]string, error) {
+	names := []string{}
+	dirs, err := os.ReadDir(pki.sshCAsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return names, nil
+		}
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if _, err = os.Stat(filepath.Join(pki.sshCAsDir(), dir.Name(), dir.Name()+".pub")); dir.IsDir() && err == nil {
+			names = append(names, dir.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadSSHCA() : the named SSH CA, or the environment's only one if no name is given; its signer is only loaded if asked to
+func (pki *CA) loadSSHCA(name string, withSigner bool) (sshCA, error) {
+	if name == "" {
+		names, err := pki.sshCANames()
+		if err != nil {
+			return sshCA{}, err
+		}
+		if len(names) != 1 {
+			return sshCA{}, helpers.CustomError{Message: fmt.Sprintf("The environment has %d SSH CAs: please name the one to use", len(names)), Err: ErrUnknownIssuer}
+		}
+		name = names[0]
+	}
+	if !apiNameRegexp.MatchString(name) {
+		return sshCA{}, helpers.CustomError{Message: "Invalid SSH CA name: " + helpers.Red(name), Err: ErrUnknownIssuer}
+	}
+
+	ca := sshCA{Name: name, Dir: filepath.Join(pki.sshCAsDir(), name)}
+	pubBytes, err := os.ReadFile(filepath.Join(ca.Dir, name+".pub"))
+	if err != nil {
+		return sshCA{}, helpers.CustomError{Message: "Unknown SSH CA: " + helpers.Red(name), Err: ErrUnknownIssuer}
+	}
+	if ca.Public, _, _, _, err = ssh.ParseAuthorizedKey(pubBytes); err != nil {
+		return sshCA{}, helpers.CustomError{Message: "Unable to parse the public key of the SSH CA " + name + ": " + err.Error()}
+	}
+	if !withSigner {
+		return ca, nil
+	}
+
+	key, err := pki.signingKey(sshTokenName(name), filepath.Join(ca.Dir, name+".key"))
+	if err != nil {
+		return sshCA{}, err
+	}
+	if ca.Signer, err = ssh.NewSignerFromSigner(key); err != nil {
+		return sshCA{}, helpers.CustomError{Message: "The key of the SSH CA " + name + " cannot sign: " + err.Error()}
+	}
+	if string(ca.Signer.PublicKey().Marshal()) != string(ca.Public.Marshal()) {
+		return sshCA{}, helpers.CustomError{Message: "The key of the SSH CA " + name + " does not match its public key " + name + ".pub"}
+	}
+	return ca, nil
+}
+
+// sshTokenName() : SSH CAs have their own names on the token, so that they do not collide with the X.509 CAs
+func sshTokenName(name string) string {
+	return "ssh-" + name
+}
+
+// CreateSSHCA :
+// Creates an SSH CA: its key pair, generated on the PKCS#11 token if the environment has one, and its empty database
+// The key algorithm is ed25519 by default, or ecdsa-p256 on a token
+func (pki *CA) CreateSSHCA(ctx context.Context, name string, keyAlgorithm string) (ssh.PublicKey, error) {
+	var key crypto.Signer
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !apiNameRegexp.MatchString(name) {
+		return nil, helpers.CustomError{Message: "Invalid SSH CA name: " + helpers.Red(name), Err: ErrInvalidCertificate}
+	}
+	c := CertificateStruct{CertificateName: name, IsCA: true, KeyAlgorithm: keyAlgorithm}
+	if c.KeyAlgorithm == "" {
+		c.KeyAlgorithm = "ed25519"
+		if pki.env.PKCS11 != nil {
+			c.KeyAlgorithm = "ecdsa-p256"
+		}
+	}
+	if c.KeyAlgorithm, err = normalizeKeyAlgorithm(c.KeyAlgorithm); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(pki.sshCAsDir(), name)
+	unlock, err := helpers.LockDirectoryContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err = os.Stat(filepath.Join(dir, name+".pub")); err == nil {
+		return nil, helpers.CustomError{Message: "The SSH CA " + helpers.Red(name) + " already exists", Err: ErrDuplicate}
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "newcerts"), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// The key: on the token, or in NAME.key, encrypted as per the KeyEncryption policy for CA keys
+	if c.keyOnToken(pki) {
+		tokenCert := c
+		tokenCert.CertificateName = sshTokenName(name)
+		key, err = tokenCert.createTokenKey(pki)
+	} else {
+		key, err = c.generateKey(pki.options.KeySize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.keyOnToken(pki) {
+		encrypt, err := c.encryptKey(pki)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := pki.encodePrivateKeyPEM(key, encrypt)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	public, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, name+".pub"), sshAuthorizedKey(public, "cm-"+name), 0644); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(public.Marshal())
+	record := helpers.AuditRecord{Operation: "ssh-ca-create", Environment: pki.options.Name, Client: pki.options.Client,
+		Certificate: name, SHA256: hex.EncodeToString(sum[:]), Details: public.Type() + " " + ssh.FingerprintSHA256(public)}
+	if err = helpers.AppendAuditRecord(pki.env.RootCAdir, record); err != nil {
+		return nil, helpers.CustomError{Message: "The operation succeeded, but could not be recorded in the audit log: " + err.Error()}
+	}
+	return public, nil
+}
+
+// SSHCAPublicKeys :
+// The public keys of the SSH CAs, keyed by CA name: the named one, or all of them if no name is given
+func (pki *CA) SSHCAPublicKeys(ctx context.Context, name string) (map[string]ssh.PublicKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	names := []string{name}
+	if name == "" {
+		var err error
+		if names, err = pki.sshCANames(); err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, helpers.CustomError{Message: "No SSH CA found in " + pki.sshCAsDir(), Err: ErrUnknownIssuer}
+		}
+	}
+
+	keys := make(map[string]ssh.PublicKey)
+	for _, caname := range names {
+		ca, err := pki.loadSSHCA(caname, false)
+		if err != nil {
+			return nil, err
+		}
+		keys[caname] = ca.Public
+	}
+	return keys, nil
+}
+
+// sshAuthorizedKey() : the public key as an authorized_keys line, with its comment
+func sshAuthorizedKey(public ssh.PublicKey, comment string) []byte {
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(public)), "\n")
+	return []byte(line + " " + comment + "\n")
+}
+
+// CreateSSHCACommand :
+// cm ssh ca create: creates the SSH CA, and prints its public key
+func CreateSSHCACommand(name string) error {
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	public, err := pki.CreateSSHCA(context.Background(), name, SSHKeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SSH CA %s (%s, %s) successfully created in %s\n", helpers.White(name), public.Type(), ssh.FingerprintSHA256(public),
+		helpers.White(filepath.Join(pki.sshCAsDir(), name)))
+	fmt.Print(string(sshAuthorizedKey(public, "cm-"+name)))
+	return nil
+}
+
+// ExportSSHCA :
+// cm ssh ca export: the public keys of the SSH CA (or of all of them) as known_hosts @cert-authority lines, for the
+// Clients to trust the host certificates, or as a TrustedUserCAKeys file, for sshd to trust the user certificates
+func ExportSSHCA(name string) error {
+	var export strings.Builder
+
+	if (SSHKnownHosts == "") == !SSHTrustedUserCAKeys {
+		return helpers.CustomError{Message: "Please choose one of --known-hosts PATTERNS or --trusted-user-ca-keys"}
+	}
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	keys, err := pki.SSHCAPublicKeys(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(keys))
+	for caname := range keys {
+		names = append(names, caname)
+	}
+	sort.Strings(names)
+
+	for _, caname := range names {
+		if SSHTrustedUserCAKeys {
+			export.Write(sshAuthorizedKey(keys[caname], "cm-"+caname))
+		} else {
+			export.WriteString("@cert-authority " + SSHKnownHosts + " ")
+			export.Write(sshAuthorizedKey(keys[caname], "cm-"+caname))
+		}
+	}
+
+	if SSHExportOutput == "" {
+		fmt.Print(export.String())
+		return nil
+	}
+	if err = os.WriteFile(SSHExportOutput, []byte(export.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%d SSH CA public key(s) exported to %s\n", len(names), helpers.Green(SSHExportOutput))
+	return nil
+}
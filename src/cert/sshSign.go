@@ -0,0 +1,334 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/sshSign.go
+// Original timestamp: 2026/10/19 02:40
+
+// Signs OpenSSH public keys into user and host certificates, and records them in the SSH CA's own serial, index.txt
+// And newcerts/, the way indexSerial.go does for the X.509 CAs
+// SSH serial numbers are 64-bit: the random strategy draws 64 bits instead of 128
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"maps"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var SSHCAName = ""
+var SSHPrincipals []string
+var SSHKeyID = ""
+var SSHValidity time.Duration
+var SSHCriticalOptions []string
+var SSHExtensions []string
+var SSHNoDefaultExtensions = false
+var SSHSignOutput = ""
+
+// The default validity of the certificates, when none is given
+const (
+	SSHUserValidity = 7 * 24 * time.Hour
+	SSHHostValidity = 365 * 24 * time.Hour
+)
+
+// The certificates are valid from a few minutes ago, to allow for the clocks of the servers being a bit late
+const sshClockSkew = 5 * time.Minute
+
+// The critical options understood by OpenSSH; sshd refuses a certificate holding any other
+var sshCriticalOptions = []string{"force-command", "source-address", "verify-required"}
+
+// SSHCertificateRequest : what SignSSHKey puts in the certificate
+type SSHCertificateRequest struct {
+	CA              string            // name of the SSH CA; may be empty when the environment has only one
+	Host            bool              // a host certificate, instead of a user certificate
+	KeyID           string            // key identity, logged by sshd; defaults to the first principal. No slash nor control character
+	Principals      []string          // user names, or host names
+	Validity        time.Duration     // SSHUserValidity or SSHHostValidity if not set
+	CriticalOptions map[string]string // force-command, source-address, verify-required; user certificates only
+	Extensions      map[string]string // permit-pty, permit-port-forwarding...; see DefaultSSHUserExtensions()
+}
+
+// DefaultSSHUserExtensions : the extensions ssh-keygen gives to user certificates by default
+func DefaultSSHUserExtensions() map[string]string {
+	return map[string]string{"permit-X11-forwarding": "", "permit-agent-forwarding": "", "permit-port-forwarding": "",
+		"permit-pty": "", "permit-user-rc": ""}
+}
+
+// SignSSHKey :
+// Signs the public key with the SSH CA, and records the certificate in the CA's index.txt and newcerts/
+func (pki *CA) SignSSHKey(ctx context.Context, public ssh.PublicKey, req SSHCertificateRequest) (*ssh.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := req.check(public); err != nil {
+		return nil, err
+	}
+	if req.KeyID == "" {
+		req.KeyID = req.Principals[0]
+	}
+	if addresses, ok := req.CriticalOptions["source-address"]; ok {
+		req.CriticalOptions = maps.Clone(req.CriticalOptions)
+		req.CriticalOptions["source-address"] = strings.Join(sshSourceAddresses(addresses), ",")
+	}
+	if req.Validity == 0 {
+		req.Validity = SSHUserValidity
+		if req.Host {
+			req.Validity = SSHHostValidity
+		}
+	}
+
+	ca, err := pki.loadSSHCA(req.CA, true)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := helpers.LockDirectoryContext(ctx, ca.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	serial, err := nextSSHSerialNumber(pki.env, ca.Dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	crt := &ssh.Certificate{
+		Key:             public,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           req.KeyID,
+		ValidPrincipals: req.Principals,
+		ValidAfter:      uint64(now.Add(-sshClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(req.Validity).Unix()),
+		Permissions:     ssh.Permissions{CriticalOptions: req.CriticalOptions, Extensions: req.Extensions},
+	}
+	if req.Host {
+		crt.CertType = ssh.HostCert
+	}
+	if err = crt.SignCert(rand.Reader, ca.Signer); err != nil {
+		return nil, helpers.CustomError{Message: "Unable to sign the SSH certificate: " + err.Error()}
+	}
+
+	if err = registerSSHCertificate(pki.env, ca, crt); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(crt.Marshal())
+	record := helpers.AuditRecord{Operation: "ssh-sign-" + sshCertType(crt), Environment: pki.options.Name, Client: pki.options.Client,
+		Issuer: ca.Name, Certificate: crt.KeyId, Serial: formatSerial(new(big.Int).SetUint64(crt.Serial)), Subject: sshIndexSubject(crt),
+		SHA256: hex.EncodeToString(sum[:]), Details: ssh.FingerprintSHA256(public)}
+	if err = helpers.AppendAuditRecord(pki.env.RootCAdir, record); err != nil {
+		return nil, helpers.CustomError{Message: "The operation succeeded, but could not be recorded in the audit log: " + err.Error()}
+	}
+	return crt, nil
+}
+
+// check() : the request is complete, and its critical options are ones OpenSSH understands
+func (req SSHCertificateRequest) check(public ssh.PublicKey) error {
+	if public == nil {
+		return helpers.CustomError{Message: "No public key to sign", Err: ErrInvalidCertificate}
+	}
+	if _, ok := public.(*ssh.Certificate); ok {
+		return helpers.CustomError{Message: "The key to sign is already a certificate", Err: ErrInvalidCertificate}
+	}
+	if len(req.Principals) == 0 {
+		return helpers.CustomError{Message: "The certificate needs at least one principal", Err: ErrInvalidCertificate}
+	}
+	for _, principal := range req.Principals {
+		if !validSSHKeyID(principal) || strings.ContainsAny(principal, ", ") {
+			return helpers.CustomError{Message: "Invalid principal: " + helpers.Red(fmt.Sprintf("%q", principal)), Err: ErrInvalidCertificate}
+		}
+	}
+	if req.KeyID != "" && !validSSHKeyID(req.KeyID) {
+		return helpers.CustomError{Message: "Invalid key identity: " + helpers.Red(fmt.Sprintf("%q", req.KeyID)) + " (no slash nor control character)", Err: ErrInvalidCertificate}
+	}
+	if req.Validity < 0 {
+		return helpers.CustomError{Message: "The certificate validity cannot be negative", Err: ErrInvalidCertificate}
+	}
+	if req.Host && len(req.CriticalOptions) != 0 {
+		return helpers.CustomError{Message: "Host certificates have no critical options", Err: ErrInvalidCertificate}
+	}
+
+	for name, value := range req.CriticalOptions {
+		if !slices.Contains(sshCriticalOptions, name) {
+			return helpers.CustomError{Message: "Unknown critical option: " + helpers.Red(name) + " (valid options are " +
+				strings.Join(sshCriticalOptions, ", ") + ")", Err: ErrInvalidCertificate}
+		}
+		if name == "source-address" {
+			for _, address := range sshSourceAddresses(value) {
+				if _, _, err := net.ParseCIDR(address); err != nil && net.ParseIP(address) == nil {
+					return helpers.CustomError{Message: "Invalid source-address: " + helpers.Red(address), Err: ErrInvalidCertificate}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validSSHKeyID() : the key identity and the principals go into the SSH CA's index.txt, whose columns are tab-separated,
+// And into its /type=user/id=KEYID/principals=alice,bob subjects; a key comment can hold about anything
+func validSSHKeyID(id string) bool {
+	return id != "" && strings.TrimSpace(id) == id && !strings.ContainsFunc(id, func(r rune) bool { return r == '/' || unicode.IsControl(r) })
+}
+
+// sshSourceAddresses() : the addresses and CIDRs of the source-address critical option, without the spaces around them
+func sshSourceAddresses(value string) []string {
+	addresses := strings.Split(value, ",")
+	for i := range addresses {
+		addresses[i] = strings.TrimSpace(addresses[i])
+	}
+	return addresses
+}
+
+// nextSSHSerialNumber() : the serial number of the next certificate signed by the SSH CA (caDir), as per the
+// Environment's serial number strategy; like nextSerialNumber(), but bound to 64 bits
+func nextSSHSerialNumber(env environment.EnvironmentStruct, caDir string) (uint64, error) {
+	switch env.SerialNumberStrategy() {
+	case environment.SerialSequential:
+		serial, err := getSerialNumber(caDir)
+		if err != nil {
+			return 0, err
+		}
+		if serial.Add(serial, big.NewInt(1)); !serial.IsUint64() {
+			return 0, helpers.CustomError{Message: "The serial number of the SSH CA in " + caDir + " overflows 64 bits"}
+		}
+		return serial.Uint64(), nil
+	case environment.SerialRandom:
+		entries, err := readIndexFile(caDir)
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 8)
+		for {
+			if _, err = rand.Read(buf); err != nil {
+				return 0, err
+			}
+			serial := binary.BigEndian.Uint64(buf)
+			if serial != 0 && !serialInIndex(entries, new(big.Int).SetUint64(serial)) {
+				return serial, nil
+			}
+		}
+	}
+	return 0, helpers.CustomError{Message: "Unknown serial number strategy: " + helpers.Red(env.SerialStrategy) +
+		" (valid strategies are " + environment.SerialSequential + " and " + environment.SerialRandom + ")"}
+}
+
+// registerSSHCertificate() : records the certificate in the SSH CA's serial, index.txt and newcerts/SERIAL-cert.pub
+// Unlike the X.509 certificates, every certificate keeps its index.txt line: a key is often signed several times
+func registerSSHCertificate(env environment.EnvironmentStruct, ca sshCA, crt *ssh.Certificate) error {
+	serial := new(big.Int).SetUint64(crt.Serial)
+	if err := os.MkdirAll(filepath.Join(ca.Dir, "newcerts"), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ca.Dir, "newcerts", formatSerial(serial)+"-cert.pub"), sshAuthorizedKey(crt, crt.KeyId), 0644); err != nil {
+		return err
+	}
+	if env.SerialNumberStrategy() == environment.SerialSequential {
+		if err := setSerialNumber(serial, ca.Dir); err != nil {
+			return err
+		}
+	}
+
+	entries, err := readIndexFile(ca.Dir)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, indexEntry{Status: "V", Date: time.Unix(int64(crt.ValidBefore), 0),
+		Serial: formatSerial(serial), Subject: sshIndexSubject(crt)})
+	return writeIndexEntries(ca.Dir, entries)
+}
+
+// sshCertType() : user or host
+func sshCertType(crt *ssh.Certificate) string {
+	if crt.CertType == ssh.HostCert {
+		return "host"
+	}
+	return "user"
+}
+
+// sshIndexSubject() : the certificate, as written in index.txt: /type=user/id=KEYID/principals=alice,bob
+func sshIndexSubject(crt *ssh.Certificate) string {
+	return fmt.Sprintf("/type=%s/id=%s/principals=%s", sshCertType(crt), crt.KeyId, strings.Join(crt.ValidPrincipals, ","))
+}
+
+// parseSSHOptions() : the name[=value] flags, as a map; the names must be unique
+func parseSSHOptions(kind string, values []string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, value := range values {
+		name, option, _ := strings.Cut(value, "=")
+		if name == "" {
+			return nil, helpers.CustomError{Message: "Invalid " + kind + ": " + helpers.Red(value) + " (expected name or name=value)", Err: ErrInvalidCertificate}
+		}
+		if _, ok := options[name]; ok {
+			return nil, helpers.CustomError{Message: "The " + kind + " " + helpers.Red(name) + " is given twice", Err: ErrInvalidCertificate}
+		}
+		options[name] = option
+	}
+	return options, nil
+}
+
+// SignSSHKeyCommand :
+// cm ssh sign-user and cm ssh sign-host: signs the public key file, and writes the certificate next to it
+// (KEY.pub gives KEY-cert.pub, as with ssh-keygen), or in the -o file
+func SignSSHKeyCommand(keyFile string, host bool) error {
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	public, comment, _, _, err := ssh.ParseAuthorizedKey(keyBytes)
+	if err != nil {
+		return helpers.CustomError{Message: "Unable to parse the public key " + keyFile + ": " + err.Error()}
+	}
+
+	req := SSHCertificateRequest{CA: SSHCAName, Host: host, KeyID: SSHKeyID, Principals: SSHPrincipals, Validity: SSHValidity}
+	// The key comment is only a default: one that would not fit in index.txt gives way to the first principal
+	if req.KeyID == "" && validSSHKeyID(comment) {
+		req.KeyID = comment
+	}
+	if req.CriticalOptions, err = parseSSHOptions("critical option", SSHCriticalOptions); err != nil {
+		return err
+	}
+	if req.Extensions, err = parseSSHOptions("extension", SSHExtensions); err != nil {
+		return err
+	}
+	if !host && !SSHNoDefaultExtensions {
+		for name, value := range DefaultSSHUserExtensions() {
+			if _, ok := req.Extensions[name]; !ok {
+				req.Extensions[name] = value
+			}
+		}
+	}
+
+	pki, err := openCommandCA()
+	if err != nil {
+		return err
+	}
+	crt, err := pki.SignSSHKey(context.Background(), public, req)
+	if err != nil {
+		return err
+	}
+
+	output := SSHSignOutput
+	if output == "" {
+		output = strings.TrimSuffix(keyFile, ".pub") + "-cert.pub"
+	}
+	if err = os.WriteFile(output, sshAuthorizedKey(crt, crt.KeyId), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("SSH %s certificate %s (serial %d, principals %s) valid until %s, written to %s\n", sshCertType(crt), helpers.White(crt.KeyId),
+		crt.Serial, strings.Join(crt.ValidPrincipals, ","), time.Unix(int64(crt.ValidBefore), 0).Format("2006/01/02 15:04:05"), helpers.Green(output))
+	return nil
+}
@@ -0,0 +1,70 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/sshSign_test.go
+// Original timestamp: 2026/10/19 08:50
+
+package cert
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Nothing that would break the tab-separated index.txt, or its subjects, goes into the certificate
+func TestSSHCertificateRequestCheck(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  SSHCertificateRequest
+		want string
+	}{
+		{"valid", SSHCertificateRequest{KeyID: "alice@laptop", Principals: []string{"alice"}}, ""},
+		{"default key identity", SSHCertificateRequest{Principals: []string{"alice"}}, ""},
+		{"key identity with spaces", SSHCertificateRequest{KeyID: "Alice's laptop", Principals: []string{"alice"}}, ""},
+		{"key identity with a tab", SSHCertificateRequest{KeyID: "alice\tV", Principals: []string{"alice"}}, "Invalid key identity"},
+		{"key identity with a newline", SSHCertificateRequest{KeyID: "alice\nV\t991231235959Z", Principals: []string{"alice"}}, "Invalid key identity"},
+		{"key identity with a slash", SSHCertificateRequest{KeyID: "alice/principals=root", Principals: []string{"alice"}}, "Invalid key identity"},
+		{"key identity with spaces around", SSHCertificateRequest{KeyID: " alice ", Principals: []string{"alice"}}, "Invalid key identity"},
+		{"no principal", SSHCertificateRequest{KeyID: "alice"}, "at least one principal"},
+		{"principal with a comma", SSHCertificateRequest{Principals: []string{"alice,root"}}, "Invalid principal"},
+		{"principal with a newline", SSHCertificateRequest{Principals: []string{"alice\n"}}, "Invalid principal"},
+		{"source-address with spaces", SSHCertificateRequest{Principals: []string{"alice"},
+			CriticalOptions: map[string]string{"source-address": "10.0.0.0/8, 192.168.1.1 ,::1"}}, ""},
+		{"invalid source-address", SSHCertificateRequest{Principals: []string{"alice"},
+			CriticalOptions: map[string]string{"source-address": "10.0.0.0/8,example.com"}}, "Invalid source-address"},
+		{"empty source-address", SSHCertificateRequest{Principals: []string{"alice"},
+			CriticalOptions: map[string]string{"source-address": "10.0.0.0/8,"}}, "Invalid source-address"},
+		{"unknown critical option", SSHCertificateRequest{Principals: []string{"alice"},
+			CriticalOptions: map[string]string{"permit-pty": ""}}, "Unknown critical option"},
+		{"host critical option", SSHCertificateRequest{Host: true, Principals: []string{"web.example.com"},
+			CriticalOptions: map[string]string{"force-command": "true"}}, "no critical options"},
+	}
+	for _, tt := range tests {
+		err := tt.req.check(public)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSSHSourceAddresses(t *testing.T) {
+	got := sshSourceAddresses(" 10.0.0.0/8,192.168.1.1 , ::1")
+	if want := []string{"10.0.0.0/8", "192.168.1.1", "::1"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
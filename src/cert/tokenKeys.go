@@ -0,0 +1,127 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/tokenKeys.go
+// Original timestamp: 2026/10/19 01:10
+
+// CA keys held by a PKCS#11 token (HSM, smart card, SoftHSM2...), as set in the environment's PKCS11 settings
+// The keys are generated on the token when a CA is created, and never leave it: the token signs the certificates,
+// CRLs and OCSP responses. A CA whose key file is still in its directory keeps using it, unless the token also has
+// A key with its label
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tokenPINEnvVar : the environment variable suggested when the PIN cannot be prompted for
+const tokenPINEnvVar = "CM_PKCS11_PIN"
+
+// The PIN is prompted for once per run
+var tokenPINCache []byte
+
+// errNoTokenKey : the token has no key with the label we are looking for
+var errNoTokenKey = fmt.Errorf("%w: no such key", ErrToken)
+
+// keyOnToken() : whether the certificate's private key is generated on the PKCS#11 token
+func (c CertificateStruct) keyOnToken(pki *CA) bool {
+	return c.IsCA && pki.env.PKCS11 != nil
+}
+
+// caKey() : the private key of the named CA, from the token or from its key file in the CA directory
+func (pki *CA) caKey(name string, dir string) (crypto.Signer, error) {
+	return pki.signingKey(name, filepath.Join(dir, name+".key"))
+}
+
+// signingKey() : the token's key labelled after tokenName, or else the key file
+func (pki *CA) signingKey(tokenName string, keyFile string) (crypto.Signer, error) {
+	if pki.env.PKCS11 != nil {
+		key, err := loadTokenKey(pki, pki.env.PKCS11.CAKeyLabel(tokenName))
+		if err == nil {
+			return key, nil
+		}
+		if !errors.Is(err, errNoTokenKey) {
+			return nil, err
+		}
+		if _, statErr := os.Stat(keyFile); statErr != nil {
+			return nil, err
+		}
+	}
+
+	caKeyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, helpers.CustomError{Message: "Error reading CA private key: " + err.Error()}
+	}
+	return pki.decodePrivateKeyPEM(caKeyPEM, filepath.Base(keyFile))
+}
+
+// createTokenKey() : generates the CA's key pair on the token
+func (c CertificateStruct) createTokenKey(pki *CA) (crypto.Signer, error) {
+	if err := pki.env.PKCS11.Check(); err != nil {
+		return nil, helpers.CustomError{Message: err.Error(), Err: ErrToken}
+	}
+	return generateTokenKey(pki, pki.env.PKCS11.CAKeyLabel(c.CertificateName), c.KeyAlgorithm, pki.options.KeySize)
+}
+
+// tokenPIN() : the token's user PIN, from the source set in the environment: env:VARIABLE, file:PATH, or Options.PIN
+func (pki *CA) tokenPIN() (string, error) {
+	source := pki.env.PKCS11.PINSource()
+	pin := ""
+
+	switch {
+	case strings.HasPrefix(source, environment.PINFromEnv):
+		variable := strings.TrimPrefix(source, environment.PINFromEnv)
+		if pin = os.Getenv(variable); pin == "" {
+			return "", helpers.CustomError{Message: "The token PIN is to be read from the " + helpers.Red(variable) + " environment variable, which is not set", Err: ErrToken}
+		}
+	case strings.HasPrefix(source, environment.PINFromFile):
+		content, err := os.ReadFile(strings.TrimPrefix(source, environment.PINFromFile))
+		if err != nil {
+			return "", helpers.CustomError{Message: "Unable to read the token PIN: " + err.Error(), Err: ErrToken}
+		}
+		pin = strings.TrimRight(string(content), "\r\n")
+	case source == environment.PINFromPrompt:
+		if pki.options.PIN == nil {
+			return "", helpers.CustomError{Message: "The token PIN is to be prompted for, and no PIN was provided", Err: ErrToken}
+		}
+		content, err := pki.options.PIN()
+		if err != nil {
+			if errors.Is(err, ErrToken) {
+				return "", err
+			}
+			return "", helpers.CustomError{Message: err.Error(), Err: ErrToken}
+		}
+		pin = string(content)
+	default:
+		return "", helpers.CustomError{Message: "Invalid token PIN source: " + helpers.Red(source) + " (valid sources are env:VARIABLE, file:PATH and prompt)", Err: ErrToken}
+	}
+	if pin == "" {
+		return "", helpers.CustomError{Message: "The token PIN cannot be empty", Err: ErrToken}
+	}
+	return pin, nil
+}
+
+// tokenPINPrompt() : the command line's Options.PIN, prompting for the PIN
+func tokenPINPrompt() ([]byte, error) {
+	if tokenPINCache != nil {
+		return tokenPINCache, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, helpers.CustomError{Message: "The token PIN cannot be prompted for: set the environment's PKCS11 PIN source to env:" + tokenPINEnvVar +
+			" or file:PATH", Err: ErrToken}
+	}
+	pin := helpers.GetPassword("Please provide the token PIN: ")
+	if pin == "" {
+		return nil, helpers.CustomError{Message: "The token PIN cannot be empty", Err: ErrToken}
+	}
+	tokenPINCache = []byte(pin)
+	return tokenPINCache, nil
+}
@@ -19,17 +19,56 @@ import (
 var CaVerifyVerbose = false
 var CaVerifyComments = false
 
+// verifyRecord : a verified certificate, as emitted by the machine-readable (--output) formats
+type verifyRecord struct {
+	CertificateFile string      `json:"CertificateFile"`
+	X509            X509Details `json:"X509"`
+	Validation      Validation  `json:"Validation"`
+	PEM             string      `json:"PEM,omitempty"`
+	Comments        []string    `json:"Comments,omitempty"`
+}
+
+// Verify : displays and validates the certificates
+// Returns an error when at least one of them fails the validation
 func Verify(certFilePaths []string) error {
-	for _, path := range certFilePaths {
-		if err := verifyCert(path); err != nil {
+	invalid := 0
+
+	if helpers.StructuredOutput() {
+		records := []verifyRecord{}
+		for _, path := range certFilePaths {
+			record, err := newVerifyRecord(path)
+			if err != nil {
+				return err
+			}
+			if !record.Validation.Valid {
+				invalid++
+			}
+			records = append(records, record)
+		}
+		if err := helpers.PrintRecords(records); err != nil {
 			return err
 		}
+	} else {
+		for _, path := range certFilePaths {
+			valid, err := verifyCert(path)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				invalid++
+			}
+		}
+	}
+
+	if invalid > 0 {
+		return helpers.CustomError{Message: fmt.Sprintf("%d certificate(s) failed the validation", invalid)}
 	}
 	return nil
 }
 
-func verifyCert(certFilePath string) error {
-
+// loadCertificateToVerify : reads, decodes and parses the certificate file
+// The other certificates of the file, if any (a full chain, for instance), are returned as well
+func loadCertificateToVerify(certFilePath string) (string, []byte, *x509.Certificate, []*x509.Certificate, error) {
 	if !strings.HasSuffix(certFilePath, ".crt") {
 		certFilePath += ".crt"
 	}
@@ -37,22 +76,73 @@ func verifyCert(certFilePath string) error {
 	certPEMBlock, err := os.ReadFile(certFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return helpers.CustomError{Message: "You most likely did not provide a full path to the CRT file"}
+			return "", nil, nil, nil, helpers.CustomError{Message: "You most likely did not provide a full path to the CRT file"}
 		} else {
-			return err
+			return "", nil, nil, nil, err
 		}
 	}
 
 	// Decode the PEM block into a certificate
 	cert, _ := pem.Decode(certPEMBlock)
 	if cert == nil {
-		return fmt.Errorf("failed to decode certificate PEM block")
+		return "", nil, nil, nil, fmt.Errorf("failed to decode certificate PEM block")
 	}
 
 	// Parse the certificate
 	parsedCert, err := x509.ParseCertificate(cert.Bytes)
 	if err != nil {
-		return err
+		return "", nil, nil, nil, err
+	}
+	extra, _ := parseCertificates(certPEMBlock)
+	if len(extra) > 0 {
+		extra = extra[1:]
+	}
+	return certFilePath, certPEMBlock, parsedCert, extra, nil
+}
+
+func newVerifyRecord(certFilePath string) (verifyRecord, error) {
+	certFilePath, certPEMBlock, parsedCert, extra, err := loadCertificateToVerify(certFilePath)
+	if err != nil {
+		return verifyRecord{}, err
+	}
+	record := verifyRecord{CertificateFile: certFilePath, X509: newX509Details(parsedCert)}
+	if record.Validation, err = commandValidation(parsedCert, extra); err != nil {
+		return verifyRecord{}, err
+	}
+	if CaVerifyVerbose {
+		record.PEM = string(certPEMBlock)
+	}
+	if CaVerifyComments {
+		if record.Comments, err = certificateComments(certFilePath); err != nil {
+			return verifyRecord{}, err
+		}
+	}
+	return record, nil
+}
+
+// certificateComments : the comments of the certificate's config file
+func certificateComments(certFilePath string) ([]string, error) {
+	var c CertificateStruct
+	var err error
+	var e environment.EnvironmentStruct
+
+	if e, err = environment.LoadEnvironmentFile(); err != nil {
+		return nil, err
+	}
+	cfgfileName := filepath.Base(certFilePath)
+	baseName := cfgfileName[:len(cfgfileName)-len(filepath.Ext(cfgfileName))] + ".json"
+	if c, err = LoadCertificateConfFile(filepath.Join(e.CertificatesConfigDir, baseName)); err != nil {
+		return nil, err
+	}
+	return c.Comments, nil
+}
+
+// verifyCert : prints the certificate's information, followed by the validation checks
+// Returns whether the certificate passed the validation
+func verifyCert(certFilePath string) (bool, error) {
+	certFilePath, certPEMBlock, parsedCert, extra, err := loadCertificateToVerify(certFilePath)
+	if err != nil {
+		return false, err
 	}
 
 	// Print certificate information
@@ -115,25 +205,23 @@ func verifyCert(certFilePath string) error {
 
 	// TODO: fix this
 	if CaVerifyComments {
-		var c CertificateStruct
-		var err error
-		var e environment.EnvironmentStruct
-
-		if e, err = environment.LoadEnvironmentFile(); err != nil {
-			return err
-		}
-		cfgfileName := filepath.Base(certFilePath)
-		baseName := cfgfileName[:len(cfgfileName)-len(filepath.Ext(cfgfileName))] + ".json"
-		if c, err = LoadCertificateConfFile(filepath.Join(e.CertificatesConfigDir, baseName)); err != nil {
-			return err
+		comments, err := certificateComments(certFilePath)
+		if err != nil {
+			return false, err
 		}
-		if len(c.Comments) > 0 {
+		if len(comments) > 0 {
 			fmt.Println("\n\nComments (part of the config, but NOT of the certificate itself)\n----------------------------------------------------------------")
-			for _, cm := range c.Comments {
+			for _, cm := range comments {
 				fmt.Printf("\t• %s\n", cm)
 			}
 			fmt.Println()
 		}
 	}
-	return nil
+
+	result, err := commandValidation(parsedCert, extra)
+	if err != nil {
+		return false, err
+	}
+	printValidation(result)
+	return result.Valid, nil
 }
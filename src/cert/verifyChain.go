@@ -0,0 +1,254 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/verifyChain.go
+// Original timestamp: 2026/10/18 17:55
+
+// Validates a certificate: its chain of trust up to the environment's CA (or a --roots bundle), its validity period,
+// its hostname and its status in the issuer's index.txt
+
+package cert
+
+import (
+	"bytes"
+	"certificateManager/environment"
+	"certificateManager/helpers"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var CaVerifyRoots = ""
+var CaVerifyHostname = ""
+var CaVerifyAt = ""
+
+// Validation : the outcome of the checks; the certificate is valid when there are no failures
+type Validation struct {
+	Valid       bool      `json:"Valid"`
+	CheckedAt   time.Time `json:"CheckedAt"`
+	Chain       []string  `json:"Chain"`
+	IndexStatus string    `json:"IndexStatus"`
+	Failures    []string  `json:"Failures"`
+}
+
+// parseVerifyTime() : the time at which the certificate is validated; now, unless --at is given
+func parseVerifyTime(at string) (time.Time, error) {
+	if at == "" {
+		return time.Now(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "2006/01/02 15:04:05", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, at, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, helpers.CustomError{Message: "Invalid time: " + helpers.Red(at) + " (examples: 2026-12-31, 2026-12-31 23:59:59, 2026-12-31T23:59:59Z)"}
+}
+
+// commandValidation() : validates the certificate as per the --roots, --hostname and --at flags
+// The environment is optional when a --roots bundle is given: the certificate might not come from our PKI
+func commandValidation(crt *x509.Certificate, extra []*x509.Certificate) (Validation, error) {
+	var env *environment.EnvironmentStruct
+	var err error
+	opts := VerifyOptions{Intermediates: extra, Hostname: CaVerifyHostname}
+
+	if opts.At, err = parseVerifyTime(CaVerifyAt); err != nil {
+		return Validation{}, err
+	}
+	if CaVerifyRoots != "" {
+		bundle, err := os.ReadFile(CaVerifyRoots)
+		if err != nil {
+			return Validation{}, err
+		}
+		if opts.Roots, err = parseCertificates(bundle); err != nil {
+			return Validation{}, helpers.CustomError{Message: CaVerifyRoots + ": " + err.Error()}
+		}
+	}
+
+	e, envErr := environment.LoadEnvironmentFile()
+	if envErr == nil {
+		env = &e
+	} else if CaVerifyRoots == "" {
+		return Validation{}, helpers.CustomError{Message: "Unable to load the environment's CA (use --roots to provide one): " + envErr.Error()}
+	}
+	return validateCertificate(env, crt, opts)
+}
+
+// loadTrustAnchors() : the root and intermediate certificate pools, from the given roots or from the environment
+// The given roots replace the environment's root CA, but its intermediate CAs are kept, to build the chain
+// Returns the CA certificates of the environment as well, keyed by their directory, for the index.txt lookup
+func loadTrustAnchors(env *environment.EnvironmentStruct, bundle []*x509.Certificate) (*x509.CertPool, *x509.CertPool, map[string]*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	var caCerts map[string]*x509.Certificate
+
+	if env != nil {
+		var err error
+		if caCerts, err = caCertificates(*env); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if env == nil && len(bundle) == 0 {
+		return nil, nil, nil, helpers.CustomError{Message: "No trusted root: neither an environment nor a bundle of roots was given", Err: ErrInvalidEnvironment}
+	}
+	for _, crt := range bundle {
+		roots.AddCert(crt)
+	}
+	for dir, crt := range caCerts {
+		if dir != env.RootCAdir {
+			intermediates.AddCert(crt)
+		} else if len(bundle) == 0 {
+			roots.AddCert(crt)
+		}
+	}
+	return roots, intermediates, caCerts, nil
+}
+
+// validateCertificate() : runs all checks against the certificate; every failed check adds its reason to the result
+// The intermediates of the options (the other certificates of a full chain file, for instance) are added to the environment's
+func validateCertificate(env *environment.EnvironmentStruct, crt *x509.Certificate, opts VerifyOptions) (Validation, error) {
+	var result Validation
+	var err error
+
+	result.CheckedAt = opts.At
+	if result.CheckedAt.IsZero() {
+		result.CheckedAt = time.Now()
+	}
+	roots, intermediates, caCerts, err := loadTrustAnchors(env, opts.Roots)
+	if err != nil {
+		return result, err
+	}
+	for _, ic := range opts.Intermediates {
+		intermediates.AddCert(ic)
+	}
+
+	// 1. Validity period of the certificate itself
+	if result.CheckedAt.Before(crt.NotBefore) {
+		result.Failures = append(result.Failures, fmt.Sprintf("the certificate is not yet valid: its validity period starts on %s",
+			crt.NotBefore.Format("2006/01/02 15:04:05")))
+	}
+	if result.CheckedAt.After(crt.NotAfter) {
+		result.Failures = append(result.Failures, fmt.Sprintf("the certificate has expired on %s", crt.NotAfter.Format("2006/01/02 15:04:05")))
+	}
+
+	// 2. Chain of trust; a certificate checked against a host name must allow server authentication (or carry no
+	// Extended key usage at all), as TLS clients require; otherwise, any extended key usage will do
+	keyUsages := []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	if opts.Hostname != "" {
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	chains, err := crt.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: result.CheckedAt, KeyUsages: keyUsages})
+	if err != nil {
+		if reason := chainFailureReason(crt, err, opts.Hostname); reason != "" {
+			result.Failures = append(result.Failures, reason)
+		}
+	} else {
+		for _, c := range chains[0] {
+			result.Chain = append(result.Chain, c.Subject.String())
+		}
+	}
+
+	// 3. Hostname
+	if opts.Hostname != "" {
+		if err = crt.VerifyHostname(opts.Hostname); err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("the certificate is not valid for %s: %s", opts.Hostname, certificateHostnames(crt)))
+		}
+	}
+
+	// 4. Status in the issuer's index.txt
+	result.IndexStatus = "not found"
+	for dir, caCert := range caCerts {
+		if !bytes.Equal(caCert.RawSubject, crt.RawIssuer) {
+			continue
+		}
+		entries, err := readIndexFile(dir)
+		if err != nil {
+			return result, helpers.CustomError{Message: "Unable to load/parse the index.txt database: " + err.Error()}
+		}
+		for _, entry := range entries {
+			if serial, err := entry.SerialNumber(); err != nil || serial.Cmp(crt.SerialNumber) != 0 {
+				continue
+			}
+			result.IndexStatus = indexStatusName(entry.Status)
+			if entry.Status == "R" {
+				reason := fmt.Sprintf("the certificate has been revoked on %s", entry.RevocationDate.Format("2006/01/02 15:04:05"))
+				if entry.Reason != "" {
+					reason += " (" + entry.Reason + ")"
+				}
+				result.Failures = append(result.Failures, reason)
+			}
+		}
+	}
+
+	result.Valid = len(result.Failures) == 0
+	return result, nil
+}
+
+// chainFailureReason() : explains why the chain could not be built
+// The expiry of the certificate itself is already reported, so it is not repeated here
+func chainFailureReason(crt *x509.Certificate, err error, hostname string) string {
+	var invalid x509.CertificateInvalidError
+	var unknown x509.UnknownAuthorityError
+
+	switch {
+	case errors.As(err, &invalid):
+		switch invalid.Reason {
+		case x509.Expired:
+			if invalid.Cert == crt {
+				return ""
+			}
+			return fmt.Sprintf("the issuing CA %s is expired or not yet valid (%s to %s)", invalid.Cert.Subject.CommonName,
+				invalid.Cert.NotBefore.Format("2006/01/02 15:04:05"), invalid.Cert.NotAfter.Format("2006/01/02 15:04:05"))
+		case x509.NotAuthorizedToSign:
+			return fmt.Sprintf("the issuer %s is not authorized to sign certificates", invalid.Cert.Subject.CommonName)
+		case x509.IncompatibleUsage:
+			if hostname != "" && len(crt.ExtKeyUsage) > 0 && !slices.Contains(crt.ExtKeyUsage, x509.ExtKeyUsageServerAuth) &&
+				!slices.Contains(crt.ExtKeyUsage, x509.ExtKeyUsageAny) {
+				return fmt.Sprintf("the certificate cannot be used by a server for %s: its extended key usage does not include server auth", hostname)
+			}
+			return "the certificate's extended key usage is not allowed by its issuing CA"
+		case x509.TooManyIntermediates:
+			return "the chain is too long for the CA's path length constraint"
+		}
+		return "invalid chain: " + invalid.Error()
+	case errors.As(err, &unknown):
+		return fmt.Sprintf("the certificate is not signed by a trusted CA (issuer: %s)", crt.Issuer.String())
+	}
+	return "invalid chain: " + err.Error()
+}
+
+// certificateHostnames() : the names the certificate is valid for, to explain a hostname mismatch
+func certificateHostnames(crt *x509.Certificate) string {
+	var names []string
+	names = append(names, crt.DNSNames...)
+	for _, ip := range crt.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return "it has no DNS or IP subject alternative name"
+	}
+	return "it is valid for " + strings.Join(names, ", ")
+}
+
+// printValidation() : the text output of the checks
+func printValidation(result Validation) {
+	fmt.Printf("\n   Validation (at %s)\n   ----------\n", result.CheckedAt.Format("2006/01/02 15:04:05"))
+	if len(result.Chain) > 0 {
+		fmt.Println("   Chain:")
+		for _, subject := range result.Chain {
+			fmt.Printf("\t• %s\n", subject)
+		}
+	}
+	fmt.Printf("   index.txt status: %s\n", result.IndexStatus)
+	if result.Valid {
+		fmt.Printf("   Result: %s\n", helpers.Green("valid"))
+		return
+	}
+	fmt.Printf("   Result: %s\n", helpers.Red("invalid"))
+	for _, failure := range result.Failures {
+		fmt.Printf("\t• %s\n", helpers.Red(failure))
+	}
+}
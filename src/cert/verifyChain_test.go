@@ -0,0 +1,156 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/verifyChain_test.go
+// Original timestamp: 2026/10/19 09:20
+
+package cert
+
+import (
+	"certificateManager/environment"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate() : a certificate signed by the parent (self-signed when it is nil), and its key
+func testCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool, extKeyUsage []x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: serial, Subject: pkix.Name{CommonName: cn}, NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(24 * time.Hour), IsCA: isCA, BasicConstraintsValid: true, ExtKeyUsage: extKeyUsage,
+		KeyUsage: x509.KeyUsageDigitalSignature}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.DNSNames = []string{cn}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt, key
+}
+
+// testVerifyEnvironment() : an environment with a root CA, and an intermediate CA signed by it
+func testVerifyEnvironment(t *testing.T) (*environment.EnvironmentStruct, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	root := t.TempDir()
+	env := &environment.EnvironmentStruct{CertificateRootDir: root, RootCAdir: filepath.Join(root, "rootCA"),
+		ServerCertsDir: filepath.Join(root, "servers"), CertificatesConfigDir: filepath.Join(root, "conf")}
+	rootCert, rootKey := testCertificate(t, "Root CA", nil, nil, true, nil)
+	interCert, interKey := testCertificate(t, "Intermediate CA", rootCert, rootKey, true, nil)
+
+	writeCert := func(dir string, name string, crt *x509.Certificate) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCert(env.RootCAdir, "rootca", rootCert)
+	writeCert(filepath.Join(intermediatesDir(*env), "inter"), "inter", interCert)
+	return env, interCert, interKey
+}
+
+// A host name requires the server auth extended key usage; without one, any extended key usage is accepted
+func TestValidateCertificateKeyUsage(t *testing.T) {
+	env, interCert, interKey := testVerifyEnvironment(t)
+
+	tests := []struct {
+		name        string
+		extKeyUsage []x509.ExtKeyUsage
+		hostname    string
+		want        string
+	}{
+		{"server, with a host name", []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, "www.example.com", ""},
+		{"no extended key usage, with a host name", nil, "www.example.com", ""},
+		{"client, with a host name", []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, "www.example.com", "does not include server auth"},
+		{"client, without a host name", []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, "", ""},
+		{"code signing, without a host name", []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, "", ""},
+		{"server, with another host name", []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, "api.example.com", "is not valid for api.example.com"},
+	}
+	for _, tt := range tests {
+		leaf, _ := testCertificate(t, "www.example.com", interCert, interKey, false, tt.extKeyUsage)
+		result, err := validateCertificate(env, leaf, VerifyOptions{Hostname: tt.hostname})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		failures := strings.Join(result.Failures, "; ")
+		if tt.want == "" && !result.Valid {
+			t.Errorf("%s: %s", tt.name, failures)
+		}
+		if tt.want != "" && (result.Valid || !strings.Contains(failures, tt.want)) {
+			t.Errorf("%s: got %q, want a failure containing %q", tt.name, failures, tt.want)
+		}
+	}
+}
+
+// --roots replaces the environment's root CA, but the chain is still built through its intermediate CAs
+func TestValidateCertificateRoots(t *testing.T) {
+	env, interCert, interKey := testVerifyEnvironment(t)
+	leaf, _ := testCertificate(t, "www.example.com", interCert, interKey, false, nil)
+	rootCert, err := readCertificateFile(filepath.Join(env.RootCAdir, "rootca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoot, _ := testCertificate(t, "Other Root CA", nil, nil, true, nil)
+
+	result, err := validateCertificate(env, leaf, VerifyOptions{Roots: []*x509.Certificate{rootCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || len(result.Chain) != 3 {
+		t.Errorf("with the environment's intermediates: %v, chain %q", result.Failures, result.Chain)
+	}
+
+	// The environment's root CA is no longer trusted
+	if result, err = validateCertificate(env, leaf, VerifyOptions{Roots: []*x509.Certificate{otherRoot}}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid {
+		t.Error("the environment's root CA is still trusted with --roots")
+	}
+
+	// Without an environment, the intermediate must come with the certificate
+	if result, err = validateCertificate(nil, leaf, VerifyOptions{Roots: []*x509.Certificate{rootCert}}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid {
+		t.Error("the chain was built without its intermediate CA")
+	}
+	result, err = validateCertificate(nil, leaf, VerifyOptions{Roots: []*x509.Certificate{rootCert}, Intermediates: []*x509.Certificate{interCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid {
+		t.Errorf("with the intermediate CA: %v", result.Failures)
+	}
+
+	if _, err = validateCertificate(nil, leaf, VerifyOptions{}); err == nil {
+		t.Error("no trusted root, yet no error")
+	}
+}
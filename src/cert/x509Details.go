@@ -0,0 +1,96 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cert/X509Details.go
+// Original timestamp: 2026/10/18 17:20
+
+// The parsed x509 details of a certificate, as emitted by the machine-readable (--output) formats
+
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// X509Details : the certificate itself, as emitted by the machine-readable (--output) formats
+type X509Details struct {
+	Subject               string    `json:"Subject"`
+	Issuer                string    `json:"Issuer"`
+	SerialNumber          string    `json:"SerialNumber"`
+	NotBefore             time.Time `json:"NotBefore"`
+	NotAfter              time.Time `json:"NotAfter"`
+	IsCA                  bool      `json:"IsCA"`
+	PublicKeyAlgorithm    string    `json:"PublicKeyAlgorithm"`
+	SignatureAlgorithm    string    `json:"SignatureAlgorithm"`
+	KeyUsage              []string  `json:"KeyUsage"`
+	ExtKeyUsage           []string  `json:"ExtKeyUsage"`
+	DNSNames              []string  `json:"DNSNames"`
+	IPAddresses           []string  `json:"IPAddresses"`
+	EmailAddresses        []string  `json:"EmailAddresses"`
+	URIs                  []string  `json:"URIs"`
+	OCSPServers           []string  `json:"OCSPServers"`
+	CRLDistributionPoints []string  `json:"CRLDistributionPoints"`
+	SHA256Fingerprint     string    `json:"SHA256Fingerprint"`
+}
+
+// extKeyUsageNames : the names of the extended key usages, as displayed by OpenSSL
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server auth",
+	x509.ExtKeyUsageClientAuth:      "client auth",
+	x509.ExtKeyUsageCodeSigning:     "code signing",
+	x509.ExtKeyUsageEmailProtection: "email protection",
+	x509.ExtKeyUsageTimeStamping:    "time stamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocsp signing",
+}
+
+func newX509Details(crt *x509.Certificate) X509Details {
+	details := X509Details{
+		Subject:               crt.Subject.String(),
+		Issuer:                crt.Issuer.String(),
+		SerialNumber:          formatSerial(crt.SerialNumber),
+		NotBefore:             crt.NotBefore,
+		NotAfter:              crt.NotAfter,
+		IsCA:                  crt.IsCA,
+		PublicKeyAlgorithm:    publicKeyDescription(crt.PublicKey),
+		SignatureAlgorithm:    crt.SignatureAlgorithm.String(),
+		KeyUsage:              getStringsFromKeyUsage(crt.KeyUsage),
+		DNSNames:              crt.DNSNames,
+		EmailAddresses:        crt.EmailAddresses,
+		OCSPServers:           crt.OCSPServer,
+		CRLDistributionPoints: crt.CRLDistributionPoints,
+		SHA256Fingerprint:     fmt.Sprintf("%X", sha256.Sum256(crt.Raw)),
+	}
+	for _, eku := range crt.ExtKeyUsage {
+		if name, ok := extKeyUsageNames[eku]; ok {
+			details.ExtKeyUsage = append(details.ExtKeyUsage, name)
+		} else {
+			details.ExtKeyUsage = append(details.ExtKeyUsage, fmt.Sprintf("unknown (%d)", eku))
+		}
+	}
+	for _, ip := range crt.IPAddresses {
+		details.IPAddresses = append(details.IPAddresses, ip.String())
+	}
+	for _, uri := range crt.URIs {
+		details.URIs = append(details.URIs, uri.String())
+	}
+	return details
+}
+
+// publicKeyDescription : the key algorithm, with its size or curve
+func publicKeyDescription(pub interface{}) string {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return "unknown"
+}
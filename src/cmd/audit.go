@@ -0,0 +1,39 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cmd/audit.go
+// Original timestamp: 2026/10/18 23:40
+
+package cmd
+
+import (
+	"certificateManager/cert"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var auditCmd = &cobra.Command{
+	Use:     "audit",
+	Example: "cm audit verify",
+	Short:   "Audit log sub-command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("You need to specify one of the following subcommand: verify")
+		os.Exit(0)
+	},
+}
+
+// Check the hash chain of the environment's audit log
+var auditVerifyCmd = &cobra.Command{
+	Use:     "verify",
+	Example: "cm audit verify",
+	Short:   "Verifies the environment's audit log",
+	Long: `Every record of the audit log (audit.log, in the root CA directory) holds its own hash and the previous record's:
+a modified, inserted or removed record breaks the chain. Removing the last records cannot be detected this way, though:
+compare the last record hash with a copy kept elsewhere. The command exits with code 2 if the verification fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cert.VerifyAudit(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
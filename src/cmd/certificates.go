@@ -9,15 +9,16 @@ import (
 	"certificateManager/cert"
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"os"
 )
 
 var certCmd = &cobra.Command{
 	Use:     "cert",
-	Example: "cm cert { {create | delete | verify } } certificate_name | list }",
+	Example: "cm cert { {create | export | renew | revoke | sign | verify } } certificate_name | list }",
 	Short:   "Certificate sub-command",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("You need to specify one of the following subcommand: add | delete | verify | list")
+		fmt.Println("You need to specify one of the following subcommand: create | export | renew | revoke | sign | verify | list")
 		os.Exit(0)
 	},
 }
@@ -36,13 +37,37 @@ var certlistCmd = &cobra.Command{
 	},
 }
 
+// Report the issued certificates' expiration dates; the exit code is 1 if any is expired or expires soon
+var certExpiringCmd = &cobra.Command{
+	Use:     "expiring",
+	Aliases: []string{"expiry"},
+	Example: "cm cert expiring [--within 30d]",
+	Short:   "Lists all issued certificates by expiration date",
+	Long: `Reads the CA, OCSP and server certificates themselves (not their config files).
+Expired certificates are shown in red, and those expiring within the threshold in yellow.
+Revoked certificates, including those superseded by a renewal, are only listed with --include-revoked.
+The command exits with code 1 if any certificate is expired or expires within the threshold.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flagged, err := cert.ExpiryReport()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if flagged > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
 // Verify a given certificate
 var certVerifyCmd = &cobra.Command{
 	Use: "verify",
 	//Aliases: []string{"ls"},
-	Example: "cm cert verify FILENAME",
+	Example: "cm cert verify FILENAME [--hostname HOST] [--at TIME] [--roots BUNDLE]",
 	Short:   "Verifies a certificate, as per the provided filename",
-	Long:    "Please note: the certificate filename does not need to be within the current PKI structure.",
+	Long: `Displays the certificate, then validates its chain against the environment's CA (or the --roots bundle),
+its validity period, its hostname (with --hostname) and its status in the issuer's index.txt.
+Please note: the certificate filename does not need to be within the current PKI structure.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := cert.Verify(args); err != nil {
 			fmt.Println(err)
@@ -55,13 +80,18 @@ var certVerifyCmd = &cobra.Command{
 var certCreateCmd = &cobra.Command{
 	Use: "create",
 	//Aliases: []string{"ls"},
-	Example: "cm cert create [CERTICATE_CONFIG_FILE]",
+	Example: "cm cert create [CERTICATE_CONFIG_FILE] [--cn NAME --dns NAME --ip ADDRESS -p PROFILE ...]",
 	Short:   "Creates a certificate, specifying (or not) the config file to use",
+	Long: `The certificate fields can be set with flags, on their own or layered over the config file.
+Without a config file nor flags, the values are prompted for; otherwise, missing required values are an error.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		certname := ""
 		if len(args) != 0 {
 			certname = args[0]
 		}
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			cert.CertFlagsSet[f.Name] = true
+		})
 		if err := cert.Create(certname); err != nil {
 			fmt.Println(err)
 			os.Exit(2)
@@ -69,6 +99,69 @@ var certCreateCmd = &cobra.Command{
 	},
 }
 
+// Sign an externally generated CSR
+var certSignCmd = &cobra.Command{
+	Use:     "sign",
+	Example: "cm cert sign --csr FILE.csr [CERTICATE_CONFIG_FILE]",
+	Short:   "Signs an external CSR, optionally merged with a certificate config file (profile)",
+	Long: `The CSR's signature is checked, and its subject and SANs are merged with the config file's values, the latter taking precedence.
+The certificate name defaults to the CSR's common name, unless -n is used. The private key never touches the PKI.
+A name already in use is refused, unless --force is given to replace that certificate, which is then superseded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := ""
+		if len(args) != 0 {
+			profile = args[0]
+		}
+		if err := cert.SignCSR(profile); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Reissue a certificate from its config file, with a new serial number and validity period
+var certRenewCmd = &cobra.Command{
+	Use:     "renew",
+	Example: "cm cert renew CERTICATE_CONFIG_FILE [-k]",
+	Short:   "Renews a certificate, specifying the config file to use",
+	Long: `The private key is reused, unless the -k flag is set. The previous certificate is flagged as superseded in index.txt.
+Java files (.p12, .jks) are regenerated if the previous certificate had them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("You need to specify the certificate to renew")
+			os.Exit(2)
+		}
+		if err := cert.Renew(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Export a certificate, its chain and its key in the formats expected by the various consumers
+var certExportCmd = &cobra.Command{
+	Use:     "export",
+	Example: "cm cert export CERTICATE_CONFIG_FILE [-f fullchain,haproxy,der,pkcs7,pkcs8|all] [--k8s] [--cert-manager] [-d DIRECTORY]",
+	Short:   "Exports a certificate, its chain and its key in various formats",
+	Long: `Formats: fullchain (PEM), haproxy (certificate, chain and key in a single PEM file), der (certificate only),
+pkcs7 (certificate and chain, .p7b) and pkcs8 (private key). Files holding the private key are only readable by their owner.
+--k8s writes a kubernetes.io/tls Secret manifest (tls.crt, tls.key and ca.crt), and --cert-manager the Secret and
+cert-manager CA Issuer of a root or intermediate CA; without -f, only the manifests are written.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("You need to specify the certificate to export")
+			os.Exit(2)
+		}
+		if (cert.CertExportK8s || cert.CertExportCertManager) && !cmd.Flags().Changed("format") {
+			cert.CertExportFormats = nil
+		}
+		if err := cert.Export(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
 var certRevokeCmd = &cobra.Command{
 	Use:     "revoke",
 	Aliases: []string{"rm", "remove"},
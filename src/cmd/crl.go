@@ -0,0 +1,42 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cmd/crl.go
+// Original timestamp: 2026/10/18 10:41
+
+package cmd
+
+import (
+	"certificateManager/cert"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var crlCmd = &cobra.Command{
+	Use:     "crl",
+	Example: "cm crl generate [CA_NAME]",
+	Short:   "Certificate revocation list (CRL) sub-command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("You need to specify one of the following subcommand: generate")
+		os.Exit(0)
+	},
+}
+
+// Generate the CRL of a CA, from the revoked entries of its index.txt
+var crlGenerateCmd = &cobra.Command{
+	Use:     "generate",
+	Aliases: []string{"gen"},
+	Example: "cm crl generate [CA_NAME]",
+	Short:   "Generates the CRL of a CA (the root CA if no name is given)",
+	Long:    "The CRL is written in both DER (CA_NAME.crl) and PEM (CA_NAME.crl.pem) formats in the CA directory.",
+	Run: func(cmd *cobra.Command, args []string) {
+		caname := ""
+		if len(args) != 0 {
+			caname = args[0]
+		}
+		if err := cert.GenerateCRL(caname); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
@@ -16,7 +16,7 @@ var envCmd = &cobra.Command{
 	Use:   "env",
 	Short: "Environment sub-command",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Valid subcommands are: { list | add | remove }")
+		fmt.Println("Valid subcommands are: { list | add | remove | info | backup | restore }")
 	},
 }
 
@@ -94,3 +94,40 @@ var envInfoCmd = &cobra.Command{
 		}
 	},
 }
+
+var envBackupCmd = &cobra.Command{
+	Use:     "backup",
+	Example: "cm env backup [FILE[.json]] [-o ARCHIVE.tar.gz] [--encrypt | --passphrase-file FILE]",
+	Short:   "Backs up the environment FILE and its whole PKI in an archive",
+	Long: `The archive holds the environment file, the certificate root dir (CAs, server certificates, private keys,
+certificate config files) and a manifest with the SHA-256 checksum of each file. Keep it safe: it holds the private keys.
+With --encrypt (or --passphrase-file), the archive is encrypted with a passphrase (scrypt, AES-256-GCM).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fname := "defaultEnv.json"
+		if len(args) != 0 {
+			fname = args[0]
+		}
+		if err := environment.BackupEnvironment(fname); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+var envRestoreCmd = &cobra.Command{
+	Use:     "restore",
+	Example: "cm env restore ARCHIVE.tar.gz --root NEW_CERTIFICATE_ROOT_DIR [-n NEWNAME[.json]] [--passphrase-file FILE]",
+	Short:   "Restores an environment and its PKI from a backup archive",
+	Long: `The archive is checked against its manifest before anything is written. The PKI is recreated under the --root
+directory, which must be empty or missing, and the environment file is rewritten to point to it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("You need to specify the archive to restore")
+			os.Exit(2)
+		}
+		if err := environment.RestoreEnvironment(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
@@ -0,0 +1,60 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cmd/ocsp.go
+// Original timestamp: 2026/10/18 13:05
+
+package cmd
+
+import (
+	"certificateManager/cert"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var ocspCmd = &cobra.Command{
+	Use:     "ocsp",
+	Example: "cm ocsp { serve | signer } [CA_NAME]",
+	Short:   "OCSP responder sub-command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("You need to specify one of the following subcommand: serve | signer")
+		os.Exit(0)
+	},
+}
+
+// Run an OCSP responder for a CA, answering from its index.txt
+var ocspServeCmd = &cobra.Command{
+	Use:     "serve",
+	Example: "cm ocsp serve [CA_NAME] [-l :8080] [-d]",
+	Short:   "Runs an OCSP responder over HTTP for a CA (the root CA if no name is given)",
+	Long: `The responder answers from the CA's index.txt database, which is re-read on every request.
+Responses are signed by the CA, or by its delegated OCSP signing certificate with -d (see cm ocsp signer).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		caname := ""
+		if len(args) != 0 {
+			caname = args[0]
+		}
+		if err := cert.ServeOCSP(caname); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Issue the delegated OCSP signing certificate of a CA
+var ocspSignerCmd = &cobra.Command{
+	Use:     "signer",
+	Example: "cm ocsp signer [CA_NAME]",
+	Short:   "Creates the delegated OCSP signing certificate of a CA (the root CA if no name is given)",
+	Long:    "The certificate and its key are stored in the CA's ocsp/ directory, as CA_NAME-ocsp.crt and CA_NAME-ocsp.key.",
+	Run: func(cmd *cobra.Command, args []string) {
+		caname := ""
+		if len(args) != 0 {
+			caname = args[0]
+		}
+		if err := cert.CreateOCSPSigner(caname); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
@@ -9,6 +9,7 @@ import (
 	"certificateManager/helpers"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -16,6 +17,9 @@ var rootCmd = &cobra.Command{
 	Use:     "cm",
 	Short:   "Certificate / PKI management tool",
 	Version: helpers.White("1.24.00-0 (2024.03.01)"),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return helpers.SetOutputFormat(helpers.OutputFormat)
+	},
 }
 
 var clCmd = &cobra.Command{
@@ -41,21 +45,131 @@ func init() {
 	rootCmd.AddCommand(clCmd)
 	rootCmd.AddCommand(certCmd)
 	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(crlCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(ocspCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(sshCmd)
 
 	certCmd.AddCommand(certlistCmd)
 	certCmd.AddCommand(certVerifyCmd)
 	certCmd.AddCommand(certCreateCmd)
 	certCmd.AddCommand(certRevokeCmd)
+	certCmd.AddCommand(certRenewCmd)
+	certCmd.AddCommand(certExpiringCmd)
+	certCmd.AddCommand(certSignCmd)
+	certCmd.AddCommand(certExportCmd)
 
 	envCmd.AddCommand(envListCmd)
 	envCmd.AddCommand(envRmCmd)
 	envCmd.AddCommand(envAddCmd)
 	envCmd.AddCommand(envInfoCmd)
+	envCmd.AddCommand(envBackupCmd)
+	envCmd.AddCommand(envRestoreCmd)
+
+	crlCmd.AddCommand(crlGenerateCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	ocspCmd.AddCommand(ocspServeCmd)
+	ocspCmd.AddCommand(ocspSignerCmd)
+	serveCmd.AddCommand(serveTokenCmd)
+
+	sshCmd.AddCommand(sshCACmd)
+	sshCmd.AddCommand(sshSignUserCmd)
+	sshCmd.AddCommand(sshSignHostCmd)
+	sshCACmd.AddCommand(sshCACreateCmd)
+	sshCACmd.AddCommand(sshCAExportCmd)
 
 	rootCmd.PersistentFlags().StringVarP(&environment.EnvConfigFile, "env", "e", "defaultEnv.json", "Default environment configuration file; this is a per-user setting.")
+	rootCmd.PersistentFlags().StringVar(&helpers.OutputFormat, "output", "text", "Output format of the list, verify, expiring and env commands: text, json, yaml or csv.")
+	rootCmd.PersistentFlags().DurationVar(&helpers.LockTimeout, "lock-timeout", 30*time.Second, "How long to wait for another cm process to release a CA directory lock.")
 	certCreateCmd.PersistentFlags().BoolVarP(&cert.CertJava, "java", "j", false, "Also create a Java Keystore (JKS).")
+	envBackupCmd.Flags().StringVarP(&environment.BackupOutput, "out", "o", "", "Archive file; defaults to ENVNAME.tar.gz.")
+	envBackupCmd.Flags().BoolVarP(&environment.BackupEncrypt, "encrypt", "x", false, "Encrypt the archive with a passphrase (prompted for).")
+	envBackupCmd.Flags().StringVar(&environment.BackupPassphraseFile, "passphrase-file", "", "File holding the archive passphrase; implies --encrypt.")
+	envRestoreCmd.Flags().StringVarP(&environment.RestoreRootDir, "root", "r", "", "New certificate root dir, where the PKI is restored.")
+	envRestoreCmd.Flags().StringVarP(&environment.RestoreEnvName, "name", "n", "", "Name of the restored environment file; defaults to the archived one.")
+	envRestoreCmd.Flags().StringVar(&environment.BackupPassphraseFile, "passphrase-file", "", "File holding the passphrase of an encrypted archive.")
+	rootCmd.PersistentFlags().StringVar(&cert.CertKeyPassphraseFile, "key-passphrase-file", "", "File holding the passphrase of the encrypted private keys (or set CM_KEY_PASSPHRASE).")
+	certCreateCmd.Flags().BoolVar(&cert.CertEncryptKey, "encrypt-key", false, "Encrypt the private key with a passphrase, whatever the environment's KeyEncryption policy.")
 	certRevokeCmd.PersistentFlags().BoolVarP(&cert.CertRemoveFiles, "remove", "r", false, "Remove all artefacts from PKI.")
+	certRevokeCmd.PersistentFlags().StringVarP(&cert.CertRevokeReason, "reason", "R", "", "Revocation reason (keyCompromise, CACompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold, privilegeWithdrawn, aACompromise, unspecified).")
 	certVerifyCmd.Flags().BoolVarP(&cert.CaVerifyVerbose, "verbose", "v", false, "Display the full output.")
 	certVerifyCmd.Flags().BoolVarP(&cert.CaVerifyComments, "comments", "c", false, "Display the comments (if any) at the end of the configuration file.")
+	certVerifyCmd.Flags().StringVarP(&cert.CaVerifyRoots, "roots", "r", "", "PEM bundle of trusted root CAs; defaults to the environment's CA.")
+	certVerifyCmd.Flags().StringVarP(&cert.CaVerifyHostname, "hostname", "H", "", "Also check that the certificate is valid for this hostname or IP address.")
+	certVerifyCmd.Flags().StringVarP(&cert.CaVerifyAt, "at", "t", "", "Validate the certificate at this time instead of now (2026-12-31, 2026-12-31 23:59:59, RFC3339).")
 	certCreateCmd.Flags().IntVarP(&cert.CertPKsize, "keysize", "b", 4096, "Certificate private key size in bits.")
+	certCreateCmd.Flags().StringVarP(&cert.CertKeyAlgorithm, "keyalgo", "a", "", "Private key algorithm (rsa, ecdsa-p256, ecdsa-p384, ed25519); overrides the config file.")
+	crlGenerateCmd.Flags().IntVarP(&cert.CrlNextUpdate, "nextupdate", "n", 30, "Number of days until the next CRL update.")
+	certSignCmd.Flags().StringVarP(&cert.CertCSRFile, "csr", "c", "", "CSR file to sign (PEM or DER).")
+	certSignCmd.Flags().StringVarP(&cert.CertCSRName, "name", "n", "", "Certificate name; defaults to the profile's, or the CSR's common name.")
+	certSignCmd.Flags().BoolVar(&cert.CertCSRForce, "force", false, "Replace the existing certificate of the same name (its config, certificate, CSR and private key), and supersede it.")
+	certSignCmd.Flags().StringVarP(&cert.CertIssuer, "issuer", "i", "", "Name of the CA (root or intermediate) signing the certificate; overrides the config file.")
+	certSignCmd.Flags().StringVarP(&cert.CertProfile, "profile", "p", "", "Certificate profile (server, client, peer, code-signing, smime, ocsp...), setting the key usages and default duration.")
+	certSignCmd.Flags().StringVarP(&cert.CertOCSPServer, "ocsp", "o", "", "OCSP responder URL to embed in the certificate (AIA extension); overrides the config file.")
+	certExpiringCmd.Flags().StringVarP(&cert.CertExpiryWithin, "within", "w", "30d", "Threshold for expiring certificates (30d, 4w, 72h...).")
+	certExpiringCmd.Flags().BoolVar(&cert.CertExpiryIncludeRevoked, "include-revoked", false, "Also list (and count) the revoked and superseded certificates.")
+	certExportCmd.Flags().StringSliceVarP(&cert.CertExportFormats, "format", "f", []string{"fullchain"}, "Export formats, comma-separated: fullchain, haproxy, der, pkcs7, pkcs8, k8s, cert-manager, or all (all but the Kubernetes manifests).")
+	certExportCmd.Flags().StringVarP(&cert.CertExportDir, "dir", "d", ".", "Directory where the exported files are written.")
+	certExportCmd.Flags().BoolVar(&cert.CertExportK8s, "k8s", false, "Write a Kubernetes kubernetes.io/tls Secret manifest (tls.crt, tls.key, ca.crt).")
+	certExportCmd.Flags().BoolVar(&cert.CertExportCertManager, "cert-manager", false, "Write the Secret and cert-manager CA Issuer manifest of a root or intermediate CA.")
+	certExportCmd.Flags().BoolVar(&cert.CertExportClusterIssuer, "cluster-issuer", false, "With --cert-manager, a ClusterIssuer instead of an Issuer; its secret goes to the cert-manager namespace.")
+	certExportCmd.Flags().StringVar(&cert.CertExportNamespace, "namespace", "", "Kubernetes namespace of the manifests.")
+	certExportCmd.Flags().StringArrayVar(&cert.CertExportLabels, "label", nil, "Kubernetes label of the manifests, as name=value; can be repeated.")
+	certExportCmd.Flags().StringVar(&cert.CertExportSecretName, "secret-name", "", "Name of the Kubernetes secret; defaults to NAME-tls, or NAME-ca with --cert-manager.")
+	certRenewCmd.Flags().BoolVarP(&cert.CertRotateKey, "newkey", "k", false, "Generate a new private key instead of reusing the current one.")
+	certRenewCmd.Flags().IntVarP(&cert.CertPKsize, "keysize", "b", 4096, "New private key size in bits (with -k).")
+	certRenewCmd.Flags().StringVarP(&cert.CertKeyAlgorithm, "keyalgo", "a", "", "New private key algorithm (with -k); overrides the config file.")
+	ocspServeCmd.Flags().StringVarP(&cert.OcspListen, "listen", "l", ":8080", "Address the OCSP responder listens on.")
+	ocspServeCmd.Flags().BoolVarP(&cert.OcspDelegated, "delegated", "d", false, "Sign the responses with the delegated OCSP signing certificate instead of the CA key.")
+	ocspServeCmd.Flags().IntVarP(&cert.OcspValidity, "validity", "n", 60, "Number of minutes until the next OCSP update.")
+	serveCmd.Flags().StringVarP(&cert.ServeListen, "listen", "l", "127.0.0.1:8443", "Address the API listens on; plain HTTP is only allowed on a loopback address, unless --insecure.")
+	serveCmd.Flags().StringVarP(&cert.ServeTokensFile, "tokens", "t", "", "JSON file holding the API tokens, and what each of them is allowed.")
+	serveCmd.Flags().StringVar(&cert.ServeTLSCert, "tls-cert", "", "Certificate (PEM, full chain) of the API, to serve over HTTPS.")
+	serveCmd.Flags().StringVar(&cert.ServeTLSKey, "tls-key", "", "Private key (PEM, unencrypted) of the API certificate.")
+	serveCmd.Flags().BoolVar(&cert.ServeInsecure, "insecure", false, "Serve over plain HTTP on a non-loopback address: the tokens and private keys travel in clear text.")
+	serveCmd.Flags().IntVarP(&cert.CertPKsize, "keysize", "b", 4096, "Private key size in bits of the RSA certificates issued.")
+	ocspSignerCmd.Flags().IntVarP(&cert.CertPKsize, "keysize", "b", 4096, "Certificate private key size in bits.")
+	ocspSignerCmd.Flags().StringVarP(&cert.CertKeyAlgorithm, "keyalgo", "a", "", "Private key algorithm (rsa, ecdsa-p256, ecdsa-p384, ed25519).")
+	certCreateCmd.Flags().StringVarP(&cert.CertProfile, "profile", "p", "", "Certificate profile (server, client, peer, code-signing, smime, ocsp, ca...), setting the key usages and default duration.")
+	certCreateCmd.Flags().StringVar(&cert.CertBatch, "batch", "", "Issue all certificates of a directory (.json config files) or of a YAML manifest.")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.CertificateName, "name", "", "Certificate name; defaults to the common name.")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.CommonName, "cn", "", "Common name (CN).")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.Country, "country", "", "Country (C).")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.Province, "province", "", "Province or state (ST).")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.Locality, "locality", "", "Locality (L).")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.Organization, "org", "", "Organization (O).")
+	certCreateCmd.Flags().StringVar(&cert.CertFlagValues.OrganizationalUnit, "ou", "", "Organizational unit (OU).")
+	certCreateCmd.Flags().BoolVar(&cert.CertFlagValues.IsCA, "ca", false, "Create a CA certificate.")
+	certCreateCmd.Flags().StringSliceVar(&cert.CertFlagValues.EmailAddresses, "email", nil, "Email addresses, comma-separated or repeated.")
+	certCreateCmd.Flags().IntVar(&cert.CertFlagValues.Duration, "duration", 0, "Certificate lifespan, in years.")
+	certCreateCmd.Flags().StringSliceVar(&cert.CertFlagValues.DNSNames, "dns", nil, "DNS names (SAN), comma-separated or repeated.")
+	certCreateCmd.Flags().StringSliceVar(&cert.CertFlagIPs, "ip", nil, "IP addresses (SAN), comma-separated or repeated.")
+	certCreateCmd.Flags().StringSliceVar(&cert.CertFlagValues.KeyUsage, "keyusage", nil, "Key usages (digital signature, key encipherment, cert sign...), comma-separated or repeated.")
+	certCreateCmd.Flags().StringSliceVar(&cert.CertFlagValues.ExtKeyUsage, "extkeyusage", nil, "Extended key usages (server auth, client auth...), comma-separated or repeated.")
+	certCreateCmd.Flags().StringArrayVar(&cert.CertFlagValues.Comments, "comment", nil, "Comment stored in the config file; can be repeated.")
+	certCreateCmd.Flags().StringVarP(&cert.CertOCSPServer, "ocsp", "o", "", "OCSP responder URL to embed in the certificate (AIA extension); overrides the config file.")
+	certCreateCmd.Flags().StringVarP(&cert.CertIssuer, "issuer", "i", "", "Name of the CA (root or intermediate) signing the certificate; overrides the config file.")
+	sshCACreateCmd.Flags().StringVarP(&cert.SSHKeyAlgorithm, "keyalgo", "a", "", "Private key algorithm of the SSH CA (rsa, ecdsa-p256, ecdsa-p384, ed25519).")
+	sshCACreateCmd.Flags().IntVarP(&cert.CertPKsize, "keysize", "b", 4096, "Private key size in bits of an RSA SSH CA.")
+	sshCAExportCmd.Flags().StringVar(&cert.SSHKnownHosts, "known-hosts", "", "Export as known_hosts @cert-authority lines, for the hosts matching these patterns (*.example.com,10.0.0.*).")
+	sshCAExportCmd.Flags().BoolVar(&cert.SSHTrustedUserCAKeys, "trusted-user-ca-keys", false, "Export as an sshd TrustedUserCAKeys file.")
+	sshCAExportCmd.Flags().StringVarP(&cert.SSHExportOutput, "out", "o", "", "File where the keys are written; defaults to the standard output.")
+	sshSignUserCmd.Flags().StringVar(&cert.SSHCAName, "ca", "", "Name of the SSH CA signing the key; may be omitted when the environment has only one.")
+	sshSignUserCmd.Flags().StringSliceVarP(&cert.SSHPrincipals, "principals", "n", nil, "Principals (user or host names), comma-separated or repeated.")
+	sshSignUserCmd.Flags().StringVarP(&cert.SSHKeyID, "identity", "I", "", "Key identity; defaults to the key comment (unless it holds a slash or a control character), or the first principal.")
+	sshSignUserCmd.Flags().DurationVarP(&cert.SSHValidity, "validity", "V", 0, "Certificate lifespan (24h, 720h...); a week if not set.")
+	sshSignUserCmd.Flags().StringArrayVar(&cert.SSHExtensions, "extension", nil, "Extension, as name or name=value; can be repeated.")
+	sshSignUserCmd.Flags().StringVarP(&cert.SSHSignOutput, "out", "o", "", "Certificate file; defaults to KEY-cert.pub.")
+	sshSignUserCmd.MarkFlagRequired("principals")
+	sshSignHostCmd.Flags().StringVar(&cert.SSHCAName, "ca", "", "Name of the SSH CA signing the key; may be omitted when the environment has only one.")
+	sshSignHostCmd.Flags().StringSliceVarP(&cert.SSHPrincipals, "principals", "n", nil, "Principals (user or host names), comma-separated or repeated.")
+	sshSignHostCmd.Flags().StringVarP(&cert.SSHKeyID, "identity", "I", "", "Key identity; defaults to the key comment (unless it holds a slash or a control character), or the first principal.")
+	sshSignHostCmd.Flags().DurationVarP(&cert.SSHValidity, "validity", "V", 0, "Certificate lifespan (24h, 720h...); a year if not set.")
+	sshSignHostCmd.Flags().StringArrayVar(&cert.SSHExtensions, "extension", nil, "Extension, as name or name=value; can be repeated.")
+	sshSignHostCmd.Flags().StringVarP(&cert.SSHSignOutput, "out", "o", "", "Certificate file; defaults to KEY-cert.pub.")
+	sshSignHostCmd.MarkFlagRequired("principals")
+	sshSignUserCmd.Flags().StringArrayVarP(&cert.SSHCriticalOptions, "critical-option", "O", nil, "Critical option (force-command=CMD, source-address=CIDR,..., verify-required); can be repeated.")
+	sshSignUserCmd.Flags().BoolVar(&cert.SSHNoDefaultExtensions, "no-default-extensions", false, "Do not add the default extensions (permit-pty, permit-port-forwarding...).")
 }
@@ -0,0 +1,52 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cmd/serve.go
+// Original timestamp: 2026/10/18 23:55
+
+package cmd
+
+import (
+	"certificateManager/cert"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// Run the HTTP API
+var serveCmd = &cobra.Command{
+	Use:     "serve",
+	Example: "cm serve --tokens tokens.json [-l 127.0.0.1:8443] [--tls-cert server.crt --tls-key server.key] [--insecure]",
+	Short:   "Runs an HTTP API to issue, list, fetch and revoke certificates, and download the CA bundles",
+	Long: `Clients authenticate with a bearer token (Authorization: Bearer TOKEN); the tokens file lists, for each token,
+the environments, domains and operations it is allowed. Use cm serve token NAME to generate a token.
+Endpoints, ENV being an environment name :
+POST   /v1/ENV/certificates        issue a certificate (JSON certificate config), or sign a CSR (application/pkcs10)
+GET    /v1/ENV/certificates        list the certificates
+GET    /v1/ENV/certificates/NAME   fetch a certificate and its chain (?format=pem for PEM)
+DELETE /v1/ENV/certificates/NAME   revoke a certificate (?reason=REASON)
+GET    /v1/ENV/ca                  download the CA bundle (?issuer=CANAME for the chain of a CA)
+Without --tls-cert and --tls-key, the API is served over plain HTTP, and only on a loopback address unless --insecure is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cert.ServeAPI(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Generate an API token
+var serveTokenCmd = &cobra.Command{
+	Use:     "token",
+	Example: "cm serve token NAME",
+	Short:   "Generates an API token, and the tokens file entry holding its hash",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("You need to provide the token name")
+			os.Exit(1)
+		}
+		if err := cert.NewAPIToken(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
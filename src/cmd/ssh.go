@@ -0,0 +1,100 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/cmd/ssh.go
+// Original timestamp: 2026/10/19 03:10
+
+package cmd
+
+import (
+	"certificateManager/cert"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var sshCmd = &cobra.Command{
+	Use:     "ssh",
+	Example: "cm ssh { ca | sign-user | sign-host }",
+	Short:   "SSH certificate authority sub-command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("You need to specify one of the following subcommand: ca | sign-user | sign-host")
+		os.Exit(0)
+	},
+}
+
+var sshCACmd = &cobra.Command{
+	Use:     "ca",
+	Example: "cm ssh ca { create | export }",
+	Short:   "SSH CA management sub-command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("You need to specify one of the following subcommand: create | export")
+		os.Exit(0)
+	},
+}
+
+// Create an SSH CA
+var sshCACreateCmd = &cobra.Command{
+	Use:     "create",
+	Example: "cm ssh ca create CA_NAME [-a ed25519]",
+	Short:   "Creates an SSH CA",
+	Long: `The CA is stored in RootCAdir/ssh/CA_NAME, with its own serial and index.txt.
+Its key is generated on the PKCS#11 token if the environment has one (ecdsa-p256 by default), or else stored in CA_NAME.key (ed25519 by default).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cert.CreateSSHCACommand(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Export the public keys of the SSH CAs
+var sshCAExportCmd = &cobra.Command{
+	Use:     "export",
+	Example: "cm ssh ca export [CA_NAME] { --known-hosts '*.example.com' | --trusted-user-ca-keys } [-o FILE]",
+	Short:   "Exports the public key of an SSH CA (all of them if no name is given)",
+	Long: `With --known-hosts, as @cert-authority lines for the clients' known_hosts files, trusting the host certificates of the hosts matching the patterns.
+With --trusted-user-ca-keys, as a TrustedUserCAKeys file for sshd, trusting the user certificates.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		caname := ""
+		if len(args) != 0 {
+			caname = args[0]
+		}
+		if err := cert.ExportSSHCA(caname); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Sign a user key
+var sshSignUserCmd = &cobra.Command{
+	Use:     "sign-user",
+	Example: "cm ssh sign-user id_ed25519.pub -n alice[,bob] [-I alice@laptop] [-V 24h] [-O force-command=/bin/date] [--ca CA_NAME]",
+	Short:   "Signs an OpenSSH public key into a user certificate",
+	Long: `The certificate is written next to the key (KEY.pub gives KEY-cert.pub), and is valid for a week unless -V says otherwise.
+The user certificates get the usual ssh-keygen extensions (permit-pty, permit-port-forwarding...), unless --no-default-extensions is set.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cert.SignSSHKeyCommand(args[0], false); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+// Sign a host key
+var sshSignHostCmd = &cobra.Command{
+	Use:     "sign-host",
+	Example: "cm ssh sign-host /etc/ssh/ssh_host_ed25519_key.pub -n host.example.com[,host] [-V 8760h] [--ca CA_NAME]",
+	Short:   "Signs an OpenSSH public key into a host certificate",
+	Long:    "The certificate is written next to the key (KEY.pub gives KEY-cert.pub), and is valid for a year unless -V says otherwise.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cert.SignSSHKeyCommand(args[0], true); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
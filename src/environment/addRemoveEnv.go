@@ -17,9 +17,16 @@ func RemoveEnvFile(envfile string) error {
 	if !strings.HasSuffix(envfile, ".json") {
 		envfile += ".json"
 	}
+	// The PKI itself is left alone, and the removal is recorded in its audit log, if there is one
+	env, envErr := ReadEnvironmentFile(envfile)
 	if err := os.Remove(filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", envfile)); err != nil {
 		return err
 	}
+	if _, err := os.Stat(env.RootCAdir); envErr == nil && err == nil {
+		if err = auditEnvironment(env, "env-remove", envfile, ""); err != nil {
+			return err
+		}
+	}
 
 	fmt.Printf("%s removed succesfully\n", envfile)
 	return nil
@@ -35,10 +42,23 @@ func AddEnvFile(envfile string) error {
 
 	if env, err = prompt4EnvironmentValues(); err != nil {
 		return err
-	} else {
-		err = env.SaveEnvironmentFile(envfile)
 	}
-	return err
+	if err = env.SaveEnvironmentFile(envfile); err != nil {
+		return err
+	}
+	return auditEnvironment(env, "env-add", envfile, "")
+}
+
+// auditEnvironment() : records the operation on the environment file in the audit log of its root CA directory
+func auditEnvironment(env EnvironmentStruct, operation string, envfile string, details string) error {
+	if err := os.MkdirAll(env.RootCAdir, os.ModePerm); err != nil {
+		return err
+	}
+	record := helpers.AuditRecord{Operation: operation, Environment: envfile, Details: details}
+	if err := helpers.AppendAuditRecord(env.RootCAdir, record); err != nil {
+		return helpers.CustomError{Message: "The operation succeeded, but could not be recorded in the audit log: " + err.Error()}
+	}
+	return nil
 }
 
 func prompt4EnvironmentValues() (EnvironmentStruct, error) {
@@ -71,5 +91,32 @@ func prompt4EnvironmentValues() (EnvironmentStruct, error) {
 		env.CertificatesConfigDir = filepath.Join(env.CertificateRootDir, env.CertificatesConfigDir)
 	}
 	env.RemoveDuplicates = true
+
+	env.SerialStrategy = strings.ToLower(helpers.GetStringValFromPrompt("Enter the serial number strategy, sequential or random (128-bit) [sequential]: "))
+	if env.SerialStrategy == "" {
+		env.SerialStrategy = SerialSequential
+	}
+	if env.SerialStrategy != SerialSequential && env.SerialStrategy != SerialRandom {
+		return EnvironmentStruct{}, helpers.CustomError{Message: fmt.Sprintf("%s %s\n", env.SerialStrategy, helpers.Red("is not a valid serial number strategy"))}
+	}
+
+	env.KeyEncryption = strings.ToLower(helpers.GetStringValFromPrompt("Which private keys should be encrypted with a passphrase, none, ca or all [ca]: "))
+	if env.KeyEncryption == "" {
+		env.KeyEncryption = KeyEncryptionCA
+	}
+	if env.KeyEncryption != KeyEncryptionNone && env.KeyEncryption != KeyEncryptionCA && env.KeyEncryption != KeyEncryptionAll {
+		return EnvironmentStruct{}, helpers.CustomError{Message: fmt.Sprintf("%s %s\n", env.KeyEncryption, helpers.Red("is not a valid key encryption policy"))}
+	}
+
+	// The CA keys can be kept on a PKCS#11 token, rather than in the CA directories
+	if module := helpers.GetStringValFromPrompt("Enter the PKCS#11 module holding the CA keys, ENTER to keep them in files: "); module != "" {
+		env.PKCS11 = &PKCS11Struct{Module: module}
+		env.PKCS11.TokenLabel = helpers.GetStringValFromPrompt("Enter the token label: ")
+		env.PKCS11.KeyLabel = helpers.GetStringValFromPrompt("Enter the CA key label, {name} being the CA name [{name}]: ")
+		env.PKCS11.PIN = helpers.GetStringValFromPrompt("Enter the token PIN source, env:VARIABLE, file:PATH or prompt [prompt]: ")
+		if err := env.PKCS11.Check(); err != nil {
+			return EnvironmentStruct{}, err
+		}
+	}
 	return env, nil
 }
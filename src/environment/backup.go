@@ -0,0 +1,482 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/environment/backup.go
+// Original timestamp: 2026/10/18 22:00
+
+// Backup and restore of a whole environment: its environment file, and the PKI tree it describes, in a .tar.gz archive
+// The archive holds a manifest with the SHA-256 checksum of every file, and can be encrypted with a passphrase
+
+package environment
+
+import (
+	"archive/tar"
+	"bytes"
+	"certificateManager/helpers"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var BackupOutput = ""
+var BackupEncrypt = false
+var BackupPassphraseFile = ""
+var RestoreRootDir = ""
+var RestoreEnvName = ""
+
+const backupVersion = 1
+
+// The archive's trees: the files directly under CertificateRootDir, then each of the environment's directories
+var backupTrees = []string{"CertificateRootDir", "RootCAdir", "ServerCertsDir", "CertificatesConfigDir"}
+
+// An encrypted archive starts with this magic, followed by the scrypt salt, the AES-GCM nonce and the sealed .tar.gz
+var backupMagic = []byte("CMBACKUP")
+
+const backupSaltSize = 16
+
+// backupManifest : manifest.json, at the root of the archive
+type backupManifest struct {
+	Version     int               `json:"Version"`
+	Environment string            `json:"Environment"`
+	CreatedAt   time.Time         `json:"CreatedAt"`
+	CreatedOn   string            `json:"CreatedOn"`
+	Layout      map[string]string `json:"Layout"` // each tree's directory, relative to CertificateRootDir
+	Files       []backupFile      `json:"Files"`
+}
+
+type backupFile struct {
+	Path   string      `json:"Path"`
+	Mode   fs.FileMode `json:"Mode"`
+	Size   int64       `json:"Size"`
+	SHA256 string      `json:"SHA256"`
+}
+
+// BackupEnvironment : archives the environment file and its PKI tree into BackupOutput (ENVNAME.tar.gz by default)
+// Workflow :
+// 1. Load the environment, lock the root CA directory so that no certificate is issued while we read it
+// 2. Read every file of each tree, recording its checksum in the manifest
+// 3. Write the manifest, the environment file and the trees in a .tar.gz archive
+// 4. Encrypt the archive, if asked to, and save it (only readable by its owner: it holds the private keys)
+func BackupEnvironment(envfile string) error {
+	var archive bytes.Buffer
+
+	if !strings.HasSuffix(envfile, ".json") {
+		envfile += ".json"
+	}
+	if BackupOutput == "" {
+		BackupOutput = strings.TrimSuffix(envfile, ".json") + ".tar.gz"
+	}
+
+	// 1. Environment
+	env, err := ReadEnvironmentFile(envfile)
+	if err != nil {
+		return err
+	}
+	envJSON, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", envfile))
+	if err != nil {
+		return err
+	}
+	unlock, err := helpers.LockDirectory(env.RootCAdir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 2. Read the trees
+	dirs := env.treeDirectories()
+	hostname, _ := os.Hostname()
+	manifest := backupManifest{Version: backupVersion, Environment: envfile, CreatedAt: time.Now(), CreatedOn: hostname,
+		Layout: make(map[string]string)}
+	contents := make(map[string][]byte)
+	for _, tree := range backupTrees {
+		manifest.Layout[tree] = env.relativeDirectory(dirs[tree])
+		if err = readTree(tree, dirs, &manifest, contents); err != nil {
+			return err
+		}
+	}
+
+	// 3. Archive
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	mStream, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = addTarFile(tw, "manifest.json", 0644, mStream); err != nil {
+		return err
+	}
+	if err = addTarFile(tw, "environment.json", 0600, envJSON); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err = addTarFile(tw, file.Path, file.Mode, contents[file.Path]); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gzw.Close(); err != nil {
+		return err
+	}
+
+	// 4. Encrypt, save
+	data := archive.Bytes()
+	if BackupEncrypt || BackupPassphraseFile != "" {
+		passphrase, err := backupPassphrase(true)
+		if err != nil {
+			return err
+		}
+		if data, err = encryptArchive(data, passphrase); err != nil {
+			return err
+		}
+	}
+	if err = os.WriteFile(BackupOutput+".tmp", data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(BackupOutput+".tmp", BackupOutput); err != nil {
+		return err
+	}
+
+	fmt.Printf("Environment %s backed up to %s (%s files)\n", helpers.Green(envfile), helpers.Green(BackupOutput),
+		helpers.White(fmt.Sprintf("%d", len(manifest.Files))))
+	return nil
+}
+
+// RestoreEnvironment : validates an archive made by BackupEnvironment, then recreates its PKI tree under RestoreRootDir,
+// And its environment file (under its original name, or RestoreEnvName), with paths rewritten to match
+// Nothing is written until the whole archive has been checked against its manifest
+func RestoreEnvironment(archivefile string) error {
+	var env EnvironmentStruct
+
+	if RestoreRootDir == "" {
+		return helpers.CustomError{Message: "You need to specify the new certificate root dir with --root"}
+	}
+	rootDir, err := filepath.Abs(RestoreRootDir)
+	if err != nil {
+		return err
+	}
+
+	// 1. Read and validate the archive
+	data, err := os.ReadFile(archivefile)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, backupMagic) {
+		passphrase, err := backupPassphrase(false)
+		if err != nil {
+			return err
+		}
+		if data, err = decryptArchive(data, passphrase); err != nil {
+			return err
+		}
+	}
+	manifest, contents, err := readArchive(data)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(contents["environment.json"], &env); err != nil {
+		return helpers.CustomError{Message: "Invalid archive: unable to parse environment.json: " + err.Error()}
+	}
+
+	// 2. The target environment file and directory must not be in use
+	envfile := manifest.Environment
+	if RestoreEnvName != "" {
+		envfile = RestoreEnvName
+	}
+	if !validEnvironmentName(envfile) {
+		return helpers.CustomError{Message: "Invalid environment name: " + helpers.Red(envfile)}
+	}
+	if !strings.HasSuffix(envfile, ".json") {
+		envfile += ".json"
+	}
+	envPath := filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", envfile)
+	if _, err = os.Stat(envPath); err == nil {
+		return helpers.CustomError{Message: "The environment file " + helpers.Red(envPath) + " already exists; use --name to restore under another name"}
+	}
+	if entries, err := os.ReadDir(rootDir); err == nil && len(entries) > 0 {
+		return helpers.CustomError{Message: "The directory " + helpers.Red(rootDir) + " is not empty"}
+	}
+
+	// 3. Recreate the tree, then the environment file pointing to it
+	env.CertificateRootDir = rootDir
+	env.RootCAdir = filepath.Join(rootDir, manifest.Layout["RootCAdir"])
+	env.ServerCertsDir = filepath.Join(rootDir, manifest.Layout["ServerCertsDir"])
+	env.CertificatesConfigDir = filepath.Join(rootDir, manifest.Layout["CertificatesConfigDir"])
+	dirs := env.treeDirectories()
+	for _, file := range manifest.Files {
+		tree, relPath, _ := strings.Cut(file.Path, "/")
+		target := filepath.Join(dirs[tree], filepath.FromSlash(relPath))
+		if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err = os.WriteFile(target, contents[file.Path], file.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if err = env.SaveEnvironmentFile(envfile); err != nil {
+		return err
+	}
+	// The archive's audit log goes on, with the restoration
+	if err = auditEnvironment(env, "env-restore", envfile, "restored from "+archivefile+", backed up on "+manifest.CreatedOn+
+		" at "+manifest.CreatedAt.Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Environment %s restored from %s into %s (%s files, backed up on %s)\n", helpers.Green(envfile), helpers.White(archivefile),
+		helpers.Green(rootDir), helpers.White(fmt.Sprintf("%d", len(manifest.Files))), helpers.White(manifest.CreatedAt.Format("2006/01/02 15:04:05")))
+	return nil
+}
+
+// treeDirectories() : the directory of each of the archive's trees
+func (e EnvironmentStruct) treeDirectories() map[string]string {
+	return map[string]string{"CertificateRootDir": filepath.Clean(e.CertificateRootDir), "RootCAdir": filepath.Clean(e.RootCAdir),
+		"ServerCertsDir": filepath.Clean(e.ServerCertsDir), "CertificatesConfigDir": filepath.Clean(e.CertificatesConfigDir)}
+}
+
+// relativeDirectory() : the directory's path relative to CertificateRootDir; a directory outside of it is restored
+// Under CertificateRootDir, with its base name
+func (e EnvironmentStruct) relativeDirectory(dir string) string {
+	rel, err := filepath.Rel(e.CertificateRootDir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return filepath.Base(dir)
+	}
+	return rel
+}
+
+// readTree() : reads the files of a tree, skipping the other trees nested in it and the lock files
+func readTree(tree string, dirs map[string]string, manifest *backupManifest, contents map[string][]byte) error {
+	root := dirs[tree]
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			for _, other := range backupTrees {
+				if other != tree && fpath == dirs[other] && fpath != root {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || helpers.IsLockFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		archivePath := tree + "/" + filepath.ToSlash(rel)
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, backupFile{Path: archivePath, Mode: info.Mode().Perm(), Size: int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:])})
+		contents[archivePath] = data
+		return nil
+	})
+}
+
+func addTarFile(tw *tar.Writer, name string, mode fs.FileMode, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: int64(mode.Perm()), Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readArchive() : reads the .tar.gz archive in memory, and checks it against its manifest:
+// Every listed file must be there, with the same size and checksum, and nothing else may be
+func readArchive(data []byte) (backupManifest, map[string][]byte, error) {
+	var manifest backupManifest
+	contents := make(map[string][]byte)
+
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return manifest, nil, helpers.CustomError{Message: "Invalid archive: " + err.Error()}
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, helpers.CustomError{Message: "Invalid archive: " + err.Error()}
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return manifest, nil, helpers.CustomError{Message: "Invalid archive: unexpected entry " + helpers.Red(hdr.Name)}
+		}
+		if contents[name], err = io.ReadAll(tr); err != nil {
+			return manifest, nil, helpers.CustomError{Message: "Invalid archive: " + err.Error()}
+		}
+	}
+
+	if err = json.Unmarshal(contents["manifest.json"], &manifest); err != nil {
+		return manifest, nil, helpers.CustomError{Message: "Invalid archive: missing or unreadable manifest.json"}
+	}
+	if manifest.Version != backupVersion {
+		return manifest, nil, helpers.CustomError{Message: fmt.Sprintf("Unsupported archive version: %d", manifest.Version)}
+	}
+	if _, ok := contents["environment.json"]; !ok {
+		return manifest, nil, helpers.CustomError{Message: "Invalid archive: missing environment.json"}
+	}
+	if !validEnvironmentName(manifest.Environment) {
+		return manifest, nil, helpers.CustomError{Message: "Invalid archive: invalid environment name " + helpers.Red(manifest.Environment)}
+	}
+
+	// The restored directories must stay under the new root directory
+	for _, tree := range backupTrees[1:] {
+		if !filepath.IsLocal(manifest.Layout[tree]) {
+			return manifest, nil, helpers.CustomError{Message: "Invalid archive: invalid " + tree + " layout " + helpers.Red(manifest.Layout[tree])}
+		}
+	}
+
+	var failures []string
+	listed := map[string]bool{"manifest.json": true, "environment.json": true}
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+		tree, relPath, _ := strings.Cut(file.Path, "/")
+		content, ok := contents[file.Path]
+		switch {
+		case !validTree(tree):
+			failures = append(failures, file.Path+": unknown tree")
+		case !filepath.IsLocal(filepath.FromSlash(relPath)):
+			failures = append(failures, file.Path+": invalid path")
+		case !ok:
+			failures = append(failures, file.Path+": missing")
+		case int64(len(content)) != file.Size:
+			failures = append(failures, file.Path+": size mismatch")
+		default:
+			if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != file.SHA256 {
+				failures = append(failures, file.Path+": checksum mismatch")
+			}
+		}
+	}
+	for name := range contents {
+		if !listed[name] {
+			failures = append(failures, name+": not in the manifest")
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return manifest, nil, helpers.CustomError{Message: "The archive failed the validation:\n" + helpers.Red(strings.Join(failures, "\n"))}
+	}
+	return manifest, contents, nil
+}
+
+// validEnvironmentName() : the environment file name is a plain file name, which stays in the configuration directory
+func validEnvironmentName(name string) bool {
+	return name != "" && name == filepath.Base(name) && filepath.IsLocal(name) && !strings.HasPrefix(name, ".")
+}
+
+func validTree(tree string) bool {
+	for _, t := range backupTrees {
+		if t == tree {
+			return true
+		}
+	}
+	return false
+}
+
+// backupPassphrase() : the archive's passphrase, from --passphrase-file, or prompted for (twice, when encrypting)
+func backupPassphrase(confirm bool) ([]byte, error) {
+	if BackupPassphraseFile != "" {
+		content, err := os.ReadFile(BackupPassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := strings.TrimRight(string(content), "\r\n")
+		if passphrase == "" {
+			return nil, helpers.CustomError{Message: "The passphrase file " + helpers.Red(BackupPassphraseFile) + " is empty"}
+		}
+		return []byte(passphrase), nil
+	}
+
+	passphrase := helpers.GetPassword("Please provide the archive's passphrase: ")
+	if passphrase == "" {
+		return nil, helpers.CustomError{Message: "The passphrase cannot be empty"}
+	}
+	if confirm && helpers.GetPassword("Please confirm the passphrase: ") != passphrase {
+		return nil, helpers.CustomError{Message: "The passphrases do not match"}
+	}
+	return []byte(passphrase), nil
+}
+
+// backupKey() : derives the AES-256 key from the passphrase
+func backupKey(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptArchive(data []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append(append(append([]byte{}, backupMagic...), salt...), nonce...)
+	return aead.Seal(header, nonce, data, backupMagic), nil
+}
+
+func decryptArchive(data []byte, passphrase []byte) ([]byte, error) {
+	data = data[len(backupMagic):]
+	if len(data) < backupSaltSize {
+		return nil, helpers.CustomError{Message: "Invalid archive: truncated encryption header"}
+	}
+	aead, err := backupKey(passphrase, data[:backupSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[backupSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, helpers.CustomError{Message: "Invalid archive: truncated encryption header"}
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], backupMagic)
+	if err != nil {
+		return nil, helpers.CustomError{Message: "Unable to decrypt the archive: wrong passphrase, or corrupted archive"}
+	}
+	return plain, nil
+}
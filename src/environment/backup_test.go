@@ -0,0 +1,165 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/environment/backup_test.go
+// Original timestamp: 2026/10/19 08:25
+
+package environment
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive : what goes into a test archive; the manifest lists the files, unless a test tampers with it
+type testArchive struct {
+	manifest backupManifest
+	files    map[string][]byte // the files of the trees, listed in the manifest
+	extra    []*tar.Header     // entries added as is, after the files
+	noList   bool              // the files are not listed in the manifest
+}
+
+func newTestArchive() *testArchive {
+	return &testArchive{
+		manifest: backupManifest{Version: backupVersion, Environment: "prod.json", CreatedAt: time.Now(), CreatedOn: "host",
+			Layout: map[string]string{"CertificateRootDir": ".", "RootCAdir": "rootCA", "ServerCertsDir": "servers", "CertificatesConfigDir": "conf"}},
+		files: map[string][]byte{
+			"RootCAdir/rootca.crt":           []byte("certificate"),
+			"RootCAdir/index.txt":            []byte("V\t271018000000Z\t\t01\tunknown\t/CN=Root CA\n"),
+			"ServerCertsDir/private/web.key": []byte("key"),
+			"CertificatesConfigDir/web.json": []byte("{}"),
+		},
+	}
+}
+
+// build() : the .tar.gz archive, as BackupEnvironment writes it
+func (a *testArchive) build(t *testing.T) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+
+	manifest := a.manifest
+	if !a.noList {
+		for name, data := range a.files {
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, backupFile{Path: name, Mode: 0644, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+		}
+	}
+	mStream, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	if err = addTarFile(tw, "manifest.json", 0644, mStream); err != nil {
+		t.Fatal(err)
+	}
+	if err = addTarFile(tw, "environment.json", 0600, []byte(`{"RemoveDuplicates": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range a.files {
+		if err = addTarFile(tw, name, 0644, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hdr := range a.extra {
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err = tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func TestReadArchive(t *testing.T) {
+	manifest, contents, err := readArchive(newTestArchive().build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Environment != "prod.json" || len(manifest.Files) != 4 {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	if string(contents["ServerCertsDir/private/web.key"]) != "key" {
+		t.Errorf("unexpected contents: %q", contents["ServerCertsDir/private/web.key"])
+	}
+}
+
+// Hostile or damaged archives are refused before anything is written
+func TestReadArchiveRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(a *testArchive)
+		want   string
+	}{
+		{"parent entry", func(a *testArchive) {
+			a.extra = append(a.extra, &tar.Header{Name: "../../.bashrc", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		}, "unexpected entry"},
+		{"absolute entry", func(a *testArchive) {
+			a.extra = append(a.extra, &tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		}, "unexpected entry"},
+		{"symbolic link", func(a *testArchive) {
+			a.extra = append(a.extra, &tar.Header{Name: "RootCAdir/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+		}, "unexpected entry"},
+		{"hard link", func(a *testArchive) {
+			a.extra = append(a.extra, &tar.Header{Name: "RootCAdir/link", Typeflag: tar.TypeLink, Linkname: "RootCAdir/rootca.crt"})
+		}, "unexpected entry"},
+		{"environment name with a directory", func(a *testArchive) { a.manifest.Environment = "../../.ssh/authorized_keys" }, "invalid environment name"},
+		{"absolute environment name", func(a *testArchive) { a.manifest.Environment = "/tmp/prod.json" }, "invalid environment name"},
+		{"parent environment name", func(a *testArchive) { a.manifest.Environment = ".." }, "invalid environment name"},
+		{"empty environment name", func(a *testArchive) { a.manifest.Environment = "" }, "invalid environment name"},
+		{"parent layout", func(a *testArchive) { a.manifest.Layout["RootCAdir"] = "../rootCA" }, "invalid RootCAdir layout"},
+		{"absolute layout", func(a *testArchive) { a.manifest.Layout["ServerCertsDir"] = "/srv" }, "invalid ServerCertsDir layout"},
+		{"version", func(a *testArchive) { a.manifest.Version = backupVersion + 1 }, "Unsupported archive version"},
+		{"unknown tree", func(a *testArchive) { a.files["OtherDir/file"] = []byte("x") }, "unknown tree"},
+		{"unlisted file", func(a *testArchive) { a.noList = true }, "not in the manifest"},
+		{"listed file missing", func(a *testArchive) {
+			a.manifest.Files = append(a.manifest.Files, backupFile{Path: "RootCAdir/serial", Size: 3})
+		}, "RootCAdir/serial: missing"},
+		{"size mismatch", func(a *testArchive) {
+			a.manifest.Files = append(a.manifest.Files, backupFile{Path: "RootCAdir/rootca.crt", Size: 1})
+		}, "size mismatch"},
+		{"checksum mismatch", func(a *testArchive) {
+			a.manifest.Files = append(a.manifest.Files, backupFile{Path: "RootCAdir/rootca.crt", Size: int64(len("certificate")), SHA256: strings.Repeat("0", 64)})
+		}, "checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestArchive()
+			tt.tamper(a)
+			if _, _, err := readArchive(a.build(t)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := readArchive([]byte("not a gzip stream")); err == nil || !strings.Contains(err.Error(), "Invalid archive") {
+		t.Errorf("not an archive: got %v", err)
+	}
+}
+
+func TestValidEnvironmentName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"prod.json": true, "prod": true, "default-Env.json": true,
+		"": false, ".": false, "..": false, ".hidden.json": false, "a/b.json": false, "../prod.json": false, "/etc/prod.json": false,
+	} {
+		if got := validEnvironmentName(name); got != want {
+			t.Errorf("%q: got %v, want %v", name, got, want)
+		}
+	}
+}
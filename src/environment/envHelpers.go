@@ -6,7 +6,9 @@
 package environment
 
 import (
+	"certificateManager/helpers"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,25 +16,114 @@ import (
 
 var EnvConfigFile string
 
+// The serial number strategies: an incrementing counter (the CA's serial file), or 128-bit random values
+const (
+	SerialSequential = "sequential"
+	SerialRandom     = "random"
+)
+
+// The private key encryption policies: which keys are stored as passphrase-protected PKCS#8
+const (
+	KeyEncryptionNone = "none"
+	KeyEncryptionCA   = "ca"
+	KeyEncryptionAll  = "all"
+)
+
 // This structure holds the basic software config but is ignored when the software is invoked with the -s flag
 // This is basically used when we store everything just like in my own internal gitea devops/certificates/ repos
 type EnvironmentStruct struct {
-	CertificateRootDir    string `json:"CertificateRootDir"`
-	RootCAdir             string `json:"RootCAdir"`
-	ServerCertsDir        string `json:"ServerCertsDir"`
-	CertificatesConfigDir string `json:"CertificatesConfigDir"`
-	RemoveDuplicates      bool   `json:"RemoveDuplicates"`
+	CertificateRootDir    string        `json:"CertificateRootDir"`
+	RootCAdir             string        `json:"RootCAdir"`
+	ServerCertsDir        string        `json:"ServerCertsDir"`
+	CertificatesConfigDir string        `json:"CertificatesConfigDir"`
+	RemoveDuplicates      bool          `json:"RemoveDuplicates"`
+	SerialStrategy        string        `json:"SerialStrategy,omitempty"` // sequential (default) or random
+	KeyEncryption         string        `json:"KeyEncryption,omitempty"`  // none, ca (default) or all
+	PKCS11                *PKCS11Struct `json:"PKCS11,omitempty"`         // the CA keys are held by a PKCS#11 token instead of files
+}
+
+// PKCS11Struct : the PKCS#11 token (HSM, smart card, SoftHSM2...) holding the CA keys
+// The token is found by its label, or by its slot number
+type PKCS11Struct struct {
+	Module     string `json:"Module"`               // path to the PKCS#11 library (.so)
+	TokenLabel string `json:"TokenLabel,omitempty"` // label of the token
+	Slot       *uint  `json:"Slot,omitempty"`       // slot number of the token, when there is no TokenLabel
+	KeyLabel   string `json:"KeyLabel,omitempty"`   // label of the CA keys, where {name} is the CA name; {name} when not set
+	PIN        string `json:"PIN,omitempty"`        // where the user PIN comes from: env:VARIABLE, file:PATH or prompt (the default)
+}
+
+// The PIN sources of a PKCS#11 token
+const (
+	PINFromEnv    = "env:"
+	PINFromFile   = "file:"
+	PINFromPrompt = "prompt"
+)
+
+// CAKeyLabel : the label of the named CA's key on the token
+func (p PKCS11Struct) CAKeyLabel(caName string) string {
+	if p.KeyLabel == "" {
+		return caName
+	}
+	return strings.ReplaceAll(p.KeyLabel, "{name}", caName)
+}
+
+// PINSource : the source of the token's PIN, prompt when not set
+func (p PKCS11Struct) PINSource() string {
+	if p.PIN == "" {
+		return PINFromPrompt
+	}
+	return p.PIN
+}
+
+// Check : the token settings are complete, and the PIN source is valid
+func (p PKCS11Struct) Check() error {
+	if p.Module == "" {
+		return helpers.CustomError{Message: "The PKCS11 settings need the Module, the path to the PKCS#11 library"}
+	}
+	if p.TokenLabel == "" && p.Slot == nil {
+		return helpers.CustomError{Message: "The PKCS11 settings need either the TokenLabel or the Slot of the token"}
+	}
+	if source := p.PINSource(); source != PINFromPrompt && !strings.HasPrefix(source, PINFromEnv) && !strings.HasPrefix(source, PINFromFile) {
+		return helpers.CustomError{Message: fmt.Sprintf("%s %s", source, helpers.Red("is not a valid PIN source (env:VARIABLE, file:PATH or prompt)"))}
+	}
+	return nil
+}
+
+// SerialNumberStrategy : the environment's serial number strategy, sequential when not set
+func (e EnvironmentStruct) SerialNumberStrategy() string {
+	if e.SerialStrategy == "" {
+		return SerialSequential
+	}
+	return strings.ToLower(e.SerialStrategy)
+}
+
+// KeyEncryptionPolicy : the environment's private key encryption policy, ca when not set: the CA keys are protected
+// Unless the environment explicitly opts out with none
+func (e EnvironmentStruct) KeyEncryptionPolicy() string {
+	if e.KeyEncryption == "" {
+		return KeyEncryptionCA
+	}
+	return strings.ToLower(e.KeyEncryption)
 }
 
 // Load the JSON environment file in the user's .config/certificatemanager directory, and store it into a data type (struct)
 func LoadEnvironmentFile() (EnvironmentStruct, error) {
+	if !strings.HasSuffix(EnvConfigFile, ".json") {
+		EnvConfigFile += ".json"
+	}
+	return ReadEnvironmentFile(EnvConfigFile)
+}
+
+// ReadEnvironmentFile : loads a given environment file from the user's .config/certificatemanager directory
+// Unlike LoadEnvironmentFile, it does not depend on the -e flag
+func ReadEnvironmentFile(envfile string) (EnvironmentStruct, error) {
 	var payload EnvironmentStruct
 	var err error
 
-	if !strings.HasSuffix(EnvConfigFile, ".json") {
-		EnvConfigFile += ".json"
+	if !strings.HasSuffix(envfile, ".json") {
+		envfile += ".json"
 	}
-	rcFile := filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", EnvConfigFile)
+	rcFile := filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", envfile)
 	jFile, err := os.ReadFile(rcFile)
 	if err != nil {
 		return EnvironmentStruct{}, err
@@ -63,7 +154,7 @@ func (e EnvironmentStruct) SaveEnvironmentFile(outputfile string) error {
 // Create a sample JSON environment file with an explanation .txt file
 func CreateSampleEnv() error {
 	var err error
-	e := EnvironmentStruct{filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", "certificates"), "rootCA", "servers", "conf", true}
+	e := EnvironmentStruct{filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", "certificates"), "rootCA", "servers", "conf", true, "sequential", "ca", nil}
 	//e := EnvironmentStruct{filepath.Join(os.Getenv("HOME"),".config","certificatemanager"),"certificates", "rootCA", "servers", "conf", true}
 
 	if err = e.SaveEnvironmentFile("sampleEnv.json"); err != nil {
@@ -75,7 +166,15 @@ func CreateSampleEnv() error {
  "RootCAdir" : "rootCA",
  "ServerCertsDir" : "servers",
  "CertificatesConfigDir" : "conf",
- "RemoveDuplicates": true  <-- should always be set to true, there is no use-case yet to set it to false
+ "RemoveDuplicates": true,  <-- should always be set to true, there is no use-case yet to set it to false
+ "SerialStrategy": "sequential",  <-- sequential (the default) or random (128-bit random serial numbers)
+ "KeyEncryption": "ca",  <-- private keys stored encrypted with a passphrase: none, ca (CA keys, the default) or all
+ "PKCS11": {  <-- optional: the CA keys are generated and kept on a PKCS#11 token (HSM, SoftHSM2...) instead of files
+   "Module": "/usr/lib/softhsm/libsofthsm2.so",  <-- the token's PKCS#11 library
+   "TokenLabel": "cm",  <-- the token is found by its label, or by its "Slot" number
+   "KeyLabel": "{name}",  <-- label of the CA keys on the token, {name} being the CA name
+   "PIN": "env:CM_PKCS11_PIN"  <-- env:VARIABLE, file:PATH or prompt (the default)
+ }
 }`
 	expFile, err := os.Create(filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", "sampleEnv-README.txt"))
 	if err != nil {
@@ -7,14 +7,24 @@ package environment
 
 import (
 	"certificateManager/helpers"
+	"encoding/json"
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// environmentRecord : an environment file, as emitted by the machine-readable (--output) formats
+type environmentRecord struct {
+	EnvironmentFile  string             `json:"EnvironmentFile"`
+	FileSize         int64              `json:"FileSize"`
+	ModificationTime time.Time          `json:"ModificationTime"`
+	Environment      *EnvironmentStruct `json:"Environment"`
+}
+
 func ListEnvironments(envdir string) error {
 	var err error
 	var dirFH *os.File
@@ -44,6 +54,21 @@ func ListEnvironments(envdir string) error {
 		return err
 	}
 
+	if helpers.StructuredOutput() {
+		records := []environmentRecord{}
+		for _, fi := range finfo {
+			record := environmentRecord{EnvironmentFile: filepath.Join(envdir, fi.Name()), FileSize: fi.Size(), ModificationTime: fi.ModTime()}
+			if jFile, err := os.ReadFile(record.EnvironmentFile); err == nil {
+				var e EnvironmentStruct
+				if json.Unmarshal(jFile, &e) == nil {
+					record.Environment = &e
+				}
+			}
+			records = append(records, record)
+		}
+		return helpers.PrintRecords(records)
+	}
+
 	fmt.Printf("Number of environment files: %s\n", helpers.Green(fmt.Sprintf("%d", len(finfo))))
 
 	t := table.NewWriter()
@@ -68,10 +93,32 @@ func ListEnvironments(envdir string) error {
 func ExplainEnvFile(envfiles []string) error {
 	oldEnvFile := EnvConfigFile
 
+	if helpers.StructuredOutput() {
+		records := []environmentRecord{}
+		for _, envfile := range envfiles {
+			if !strings.HasSuffix(envfile, ".json") {
+				envfile += ".json"
+			}
+			EnvConfigFile = envfile
+			e, err := LoadEnvironmentFile()
+			EnvConfigFile = oldEnvFile
+			if err != nil {
+				return err
+			}
+			record := environmentRecord{EnvironmentFile: filepath.Join(os.Getenv("HOME"), ".config", "certificatemanager", envfile), Environment: &e}
+			if fi, err := os.Stat(record.EnvironmentFile); err == nil {
+				record.FileSize = fi.Size()
+				record.ModificationTime = fi.ModTime()
+			}
+			records = append(records, record)
+		}
+		return helpers.PrintRecords(records)
+	}
+
 	fmt.Println("Paths are relative to Certificate root dir's path")
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Environment file", "Certificate root dir", "CA dir", "Server certificates dir", "Certificates config dir"})
+	t.AppendHeader(table.Row{"Environment file", "Certificate root dir", "CA dir", "Server certificates dir", "Certificates config dir", "Serial numbers"})
 
 	for _, envfile := range envfiles {
 		if !strings.HasSuffix(envfile, ".json") {
@@ -84,7 +131,7 @@ func ExplainEnvFile(envfiles []string) error {
 			return err
 		} else {
 			t.AppendRow([]interface{}{helpers.Green(envfile), helpers.Green(e.CertificateRootDir), helpers.Green(filepath.Base(e.RootCAdir)),
-				helpers.Green(filepath.Base(e.ServerCertsDir)), helpers.Green(filepath.Base(e.CertificatesConfigDir))})
+				helpers.Green(filepath.Base(e.ServerCertsDir)), helpers.Green(filepath.Base(e.CertificatesConfigDir)), helpers.Green(e.SerialNumberStrategy())})
 		}
 
 	}
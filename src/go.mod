@@ -5,8 +5,13 @@ go 1.22
 require (
 	github.com/jedib0t/go-pretty/v6 v6.5.4
 	github.com/jwalton/gchalk v1.3.0
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/pavlo-v-chernykh/keystore-go/v4 v4.5.0
 	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.20.0
+	golang.org/x/term v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 	software.sslmate.com/src/go-pkcs12 v0.3.0
 )
 
@@ -15,7 +20,5 @@ require (
 	github.com/jwalton/go-supportscolor v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/term v0.17.0 // indirect
 )
@@ -0,0 +1,201 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/helpers/audit.go
+// Original timestamp: 2026/10/18 23:10
+
+// The audit log: one JSON record per line, appended to audit.log in the environment's root CA directory
+// Each record holds the hash of the previous one, and its own hash: editing, removing or inserting a record
+// Breaks the chain, which VerifyAuditLog detects
+
+package helpers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const AuditLogFile = "audit.log"
+
+// The first record of a log chains to this hash
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// AuditRecord : an audit log line; the caller fills in the operation and what it applies to, the rest is ours
+type AuditRecord struct {
+	Sequence    int       `json:"Sequence"`
+	Time        time.Time `json:"Time"`
+	User        string    `json:"User"`
+	Host        string    `json:"Host"`
+	Command     string    `json:"Command"`
+	Client      string    `json:"Client,omitempty"` // the API token, for the operations requested through cm serve
+	Operation   string    `json:"Operation"`        // create, sign, renew, revoke, ocsp-signer, ssh-ca-create, ssh-sign-user, ssh-sign-host, env-add, env-remove, env-restore
+	Environment string    `json:"Environment,omitempty"`
+	Issuer      string    `json:"Issuer,omitempty"`
+	Certificate string    `json:"Certificate,omitempty"`
+	Serial      string    `json:"Serial,omitempty"`
+	Subject     string    `json:"Subject,omitempty"`
+	SHA256      string    `json:"SHA256,omitempty"` // fingerprint of the certificate
+	Details     string    `json:"Details,omitempty"`
+	PrevHash    string    `json:"PrevHash"`
+	Hash        string    `json:"Hash,omitempty"`
+}
+
+// AuditSummary : what VerifyAuditLog found
+type AuditSummary struct {
+	File     string    `json:"File"`
+	Records  int       `json:"Records"`
+	First    time.Time `json:"First,omitempty"`
+	Last     time.Time `json:"Last,omitempty"`
+	LastHash string    `json:"LastHash,omitempty"`
+	Valid    bool      `json:"Valid"`
+	Failures []string  `json:"Failures,omitempty"`
+}
+
+// computeHash() : the SHA-256 of the record's JSON form, without its own hash
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	jStream, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(jStream)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AppendAuditRecord : chains the record to the last one of the log in dir, and appends it
+// The log is locked while we do so (through its own lock file, as the directory itself might be locked by the caller),
+// So that concurrent cm processes do not fork the chain
+func AppendAuditRecord(dir string, record AuditRecord) error {
+	unlock, err := lockFile(context.Background(), dir, AuditLockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	last, err := lastAuditRecord(filepath.Join(dir, AuditLogFile))
+	if err != nil {
+		return err
+	}
+	record.Sequence = 1
+	record.PrevHash = auditGenesisHash
+	if last != nil {
+		record.Sequence = last.Sequence + 1
+		record.PrevHash = last.Hash
+	}
+	record.Time = time.Now().UTC()
+	record.Host, _ = os.Hostname()
+	record.Command = strings.Join(os.Args, " ")
+	if u, err := user.Current(); err == nil {
+		record.User = u.Username
+	} else {
+		record.User = os.Getenv("USER")
+	}
+	if record.Hash, err = record.computeHash(); err != nil {
+		return err
+	}
+
+	jStream, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, AuditLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return CustomError{Message: "Unable to open the audit log: " + err.Error()}
+	}
+	defer logFile.Close()
+	if _, err = logFile.Write(append(jStream, '\n')); err != nil {
+		return CustomError{Message: "Unable to write the audit log: " + err.Error()}
+	}
+	return logFile.Sync()
+}
+
+// lastAuditRecord() : the last record of the log, or nil if there is none yet
+func lastAuditRecord(logPath string) (*AuditRecord, error) {
+	var last *AuditRecord
+
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer logFile.Close()
+
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := AuditRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, CustomError{Message: "The audit log " + logPath + " is corrupted (run cm audit verify): " + err.Error()}
+		}
+		last = &record
+	}
+	return last, scanner.Err()
+}
+
+// VerifyAuditLog : checks every record of the log in dir: its JSON form, its sequence number, its hash, and its link to
+// The previous record
+func VerifyAuditLog(dir string) (AuditSummary, error) {
+	summary := AuditSummary{File: filepath.Join(dir, AuditLogFile), Valid: true}
+
+	logFile, err := os.Open(summary.File)
+	if err != nil {
+		return summary, err
+	}
+	defer logFile.Close()
+
+	prevHash := auditGenesisHash
+	expected := 1
+	line := 0
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := AuditRecord{}
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&record); err != nil {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("line %d: invalid record: %v", line, err))
+			prevHash = ""
+			continue
+		}
+
+		if record.Sequence != expected {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("line %d: sequence %d, expected %d", line, record.Sequence, expected))
+		}
+		if hash, err := record.computeHash(); err != nil || hash != record.Hash {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("line %d (record %d): the record was modified, its hash does not match", line, record.Sequence))
+		}
+		if prevHash != "" && record.PrevHash != prevHash {
+			summary.Failures = append(summary.Failures, fmt.Sprintf("line %d (record %d): broken chain, the previous record was modified or removed", line, record.Sequence))
+		}
+		if summary.Records == 0 {
+			summary.First = record.Time
+		}
+		summary.Records++
+		summary.Last = record.Time
+		summary.LastHash = record.Hash
+		prevHash = record.Hash
+		expected = record.Sequence + 1
+	}
+	if err = scanner.Err(); err != nil {
+		return summary, err
+	}
+	summary.Valid = len(summary.Failures) == 0
+	return summary, nil
+}
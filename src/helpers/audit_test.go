@@ -0,0 +1,126 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/helpers/audit_test.go
+// Original timestamp: 2026/10/19 11:45
+
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAuditLog() : a directory whose audit log holds five records, and its lines
+func testAuditLog(t *testing.T) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 1; i <= 5; i++ {
+		if err := AppendAuditRecord(dir, AuditRecord{Operation: "create", Environment: "test.json", Certificate: fmt.Sprintf("web%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jFile, err := os.ReadFile(filepath.Join(dir, AuditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, strings.Split(strings.TrimSuffix(string(jFile), "\n"), "\n")
+}
+
+// auditLine() : the record of the line, edited, and re-hashed if asked to
+func auditLine(t *testing.T, line string, edit func(*AuditRecord), rehash bool) string {
+	t.Helper()
+	var record AuditRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatal(err)
+	}
+	edit(&record)
+	if rehash {
+		record.Hash, _ = record.computeHash()
+	}
+	jStream, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(jStream)
+}
+
+// Each record follows the previous one, and the first one chains to the genesis hash
+func TestAppendAuditRecord(t *testing.T) {
+	_, lines := testAuditLog(t)
+	prevHash := auditGenesisHash
+	for i, line := range lines {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Sequence != i+1 || record.PrevHash != prevHash || record.Operation != "create" || record.Time.IsZero() {
+			t.Errorf("line %d: got %+v", i+1, record)
+		}
+		if hash, err := record.computeHash(); err != nil || hash != record.Hash {
+			t.Errorf("line %d: hash %s, want %s", i+1, record.Hash, hash)
+		}
+		prevHash = record.Hash
+	}
+}
+
+// Editing, removing or inserting a record is detected, and so is a field that is not part of the records
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, lines []string) []string
+		want   []string
+	}{
+		{"valid chain", func(t *testing.T, lines []string) []string { return lines }, nil},
+		{"edited record", func(t *testing.T, lines []string) []string {
+			lines[2] = auditLine(t, lines[2], func(r *AuditRecord) { r.Certificate = "evil" }, false)
+			return lines
+		}, []string{"line 3 (record 3): the record was modified, its hash does not match"}},
+		{"edited and re-hashed record", func(t *testing.T, lines []string) []string {
+			lines[2] = auditLine(t, lines[2], func(r *AuditRecord) { r.Certificate = "evil" }, true)
+			return lines
+		}, []string{"line 4 (record 4): broken chain"}},
+		{"deleted middle record", func(t *testing.T, lines []string) []string {
+			return append(lines[:2], lines[3:]...)
+		}, []string{"line 3: sequence 4, expected 3", "line 3 (record 4): broken chain"}},
+		{"inserted record", func(t *testing.T, lines []string) []string {
+			forged := auditLine(t, lines[1], func(r *AuditRecord) { r.Sequence, r.PrevHash, r.Certificate = 3, r.Hash, "evil" }, true)
+			return append(lines[:2], append([]string{forged}, lines[2:]...)...)
+		}, []string{"line 4: sequence 3, expected 4", "line 4 (record 3): broken chain"}},
+		{"unknown field", func(t *testing.T, lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `{"Sequence"`, `{"Approved":true,"Sequence"`, 1)
+			return lines
+		}, []string{`line 2: invalid record: json: unknown field "Approved"`}},
+	}
+	for _, tt := range tests {
+		dir, lines := testAuditLog(t)
+		lines = tt.tamper(t, lines)
+		if err := os.WriteFile(filepath.Join(dir, AuditLogFile), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		summary, err := VerifyAuditLog(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if summary.Valid != (len(tt.want) == 0) {
+			t.Errorf("%s: valid %v, failures %q", tt.name, summary.Valid, summary.Failures)
+		}
+		failures := strings.Join(summary.Failures, "\n")
+		for _, want := range tt.want {
+			if !strings.Contains(failures, want) {
+				t.Errorf("%s: got the failures %q, want %q", tt.name, summary.Failures, want)
+			}
+		}
+		if len(tt.want) == 0 && (summary.Records != 5 || summary.LastHash == "" || summary.First.After(summary.Last)) {
+			t.Errorf("%s: got %+v", tt.name, summary)
+		}
+	}
+
+	if _, err := VerifyAuditLog(t.TempDir()); err == nil {
+		t.Error("a missing audit log was verified")
+	}
+}
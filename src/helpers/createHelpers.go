@@ -72,13 +72,34 @@ func GetStringSliceFromPrompt(prompt string) []string {
 	return slice
 }
 
-func GetKeyUsage() []string {
+// GetKeyUsage : offers the certificate profiles first; a profile sets the key usage and extended key usage itself
+// Returns the chosen profile, or the key usage values entered one by one when no profile is chosen
+func GetKeyUsage(profiles []string) (string, []string) {
 	var keys []string
 	inputScanner := bufio.NewScanner(os.Stdin)
 	ku := []string{"decipher only", "encipher only", "crl sign", "cert sign", "key agreement",
-		"data encipherment", "key encipherment", "content commitment", "digital signature", "CADEFAULTS", "CERTDEFAULTS"}
+		"data encipherment", "key encipherment", "content commitment", "digital signature"}
 	inputs := []string{}
 
+	if len(profiles) > 0 {
+		fmt.Println("The available profiles are:")
+		for _, p := range profiles {
+			fmt.Printf("'%s' ", White(p))
+		}
+		fmt.Println()
+		for {
+			fmt.Print("Please enter a profile from the above list, just press ENTER to pick the key usage values yourself : ")
+			inputScanner.Scan()
+			input := inputScanner.Text()
+			if input == "" {
+				break
+			}
+			if valueInList(input, profiles) {
+				return input, nil
+			}
+		}
+	}
+
 	fmt.Println("The valid key usage values are:")
 	for i, j := range ku {
 		if i%5 == 0 && i != 0 {
@@ -86,8 +107,7 @@ func GetKeyUsage() []string {
 		}
 		fmt.Printf("'%s' ", White(j))
 	}
-	fmt.Println("\nCADEFAULTS is a catch-all for default values of a root CA")
-	fmt.Println("CERTDEFAULTS is a catch-all for default values of a standard certificate")
+	fmt.Println()
 	for {
 		input := ""
 		fmt.Print("Please enter a value from the above list, just press ENTER to end : ")
@@ -96,23 +116,14 @@ func GetKeyUsage() []string {
 		if input == "" {
 			break
 		}
-		if input == "CADEFAULTS" || input == "CERTDEFAULTS" {
-			if input == "CADEFAULTS" {
-				inputs = append(inputs, "digital signature", "cert sign", "crl sign")
-			} else {
-				inputs = append(inputs, "digital signature", "key encipherment",
-					"data encipherment", "key agreement")
-			}
-		} else {
-			if valueInList(input, ku) {
-				inputs = append(inputs, input)
-			}
+		if valueInList(input, ku) {
+			inputs = append(inputs, input)
 		}
 	}
 	// if the array is empty, we return a default value
 	if len(inputs) == 0 {
 		keys = []string{"digital signature"}
-		return keys
+		return "", keys
 	}
 	// now we need to ensure that we do not have any duplicates
 	s := make([]string, 0, len(inputs))
@@ -125,7 +136,7 @@ func GetKeyUsage() []string {
 		}
 	}
 	keys = s
-	return keys
+	return "", keys
 }
 
 func valueInList(in string, list []string) bool {
@@ -0,0 +1,144 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/helpers/lock.go
+// Original timestamp: 2026/10/18 21:00
+
+// Advisory locking of a directory, so that two cm processes sharing a PKI (NFS, git checkout...) do not hand out
+// The same serial number or overwrite each other's index.txt updates
+
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LockTimeout : how long we wait for another process to release a lock
+var LockTimeout = 30 * time.Second
+
+// ErrLocked : the lock is held by another process, and was not released in time
+var ErrLocked = errors.New("directory locked by another process")
+
+// The lock files, within RootCAdir and the CA directories: the directory lock, and the audit log's own lock, so that
+// The audit log can be appended to while the CA directory is locked
+const (
+	DirectoryLockFile = ".lock"
+	AuditLockFile     = ".audit.lock"
+)
+
+// fileLock : a lock file of this process; its mutex keeps the goroutines of this process out, and the flock(2) on
+// The file keeps the other processes out. A lock is not reentrant: taking it twice waits for the first to be released
+type fileLock struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// The lock files of this process, by absolute path; locksMutex only guards the map, it is never held while waiting
+var fileLocks = make(map[string]*fileLock)
+var locksMutex sync.Mutex
+
+// LockDirectory : takes an exclusive advisory lock on the directory (through its .lock file), waiting up to LockTimeout
+// The returned function releases the lock
+func LockDirectory(dir string) (func(), error) {
+	return LockDirectoryContext(context.Background(), dir)
+}
+
+// LockDirectoryContext : same as LockDirectory, but also gives up when the context is done
+func LockDirectoryContext(ctx context.Context, dir string) (func(), error) {
+	return lockFile(ctx, dir, DirectoryLockFile)
+}
+
+// IsLockFile : whether the file is one of the lock files, which are not part of the PKI
+func IsLockFile(name string) bool {
+	return name == DirectoryLockFile || name == AuditLockFile
+}
+
+// lockFile() : takes the named lock file of the directory, first within this process, then against the other processes
+// Both waits share the LockTimeout deadline, and give up when the context is done
+func lockFile(ctx context.Context, dir string, name string) (func(), error) {
+	var err error
+
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	locksMutex.Lock()
+	lock, ok := fileLocks[path]
+	if !ok {
+		lock = &fileLock{}
+		fileLocks[path] = lock
+	}
+	locksMutex.Unlock()
+
+	deadline := time.Now().Add(LockTimeout)
+	for !lock.mutex.TryLock() {
+		if err = waitForLock(ctx, deadline, path, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		lock.mutex.Unlock()
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		lock.mutex.Unlock()
+		return nil, CustomError{Message: "Unable to open the lock file: " + err.Error()}
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			err = CustomError{Message: "Unable to lock " + dir + ": " + err.Error()}
+		} else {
+			err = waitForLock(ctx, deadline, path, dir)
+		}
+		if err != nil {
+			file.Close()
+			lock.mutex.Unlock()
+			return nil, err
+		}
+	}
+
+	// Whoever waits for the lock will know who holds it
+	hostname, _ := os.Hostname()
+	_ = file.Truncate(0)
+	_, _ = file.WriteAt([]byte(fmt.Sprintf("pid %d on %s, since %s\n", os.Getpid(), hostname, time.Now().Format("2006/01/02 15:04:05"))), 0)
+	lock.file = file
+
+	return func() {
+		_ = lock.file.Truncate(0)
+		_ = syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+		lock.file.Close()
+		lock.file = nil
+		lock.mutex.Unlock()
+	}, nil
+}
+
+// waitForLock() : waits a bit before trying the lock again, unless the deadline is past or the context is done
+func waitForLock(ctx context.Context, deadline time.Time, path string, dir string) error {
+	if time.Now().After(deadline) {
+		holder, _ := os.ReadFile(path)
+		if len(strings.TrimSpace(string(holder))) == 0 {
+			holder = []byte("another process")
+		}
+		return CustomError{Message: fmt.Sprintf("Timed out after %s waiting for the lock on %s, held by %s. Retry later, or raise --lock-timeout",
+			LockTimeout, Red(dir), White(strings.TrimSpace(string(holder)))), Err: ErrLocked}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+	}
+	return nil
+}
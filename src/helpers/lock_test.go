@@ -0,0 +1,116 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/helpers/lock_test.go
+// Original timestamp: 2026/10/19 06:30
+
+package helpers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Two goroutines of the same process never hold the same directory lock at once
+func TestLockDirectoryExcludesGoroutines(t *testing.T) {
+	dir := t.TempDir()
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := LockDirectory(dir)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			for {
+				max := atomic.LoadInt32(&maxHolders)
+				if n <= max || atomic.CompareAndSwapInt32(&maxHolders, max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Fatalf("%d goroutines held the lock at once", maxHolders)
+	}
+}
+
+// A contended directory does not hold up the locks of the other directories
+func TestLockDirectoryOtherDirectories(t *testing.T) {
+	busy, other := t.TempDir(), t.TempDir()
+	unlock, err := LockDirectory(busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	waiting := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, err := LockDirectoryContext(ctx, busy)
+		waiting <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	unlockOther, err := LockDirectory(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("locking another directory took %s", elapsed)
+	}
+
+	cancel()
+	if err = <-waiting; !errors.Is(err, context.Canceled) {
+		t.Fatalf("waiting for a held lock: got %v, want context.Canceled", err)
+	}
+}
+
+// The audit log can be appended to while its directory is locked
+func TestAuditWhileDirectoryLocked(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := LockDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	saved := LockTimeout
+	LockTimeout = time.Second
+	defer func() { LockTimeout = saved }()
+	if err = AppendAuditRecord(dir, AuditRecord{Operation: "create", Certificate: "web"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The lock times out with ErrLocked
+func TestLockDirectoryTimeout(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := LockDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	saved := LockTimeout
+	LockTimeout = 200 * time.Millisecond
+	defer func() { LockTimeout = saved }()
+	if _, err = LockDirectory(dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+}
@@ -21,14 +21,20 @@ const (
 )
 
 // CustomError implements the error interface
+// Err, when set, is the kind of error (see the Err... values of the cert package), for errors.Is()
 type CustomError struct {
 	Message string
+	Err     error
 }
 
 func (e CustomError) Error() string {
 	return e.Message
 }
 
+func (e CustomError) Unwrap() error {
+	return e.Err
+}
+
 // COLOR FUNCTIONS
 // ================
 func Red(sentence string) string {
@@ -106,7 +112,7 @@ func GetPassword(prompt string) string {
 
 	// Restore it in the event of an interrupt.
 	// CITATION: Konstantin Shaposhnikov - https://groups.google.com/forum/#!topic/golang-nuts/kTVAbtee9UA
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	go func() {
 		<-c
@@ -0,0 +1,229 @@
+// certificateManager
+// Written by J.F. Gratton <jean-francois@famillegratton.net>
+// Original filename: src/helpers/output.go
+// Original timestamp: 2026/10/18 17:05
+
+// Machine-readable output: the commands build a slice of records, which are then emitted as JSON, YAML or CSV
+
+package helpers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jwalton/gchalk"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OutputFormat : text (the default, human-readable tables), json, yaml or csv
+var OutputFormat = "text"
+
+// SetOutputFormat : validates the requested format, and disables the colours when they would get in the way:
+// when stdout is not a terminal, or when the output is meant for another program
+func SetOutputFormat(format string) error {
+	format = strings.ToLower(format)
+	switch format {
+	case "", "text":
+		format = "text"
+	case "json", "yaml", "csv":
+	case "yml":
+		format = "yaml"
+	default:
+		return CustomError{Message: "Unknown output format: " + Red(format) + " (valid formats are text, json, yaml and csv)"}
+	}
+	OutputFormat = format
+
+	if OutputFormat != "text" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		gchalk.SetLevel(gchalk.LevelNone)
+	}
+	return nil
+}
+
+// StructuredOutput : true when the records should be printed instead of the tables
+func StructuredOutput() bool {
+	return OutputFormat != "text"
+}
+
+// PrintRecords : emits the records (a slice of structs) on stdout in the selected format
+func PrintRecords(records interface{}) error {
+	switch OutputFormat {
+	case "json":
+		jStream, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jStream))
+	case "yaml":
+		return printYAML(records)
+	case "csv":
+		return printCSV(records)
+	default:
+		return CustomError{Message: "PrintRecords() called without a structured output format"}
+	}
+	return nil
+}
+
+// printYAML : the records go through JSON first, so that both formats share the same field names and order
+func printYAML(records interface{}) error {
+	var node yaml.Node
+
+	jStream, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(jStream, &node); err != nil {
+		return err
+	}
+	resetYAMLStyle(&node)
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&node); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// resetYAMLStyle : JSON is parsed as flow-style YAML; we want the usual block style
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// printCSV : one header line, then one line per record
+// Nested structs are flattened (Parent.Field), and lists are joined with semicolons
+func printCSV(records interface{}) error {
+	value := reflect.ValueOf(records)
+	if value.Kind() != reflect.Slice {
+		return CustomError{Message: "CSV output expects a list of records"}
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(csvHeader(value.Type().Elem(), "")); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := w.Write(csvRow(value.Index(i))); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvFlattened : the types that are expanded into several columns
+func csvFlattened(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Types with their own text form (time.Time, big.Int...) stay in a single column
+	if t.Implements(stringerType) || reflect.PointerTo(t).Implements(stringerType) {
+		return false
+	}
+	return t.Kind() == reflect.Struct
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// csvFieldName : the column name of a field, taken from its JSON tag; an empty name means that the field is skipped
+func csvFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func csvHeader(t reflect.Type, prefix string) []string {
+	var header []string
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := csvFieldName(field)
+		if name == "" {
+			continue
+		}
+		switch {
+		case field.Anonymous && csvFlattened(field.Type):
+			header = append(header, csvHeader(field.Type, prefix)...)
+		case csvFlattened(field.Type):
+			header = append(header, csvHeader(field.Type, prefix+name+".")...)
+		default:
+			header = append(header, prefix+name)
+		}
+	}
+	return header
+}
+
+func csvRow(value reflect.Value) []string {
+	var row []string
+
+	// A nil pointer still needs its columns, empty
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return make([]string, len(csvHeader(value.Type(), "")))
+		}
+		value = value.Elem()
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if csvFieldName(field) == "" {
+			continue
+		}
+		if csvFlattened(field.Type) {
+			row = append(row, csvRow(value.Field(i))...)
+		} else {
+			row = append(row, csvValue(value.Field(i)))
+		}
+	}
+	return row
+}
+
+func csvValue(value reflect.Value) string {
+	if (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && value.IsNil() {
+		return ""
+	}
+	switch v := value.Interface().(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("%X", value.Bytes())
+		}
+		var values []string
+		for i := 0; i < value.Len(); i++ {
+			values = append(values, csvValue(value.Index(i)))
+		}
+		return strings.Join(values, ";")
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return ""
+		}
+		return csvValue(value.Elem())
+	}
+	return fmt.Sprintf("%v", value.Interface())
+}